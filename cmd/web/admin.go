@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/models"
+	"snippetbox.jonnevuorela.com/internal/validator"
+)
+
+const (
+	auditPageLimit   = 100
+	auditExportLimit = 10000
+)
+
+type adminIdForm struct {
+	Id int `form:"id"`
+}
+
+type adminUserRoleForm struct {
+	Id   int         `form:"id"`
+	Role models.Role `form:"role"`
+}
+
+type adminAuditForm struct {
+	Action              string `form:"action"`
+	Actor               string `form:"actor"`
+	From                string `form:"from"`
+	To                  string `form:"to"`
+	Format              string `form:"format"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) admin(writer http.ResponseWriter, request *http.Request) {
+	app.render(writer, request, http.StatusOK, "admin.tmpl", app.newTemplateData(request))
+}
+
+// adminSnippets lists every snippet, including private ones, so that
+// moderators can find content to remove.
+func (app *application) adminSnippets(writer http.ResponseWriter, request *http.Request) {
+	snippets, err := app.snippets.All(request.Context())
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	data := app.newTemplateData(request)
+	data.Snippets = snippets
+
+	app.render(writer, request, http.StatusOK, "admin_snippets.tmpl", data)
+}
+
+func (app *application) adminSnippetDeletePost(writer http.ResponseWriter, request *http.Request) {
+	var form adminIdForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil || form.Id < 1 {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	err = app.snippets.Delete(request.Context(), form.Id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(writer)
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	app.audit(request, models.AuditSnippetDelete, app.authenticatedUserId(request), map[string]string{"snippet_id": strconv.Itoa(form.Id)})
+
+	app.sessionManager.Put(request.Context(), "flash", "Snippet deleted.")
+
+	http.Redirect(writer, request, "/admin/snippets", http.StatusSeeOther)
+}
+
+func (app *application) adminUsers(writer http.ResponseWriter, request *http.Request) {
+	users, err := app.users.List(request.Context())
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	user, err := app.users.Get(request.Context(), app.authenticatedUserId(request))
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	data := app.newTemplateData(request)
+	data.User = user
+	data.Users = users
+	data.Roles = models.Roles
+
+	app.render(writer, request, http.StatusOK, "admin_users.tmpl", data)
+}
+
+// adminUser returns the user an admin action is for. Admins can't act on
+// their own account, so that they can't lock themselves out.
+func (app *application) adminUser(writer http.ResponseWriter, request *http.Request, id int) (*models.User, bool) {
+	if id < 1 || id == app.authenticatedUserId(request) {
+		app.clientError(writer, http.StatusBadRequest)
+		return nil, false
+	}
+
+	user, err := app.users.Get(request.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(writer)
+		} else {
+			app.serverError(writer, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// adminUserDisablePost disables an account and logs it out everywhere.
+func (app *application) adminUserDisablePost(writer http.ResponseWriter, request *http.Request) {
+	var form adminIdForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := app.adminUser(writer, request, form.Id)
+	if !ok {
+		return
+	}
+
+	err = app.users.SetDisabled(request.Context(), user.Id, true)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	err = app.userSessions.DeleteAll(request.Context(), user.Id)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.audit(request, models.AuditUserDisable, app.authenticatedUserId(request), map[string]string{"user_id": strconv.Itoa(user.Id)})
+
+	app.sessionManager.Put(request.Context(), "flash", user.Name+"'s account has been disabled.")
+
+	http.Redirect(writer, request, "/admin/users", http.StatusSeeOther)
+}
+
+func (app *application) adminUserEnablePost(writer http.ResponseWriter, request *http.Request) {
+	var form adminIdForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := app.adminUser(writer, request, form.Id)
+	if !ok {
+		return
+	}
+
+	err = app.users.SetDisabled(request.Context(), user.Id, false)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.audit(request, models.AuditUserEnable, app.authenticatedUserId(request), map[string]string{"user_id": strconv.Itoa(user.Id)})
+
+	app.sessionManager.Put(request.Context(), "flash", user.Name+"'s account has been enabled.")
+
+	http.Redirect(writer, request, "/admin/users", http.StatusSeeOther)
+}
+
+func (app *application) adminUserRolePost(writer http.ResponseWriter, request *http.Request) {
+	var form adminUserRoleForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil || !form.Role.Valid() {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := app.adminUser(writer, request, form.Id)
+	if !ok {
+		return
+	}
+
+	err = app.users.SetRole(request.Context(), user.Id, form.Role)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.audit(request, models.AuditUserRoleChange, app.authenticatedUserId(request), map[string]string{"user_id": strconv.Itoa(user.Id), "from": string(user.Role), "to": string(form.Role)})
+
+	app.sessionManager.Put(request.Context(), "flash", user.Name+" is now "+string(form.Role)+".")
+
+	http.Redirect(writer, request, "/admin/users", http.StatusSeeOther)
+}
+
+// adminAudit shows the audit log, filtered by action, actor email and date
+// range, or exports it as CSV or JSON with the same filters.
+func (app *application) adminAudit(writer http.ResponseWriter, request *http.Request) {
+	var form adminAuditForm
+
+	err := app.formDecoder.Decode(&form, request.URL.Query())
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	filter := models.AuditFilter{Action: form.Action, Limit: auditPageLimit}
+
+	form.CheckField(form.Action == "" || validator.PermittedValue(form.Action, models.AuditActions...), "action", "This field must be a known action")
+	form.CheckField(validator.PermittedValue(form.Format, "", "csv", "json"), "format", "This field must be csv or json")
+
+	if form.From != "" {
+		filter.Since, err = time.Parse(time.DateOnly, form.From)
+		form.CheckField(err == nil, "from", "This field must be a date")
+	}
+	if form.To != "" {
+		filter.Until, err = time.Parse(time.DateOnly, form.To)
+		form.CheckField(err == nil, "to", "This field must be a date")
+		// The range includes the whole of the last day.
+		filter.Until = filter.Until.AddDate(0, 0, 1)
+	}
+
+	if form.Actor != "" {
+		user, err := app.users.GetByEmail(request.Context(), form.Actor)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(writer, err)
+			return
+		}
+		form.CheckField(user != nil, "actor", "There is no user with this email address")
+		if user != nil {
+			filter.ActorId = user.Id
+		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(request)
+		data.Form = form
+		data.AuditActions = models.AuditActions
+		app.render(writer, request, http.StatusUnprocessableEntity, "admin_audit.tmpl", data)
+		return
+	}
+
+	if form.Format != "" {
+		filter.Limit = auditExportLimit
+	}
+
+	events, err := app.auditEvents.List(request.Context(), filter)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	switch form.Format {
+	case "csv":
+		err = writeAuditCSV(writer, events)
+		if err != nil {
+			app.errorLog.Print(err)
+		}
+	case "json":
+		writer.Header().Set("Content-Disposition", `attachment; filename="audit.json"`)
+		writeJSON(writer, http.StatusOK, events)
+	default:
+		data := app.newTemplateData(request)
+		data.Form = form
+		data.AuditActions = models.AuditActions
+		data.AuditEvents = events
+		app.render(writer, request, http.StatusOK, "admin_audit.tmpl", data)
+	}
+}
+
+func writeAuditCSV(w http.ResponseWriter, events []*models.AuditEvent) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+	w.Header().Set("Cache-Control", "no-store")
+
+	cw := csv.NewWriter(w)
+
+	err := cw.Write([]string{"id", "created", "action", "actor_id", "actor_email", "ip", "user_agent", "details"})
+	if err != nil {
+		return err
+	}
+
+	for _, e := range events {
+		details, err := json.Marshal(e.Details)
+		if err != nil {
+			return err
+		}
+
+		err = cw.Write([]string{
+			strconv.Itoa(e.Id),
+			e.Created.UTC().Format(time.RFC3339),
+			e.Action,
+			strconv.Itoa(e.ActorId),
+			csvSafe(e.ActorEmail),
+			csvSafe(e.IP),
+			csvSafe(e.UserAgent),
+			csvSafe(string(details)),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvSafe stops a user-supplied value, such as a user agent, being run as a
+// formula when the export is opened in a spreadsheet.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"snippetbox.jonnevuorela.com/internal/models"
+	"snippetbox.jonnevuorela.com/internal/validator"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// collectionSlugMaxChars is how much of a collection's title goes into its
+// slug.
+const collectionSlugMaxChars = 60
+
+type collectionForm struct {
+	Title               string `form:"title"`
+	Description         string `form:"description"`
+	Public              bool   `form:"public"`
+	validator.Validator `form:"-"`
+}
+
+type collectionSnippetForm struct {
+	SnippetId int    `form:"snippet_id"`
+	Direction string `form:"direction"`
+}
+
+type snippetCollectForm struct {
+	Collection string `form:"collection"`
+}
+
+func (form *collectionForm) validate(user *models.User) {
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
+	form.CheckField(validator.MaxChar(form.Title, 100), "title", "This field cannot be more than 100 characters long")
+	form.CheckField(validator.MaxChar(form.Description, 1000), "description", "This field cannot be more than 1000 characters long")
+	form.CheckField(!form.Public || user.EmailVerified(), "public", "You need to verify your email address before publishing public collections")
+}
+
+// slugify makes a slug out of a collection's title: lower case letters and
+// digits, with a hyphen between words.
+func slugify(title string) string {
+	var b strings.Builder
+	hyphen := false
+
+	for _, r := range strings.ToLower(title) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+		if b.Len() >= collectionSlugMaxChars {
+			break
+		}
+	}
+
+	if b.Len() == 0 {
+		return "collection"
+	}
+	return b.String()
+}
+
+// insertCollection adds a collection with a slug made from its title. If the
+// slug is taken, a random suffix is added until it isn't. The slug is
+// shortened to make room for the suffix, so that a long title doesn't lose it.
+func (app *application) insertCollection(ctx context.Context, userId int, form collectionForm) (string, error) {
+	slug := slugify(form.Title)
+	base := strings.TrimRight(slug[:min(len(slug), collectionSlugMaxChars-7)], "-")
+
+	for attempt := 0; ; attempt++ {
+		s := slug
+		if attempt > 0 {
+			suffix := make([]byte, 3)
+			_, err := rand.Read(suffix)
+			if err != nil {
+				return "", err
+			}
+			s = base + "-" + hex.EncodeToString(suffix)
+		}
+
+		_, err := app.collections.Insert(ctx, userId, s, form.Title, form.Description, form.Public)
+		if errors.Is(err, models.ErrDuplicateSlug) && attempt < 5 {
+			continue
+		}
+		return s, err
+	}
+}
+
+// canViewCollection reports whether the request may see the collection: it is
+// public, or the user owns it or is a moderator.
+func (app *application) canViewCollection(request *http.Request, collection *models.Collection) bool {
+	return collection.Public || app.isOwner(request, collection.UserId) || app.role(request).AtLeast(models.RoleModerator)
+}
+
+// collectionFromParams returns the collection named by the :slug route
+// parameter, if the request may see it.
+func (app *application) collectionFromParams(writer http.ResponseWriter, request *http.Request) (*models.Collection, bool) {
+	params := httprouter.ParamsFromContext(request.Context())
+
+	collection, err := app.collections.GetBySlug(request.Context(), params.ByName("slug"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(writer)
+		} else {
+			app.serverError(writer, err)
+		}
+		return nil, false
+	}
+
+	if !app.canViewCollection(request, collection) {
+		app.notFound(writer)
+		return nil, false
+	}
+
+	return collection, true
+}
+
+// ownCollection is collectionFromParams for requests that change the
+// collection, which only its owner can do.
+func (app *application) ownCollection(writer http.ResponseWriter, request *http.Request) (*models.Collection, bool) {
+	collection, ok := app.collectionFromParams(writer, request)
+	if !ok {
+		return nil, false
+	}
+
+	if !app.isOwner(request, collection.UserId) {
+		app.clientError(writer, http.StatusForbidden)
+		return nil, false
+	}
+
+	return collection, true
+}
+
+func collectionURL(collection *models.Collection) string {
+	return "/c/" + collection.Slug
+}
+
+// collectionView shows a collection's snippets in order. Snippets the viewer
+// can't see, such as the owner's private ones, are left out.
+func (app *application) collectionView(writer http.ResponseWriter, request *http.Request) {
+	collection, ok := app.collectionFromParams(writer, request)
+	if !ok {
+		return
+	}
+
+	snippets, err := app.collections.Snippets(request.Context(), collection.Id)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	visible := []*models.Snippet{}
+	for _, s := range snippets {
+		if app.canView(request, s) {
+			visible = append(visible, s)
+		}
+	}
+
+	author, err := app.users.Get(request.Context(), collection.UserId)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	data := app.newTemplateData(request)
+	data.Collection = collection
+	data.Author = author
+	data.Snippets = visible
+
+	app.render(writer, request, http.StatusOK, "collection.tmpl", data)
+}
+
+func (app *application) userCollections(writer http.ResponseWriter, request *http.Request) {
+	collections, err := app.collections.ByOwner(request.Context(), app.authenticatedUserId(request))
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	data := app.newTemplateData(request)
+	data.Collections = collections
+
+	app.render(writer, request, http.StatusOK, "collections.tmpl", data)
+}
+
+func (app *application) collectionCreate(writer http.ResponseWriter, request *http.Request) {
+	user, err := app.users.Get(request.Context(), app.authenticatedUserId(request))
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	data := app.newTemplateData(request)
+	data.Form = collectionForm{Public: user.EmailVerified()}
+
+	app.render(writer, request, http.StatusOK, "collection_form.tmpl", data)
+}
+
+func (app *application) collectionCreatePost(writer http.ResponseWriter, request *http.Request) {
+	user, err := app.users.Get(request.Context(), app.authenticatedUserId(request))
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	var form collectionForm
+
+	err = app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	form.validate(user)
+
+	if !form.Valid() {
+		data := app.newTemplateData(request)
+		data.Form = form
+		app.render(writer, request, http.StatusUnprocessableEntity, "collection_form.tmpl", data)
+		return
+	}
+
+	slug, err := app.insertCollection(request.Context(), app.authenticatedUserId(request), form)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.sessionManager.Put(request.Context(), "flash", "Collection created.")
+
+	http.Redirect(writer, request, "/c/"+slug, http.StatusSeeOther)
+}
+
+func (app *application) collectionEdit(writer http.ResponseWriter, request *http.Request) {
+	collection, ok := app.ownCollection(writer, request)
+	if !ok {
+		return
+	}
+
+	data := app.newTemplateData(request)
+	data.Collection = collection
+	data.Form = collectionForm{
+		Title:       collection.Title,
+		Description: collection.Description,
+		Public:      collection.Public,
+	}
+
+	app.render(writer, request, http.StatusOK, "collection_form.tmpl", data)
+}
+
+// collectionEditPost changes a collection's title, description and
+// visibility. The slug stays the same so that links to it keep working.
+func (app *application) collectionEditPost(writer http.ResponseWriter, request *http.Request) {
+	collection, ok := app.ownCollection(writer, request)
+	if !ok {
+		return
+	}
+
+	user, err := app.users.Get(request.Context(), collection.UserId)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	var form collectionForm
+
+	err = app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	form.validate(user)
+
+	if !form.Valid() {
+		data := app.newTemplateData(request)
+		data.Collection = collection
+		data.Form = form
+		app.render(writer, request, http.StatusUnprocessableEntity, "collection_form.tmpl", data)
+		return
+	}
+
+	err = app.collections.Update(request.Context(), collection.Id, form.Title, form.Description, form.Public)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.sessionManager.Put(request.Context(), "flash", "Collection updated.")
+
+	http.Redirect(writer, request, collectionURL(collection), http.StatusSeeOther)
+}
+
+func (app *application) collectionDeletePost(writer http.ResponseWriter, request *http.Request) {
+	collection, ok := app.ownCollection(writer, request)
+	if !ok {
+		return
+	}
+
+	err := app.collections.Delete(request.Context(), collection.Id)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.sessionManager.Put(request.Context(), "flash", "Collection deleted.")
+
+	http.Redirect(writer, request, "/user/collections", http.StatusSeeOther)
+}
+
+// collectionMovePost moves a snippet one place up or down the collection.
+func (app *application) collectionMovePost(writer http.ResponseWriter, request *http.Request) {
+	collection, ok := app.ownCollection(writer, request)
+	if !ok {
+		return
+	}
+
+	var form collectionSnippetForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil || !validator.PermittedValue(form.Direction, "up", "down") {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	err = app.collections.MoveSnippet(request.Context(), collection.Id, form.SnippetId, app.authenticatedUserId(request), form.Direction == "up")
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(writer)
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	http.Redirect(writer, request, collectionURL(collection), http.StatusSeeOther)
+}
+
+func (app *application) collectionRemovePost(writer http.ResponseWriter, request *http.Request) {
+	collection, ok := app.ownCollection(writer, request)
+	if !ok {
+		return
+	}
+
+	var form collectionSnippetForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	err = app.collections.RemoveSnippet(request.Context(), collection.Id, form.SnippetId)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(writer)
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(request.Context(), "flash", "Snippet removed from the collection.")
+
+	http.Redirect(writer, request, collectionURL(collection), http.StatusSeeOther)
+}
+
+// snippetCollectPost adds the snippet to one of the user's collections.
+func (app *application) snippetCollectPost(writer http.ResponseWriter, request *http.Request) {
+	snippet, ok := app.snippetFromParams(writer, request)
+	if !ok {
+		return
+	}
+
+	var form snippetCollectForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	collection, err := app.collections.GetBySlug(request.Context(), form.Collection)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(writer, err)
+		return
+	}
+	if collection == nil || !app.isOwner(request, collection.UserId) {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	err = app.collections.AddSnippet(request.Context(), collection.Id, snippet.Id)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.sessionManager.Put(request.Context(), "flash", fmt.Sprintf("Snippet added to %s.", collection.Title))
+
+	http.Redirect(writer, request, fmt.Sprintf("/snippet/view/%d", snippet.Id), http.StatusSeeOther)
+}
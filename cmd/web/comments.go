@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"snippetbox.jonnevuorela.com/internal/models"
+	"snippetbox.jonnevuorela.com/internal/validator"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// commentMaxChars is the longest a comment can be, in characters.
+const commentMaxChars = 5000
+
+type commentForm struct {
+	Body                string `form:"body"`
+	ParentId            int    `form:"parent_id"`
+	validator.Validator `form:"-"`
+}
+
+type snippetCommentsForm struct {
+	Enabled bool `form:"enabled"`
+}
+
+// snippetFromParams returns the snippet named by the :id route parameter, if
+// the request may see it.
+func (app *application) snippetFromParams(writer http.ResponseWriter, request *http.Request) (*models.Snippet, bool) {
+	params := httprouter.ParamsFromContext(request.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(writer)
+		return nil, false
+	}
+
+	snippet, err := app.snippets.Get(request.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(writer)
+		} else {
+			app.serverError(writer, err)
+		}
+		return nil, false
+	}
+
+	if !app.canView(request, snippet) {
+		app.notFound(writer)
+		return nil, false
+	}
+
+	return snippet, true
+}
+
+// ownComment returns the comment named by the :id route parameter. The
+// snippet it is on has to be one the request may still see, since it may have
+// been made private or deleted since the comment was posted. Only its author
+// can change it, so anyone else gets a 403.
+func (app *application) ownComment(writer http.ResponseWriter, request *http.Request) (*models.Comment, bool) {
+	params := httprouter.ParamsFromContext(request.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(writer)
+		return nil, false
+	}
+
+	comment, err := app.comments.Get(request.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(writer)
+		} else {
+			app.serverError(writer, err)
+		}
+		return nil, false
+	}
+
+	snippet, err := app.snippets.Get(request.Context(), comment.SnippetId)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(writer)
+		} else {
+			app.serverError(writer, err)
+		}
+		return nil, false
+	}
+
+	if !app.canView(request, snippet) {
+		app.notFound(writer)
+		return nil, false
+	}
+
+	if comment.UserId != app.authenticatedUserId(request) {
+		app.clientError(writer, http.StatusForbidden)
+		return nil, false
+	}
+
+	return comment, true
+}
+
+func commentURL(comment *models.Comment) string {
+	return fmt.Sprintf("/snippet/view/%d#comment-%d", comment.SnippetId, comment.Id)
+}
+
+// commentCreatePost adds a comment to a snippet, or a reply to one of its
+// comments when parent_id is set.
+func (app *application) commentCreatePost(writer http.ResponseWriter, request *http.Request) {
+	snippet, ok := app.snippetFromParams(writer, request)
+	if !ok {
+		return
+	}
+
+	if !snippet.CommentsEnabled {
+		app.clientError(writer, http.StatusForbidden)
+		return
+	}
+
+	var form commentForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	if form.ParentId != 0 {
+		parent, err := app.comments.Get(request.Context(), form.ParentId)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(writer, err)
+			return
+		}
+		if parent == nil || parent.SnippetId != snippet.Id {
+			app.clientError(writer, http.StatusBadRequest)
+			return
+		}
+	}
+
+	user, err := app.users.Get(request.Context(), app.authenticatedUserId(request))
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Body), "body", "This field cannot be blank")
+	form.CheckField(validator.MaxChar(form.Body, commentMaxChars), "body", fmt.Sprintf("This field cannot be more than %d characters long", commentMaxChars))
+	form.CheckField(!snippet.Public || user.EmailVerified(), "body", "You need to verify your email address before commenting on public snippets")
+
+	if !form.Valid() {
+		data, err := app.snippetViewData(request, snippet)
+		if err != nil {
+			app.serverError(writer, err)
+			return
+		}
+		data.Form = form
+		app.render(writer, request, http.StatusUnprocessableEntity, "view.tmpl", data)
+		return
+	}
+
+	id, err := app.comments.Insert(request.Context(), snippet.Id, form.ParentId, app.authenticatedUserId(request), form.Body)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.sessionManager.Put(request.Context(), "flash", "Comment posted.")
+
+	http.Redirect(writer, request, commentURL(&models.Comment{Id: id, SnippetId: snippet.Id}), http.StatusSeeOther)
+}
+
+// snippetCommentsPost lets the owner of a snippet turn its comments on or
+// off. Turning them off closes the thread: existing comments stay, but
+// nobody can add to them.
+func (app *application) snippetCommentsPost(writer http.ResponseWriter, request *http.Request) {
+	snippet, ok := app.snippetFromParams(writer, request)
+	if !ok {
+		return
+	}
+
+	if !app.isOwner(request, snippet.UserId) {
+		app.clientError(writer, http.StatusForbidden)
+		return
+	}
+
+	var form snippetCommentsForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	err = app.snippets.SetCommentsEnabled(request.Context(), snippet.UserId, snippet.Id, form.Enabled)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	if form.Enabled {
+		app.sessionManager.Put(request.Context(), "flash", "Comments are on.")
+	} else {
+		app.sessionManager.Put(request.Context(), "flash", "Comments are off.")
+	}
+
+	http.Redirect(writer, request, fmt.Sprintf("/snippet/view/%d", snippet.Id), http.StatusSeeOther)
+}
+
+func (app *application) commentEdit(writer http.ResponseWriter, request *http.Request) {
+	comment, ok := app.ownComment(writer, request)
+	if !ok {
+		return
+	}
+
+	data := app.newTemplateData(request)
+	data.Comment = comment
+	data.Form = commentForm{Body: comment.Body}
+
+	app.render(writer, request, http.StatusOK, "comment_edit.tmpl", data)
+}
+
+func (app *application) commentEditPost(writer http.ResponseWriter, request *http.Request) {
+	comment, ok := app.ownComment(writer, request)
+	if !ok {
+		return
+	}
+
+	var form commentForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Body), "body", "This field cannot be blank")
+	form.CheckField(validator.MaxChar(form.Body, commentMaxChars), "body", fmt.Sprintf("This field cannot be more than %d characters long", commentMaxChars))
+
+	if !form.Valid() {
+		data := app.newTemplateData(request)
+		data.Comment = comment
+		data.Form = form
+		app.render(writer, request, http.StatusUnprocessableEntity, "comment_edit.tmpl", data)
+		return
+	}
+
+	err = app.comments.Update(request.Context(), comment.Id, comment.UserId, form.Body)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(writer)
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(request.Context(), "flash", "Comment updated.")
+
+	http.Redirect(writer, request, commentURL(comment), http.StatusSeeOther)
+}
+
+func (app *application) commentDeletePost(writer http.ResponseWriter, request *http.Request) {
+	comment, ok := app.ownComment(writer, request)
+	if !ok {
+		return
+	}
+
+	err := app.comments.Delete(request.Context(), comment.Id, comment.UserId)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(writer)
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(request.Context(), "flash", "Comment deleted.")
+
+	http.Redirect(writer, request, fmt.Sprintf("/snippet/view/%d#comments", comment.SnippetId), http.StatusSeeOther)
+}
+
+// commentCounts returns how many comments each of the snippets has, for the
+// snippet listings.
+func (app *application) commentCounts(request *http.Request, snippets []*models.Snippet) (map[int]int, error) {
+	ids := make([]int, len(snippets))
+	for i, s := range snippets {
+		ids[i] = s.Id
+	}
+	return app.comments.Counts(request.Context(), ids)
+}
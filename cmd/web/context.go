@@ -2,4 +2,9 @@ package main
 
 type contextKey string
 
-const isAuthenticatedContextKey = contextKey("isAuthenticated")
+const (
+	isAuthenticatedContextKey = contextKey("isAuthenticated")
+	roleContextKey            = contextKey("role")
+	serverSpanContextKey      = contextKey("serverSpan")
+	userSessionContextKey     = contextKey("userSession")
+)
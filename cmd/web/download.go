@@ -0,0 +1,82 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"snippetbox.jonnevuorela.com/internal/models"
+)
+
+// snippetDownload sends all of a snippet's files as a zip archive.
+func (app *application) snippetDownload(writer http.ResponseWriter, request *http.Request) {
+	snippet, ok := app.snippetFromParams(writer, request)
+	if !ok {
+		return
+	}
+
+	files, err := app.snippetFiles(request, snippet)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	// The archive is built in memory so that a failure can still be
+	// reported with a 500 rather than a truncated download.
+	buf := new(bytes.Buffer)
+
+	err = writeSnippetZip(buf, snippet, files)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/zip")
+	writer.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="snippet-%d.zip"`, snippet.Id))
+	writer.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+
+	buf.WriteTo(writer)
+}
+
+func writeSnippetZip(buf *bytes.Buffer, snippet *models.Snippet, files []*models.SnippetFile) error {
+	zw := zip.NewWriter(buf)
+
+	used := map[string]bool{}
+
+	for i, f := range files {
+		name := zipEntryName(f.Filename, i)
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%d-%s", n, zipEntryName(f.Filename, i))
+		}
+		used[name] = true
+
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: snippet.Created,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write([]byte(f.Content))
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// zipEntryName makes a file's name safe to use in an archive: it can't be a
+// path, so that unpacking the archive can't write outside its directory.
+func zipEntryName(filename string, i int) string {
+	name := strings.NewReplacer("/", "_", `\`, "_").Replace(filename)
+	if name == "" || name == "." || name == ".." {
+		name = fmt.Sprintf("snippet-%d.txt", i+1)
+	}
+	return name
+}
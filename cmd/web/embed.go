@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/models"
+)
+
+const (
+	// embedWidth and embedHeight are the size of the frame oEmbed suggests,
+	// in pixels, unless the consumer asks for a smaller one.
+	embedWidth  = 600
+	embedHeight = 400
+)
+
+type oembedForm struct {
+	URL       string `form:"url"`
+	Format    string `form:"format"`
+	MaxWidth  int    `form:"maxwidth"`
+	MaxHeight int    `form:"maxheight"`
+}
+
+// oembedResponse is a rich oEmbed response, as described at https://oembed.com.
+type oembedResponse struct {
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name,omitempty"`
+	AuthorURL    string `json:"author_url,omitempty"`
+	HTML         string `json:"html"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+// snippetEmbed is a page with just the snippet on it, for other sites to put
+// in a frame. It is served without a session, so only public snippets can be
+// embedded.
+func (app *application) snippetEmbed(writer http.ResponseWriter, request *http.Request) {
+	snippet, ok := app.snippetFromParams(writer, request)
+	if !ok {
+		return
+	}
+
+	files, err := app.snippetFiles(request, snippet)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	data := &templateData{
+		CurrentYear: time.Now().Year(),
+		Snippet:     snippet,
+		Files:       files,
+	}
+
+	if snippet.UserId != 0 {
+		data.Author, err = app.users.Get(request.Context(), snippet.UserId)
+		if err != nil {
+			app.serverError(writer, err)
+			return
+		}
+	}
+
+	app.render(writer, request, http.StatusOK, "embed.tmpl", data)
+}
+
+// oembedSnippetId returns the id of the snippet that rawURL, a snippet page or
+// embed on this site, points to.
+func (app *application) oembedSnippetId(rawURL string) (int, bool) {
+	base, err := url.Parse(app.config.baseURL)
+	if err != nil {
+		return 0, false
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host != base.Host {
+		return 0, false
+	}
+
+	id, found := strings.CutPrefix(u.Path, "/snippet/view/")
+	if !found {
+		id, found = strings.CutPrefix(u.Path, "/snippet/embed/")
+	}
+	if !found {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(id)
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
+
+// oembed tells oEmbed consumers, such as wiki software, how to embed a
+// snippet given the URL of its page. Only JSON is supported.
+func (app *application) oembed(writer http.ResponseWriter, request *http.Request) {
+	var form oembedForm
+
+	err := app.formDecoder.Decode(&form, request.URL.Query())
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	if form.Format != "" && form.Format != "json" {
+		app.clientError(writer, http.StatusNotImplemented)
+		return
+	}
+
+	id, ok := app.oembedSnippetId(form.URL)
+	if !ok {
+		app.notFound(writer)
+		return
+	}
+
+	snippet, err := app.snippets.Get(request.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(writer)
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	if !snippet.Public {
+		app.notFound(writer)
+		return
+	}
+
+	width, height := embedWidth, embedHeight
+	if form.MaxWidth > 0 {
+		width = min(width, form.MaxWidth)
+	}
+	if form.MaxHeight > 0 {
+		height = min(height, form.MaxHeight)
+	}
+
+	src := fmt.Sprintf("%s/snippet/embed/%d", app.config.baseURL, snippet.Id)
+
+	response := oembedResponse{
+		Version:      "1.0",
+		Type:         "rich",
+		ProviderName: "Snippetbox",
+		ProviderURL:  app.config.baseURL + "/",
+		Title:        snippet.Title,
+		HTML: fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" title="%s"></iframe>`,
+			html.EscapeString(src), width, height, html.EscapeString(snippet.Title)),
+		Width:  width,
+		Height: height,
+	}
+
+	if snippet.UserId != 0 {
+		author, err := app.users.Get(request.Context(), snippet.UserId)
+		if err != nil {
+			app.serverError(writer, err)
+			return
+		}
+		response.AuthorName = author.Name
+		response.AuthorURL = app.config.baseURL + "/u/" + author.Handle
+	}
+
+	writeJSON(writer, http.StatusOK, response)
+}
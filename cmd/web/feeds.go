@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"snippetbox.jonnevuorela.com/internal/models"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// feedSize is how many snippets a feed has.
+	feedSize = 10
+	// feedSummaryChars is how much of a snippet's content goes into its
+	// feed entry, in characters.
+	feedSummaryChars = 500
+)
+
+// atomFeed and atomEntry are the parts of an Atom document (RFC 4287) that
+// the snippet feeds use. Their text is escaped by encoding/xml.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  *atomAuthor `xml:"author,omitempty"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+
+	// modified is when the newest entry was, for Last-Modified.
+	modified time.Time
+}
+
+type atomEntry struct {
+	Id        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Author    *atomAuthor `xml:"author,omitempty"`
+	Link      atomLink    `xml:"link"`
+	Summary   atomText    `xml:"summary"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// feedSummary shortens a snippet's content for its feed entry.
+func feedSummary(content string) string {
+	if utf8.RuneCountInString(content) <= feedSummaryChars {
+		return content
+	}
+	return string([]rune(content)[:feedSummaryChars]) + "…"
+}
+
+// snippetFeed builds the feed of snippets, newest first, served at path. page
+// is the HTML page the feed follows. A snippet's title and content can't be
+// edited, so each entry is dated to when its snippet was created, and the
+// feed to its newest entry. An empty feed is dated to the Unix epoch.
+func (app *application) snippetFeed(request *http.Request, path, page, title string, snippets []*models.Snippet) (*atomFeed, error) {
+	updated := time.Unix(0, 0).UTC()
+	if len(snippets) > 0 {
+		updated = snippets[0].Created
+	}
+
+	feed := &atomFeed{
+		Id:       app.config.baseURL + path,
+		Title:    title,
+		Updated:  atomTime(updated),
+		Author:   &atomAuthor{Name: "Snippetbox"},
+		modified: updated,
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: app.config.baseURL + path},
+			{Rel: "alternate", Type: "text/html", Href: app.config.baseURL + page},
+		},
+	}
+
+	authors := map[int]*models.User{}
+
+	for _, s := range snippets {
+		url := fmt.Sprintf("%s/snippet/view/%d", app.config.baseURL, s.Id)
+
+		entry := atomEntry{
+			Id:        url,
+			Title:     s.Title,
+			Published: atomTime(s.Created),
+			Updated:   atomTime(s.Created),
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: url},
+			Summary:   atomText{Type: "text", Body: feedSummary(s.Content)},
+		}
+
+		if s.UserId != 0 {
+			author, ok := authors[s.UserId]
+			if !ok {
+				var err error
+				author, err = app.users.Get(request.Context(), s.UserId)
+				if err != nil && !errors.Is(err, models.ErrNoRecord) {
+					return nil, err
+				}
+				authors[s.UserId] = author
+			}
+			if author != nil {
+				entry.Author = &atomAuthor{Name: author.Name, URI: app.config.baseURL + "/u/" + author.Handle}
+			}
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	return feed, nil
+}
+
+// etag identifies the feed's entries: which snippets are in it, when they
+// were updated and who wrote them. It changes whenever an entry is added,
+// removed or changed, which the feed's updated date alone doesn't.
+func (feed *atomFeed) etag() string {
+	h := sha256.New()
+	for _, entry := range feed.Entries {
+		fmt.Fprintf(h, "%s\n%s\n", entry.Id, entry.Updated)
+		if entry.Author != nil {
+			fmt.Fprintf(h, "%s\n%s\n", entry.Author.Name, entry.Author.URI)
+		}
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf(`"%x"`, h.Sum(nil)[:16])
+}
+
+// serveFeed writes the feed with an ETag over its entries and a Last-Modified
+// date from its newest entry, so that feed readers can poll it with either
+// If-None-Match or If-Modified-Since and get a 304 back if nothing has
+// changed. The feed can also change without a new entry, when a snippet in it
+// is deleted, expires or is made private, which moves the ETag but not the
+// date; readers that send both get the ETag checked, since it takes
+// precedence.
+func (app *application) serveFeed(writer http.ResponseWriter, request *http.Request, feed *atomFeed) {
+	buf := new(bytes.Buffer)
+	buf.WriteString(xml.Header)
+
+	err := xml.NewEncoder(buf).Encode(feed)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	writer.Header().Set("ETag", feed.etag())
+
+	// ServeContent answers If-None-Match, and If-Modified-Since when there
+	// is no If-None-Match.
+	http.ServeContent(writer, request, "", feed.modified, bytes.NewReader(buf.Bytes()))
+}
+
+// feedLatest is the site-wide feed of the latest public snippets.
+func (app *application) feedLatest(writer http.ResponseWriter, request *http.Request) {
+	snippets, err := app.snippets.Latest(request.Context())
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	feed, err := app.snippetFeed(request, "/feed.atom", "/", "Snippetbox: latest snippets", snippets)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.serveFeed(writer, request, feed)
+}
+
+// feedUser is the feed of a user's latest public snippets.
+func (app *application) feedUser(writer http.ResponseWriter, request *http.Request) {
+	params := httprouter.ParamsFromContext(request.Context())
+
+	user, err := app.users.GetByHandle(request.Context(), params.ByName("handle"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(writer)
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	if user.Disabled() {
+		app.notFound(writer)
+		return
+	}
+
+	snippets, err := app.snippets.PublicByUser(request.Context(), user.Id, feedSize, 0)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	page := "/u/" + user.Handle
+
+	feed, err := app.snippetFeed(request, page+"/feed.atom", page, "Snippetbox: snippets by "+user.Name, snippets)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.serveFeed(writer, request, feed)
+}
+
+// feedTag is the feed of the latest public snippets with a tag.
+func (app *application) feedTag(writer http.ResponseWriter, request *http.Request) {
+	params := httprouter.ParamsFromContext(request.Context())
+
+	tag := params.ByName("tag")
+	if !validTag(tag) {
+		app.notFound(writer)
+		return
+	}
+
+	snippets, err := app.snippets.PublicByTag(request.Context(), tag, feedSize, 0)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	page := "/tags/" + tag
+
+	feed, err := app.snippetFeed(request, page+"/feed.atom", page, "Snippetbox: snippets tagged "+tag, snippets)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.serveFeed(writer, request, feed)
+}
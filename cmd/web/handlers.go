@@ -1,87 +1,1196 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"snippetbox.jonnevuorela.com/internal/models"
+	"snippetbox.jonnevuorela.com/internal/totp"
 	"snippetbox.jonnevuorela.com/internal/validator"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+type homeForm struct {
+	Sort string `form:"sort"`
+}
+
+// snippetViewForm is the snippet page's query string. Source shows Markdown
+// files as they were written instead of rendered.
+type snippetViewForm struct {
+	Source bool `form:"source"`
+}
+
+type snippetFileForm struct {
+	Filename string `form:"filename"`
+	Language string `form:"language"`
+	Content  string `form:"content"`
+}
+
+// snippetCreateForm is posted both to publish a snippet and, through its
+// Add file and Remove buttons, to change how many files it has. Action says
+// which button was pressed.
 type snippetCreateForm struct {
-	Title               string `form:"title"`
-	Content             string `form:"content"`
-	Expires             int    `form:"expires"`
+	Title               string            `form:"title"`
+	Files               []snippetFileForm `form:"files"`
+	Tags                string            `form:"tags"`
+	Expires             int               `form:"expires"`
+	Public              bool              `form:"public"`
+	ForkedFrom          int               `form:"forked_from"`
+	Action              string            `form:"action"`
+	validator.Validator `form:"-"`
+}
+
+// CanAddFile reports whether the snippet has room for another file.
+func (f snippetCreateForm) CanAddFile() bool {
+	return len(f.Files) < snippetMaxFiles
+}
+
+type userSnippetsForm struct {
+	Ids                 []int  `form:"id"`
+	Action              string `form:"action"`
+	Days                int    `form:"days"`
+	Sort                string `form:"sort"`
+	validator.Validator `form:"-"`
+}
+
+// Selected reports whether the snippet was ticked when the form was posted.
+func (f userSnippetsForm) Selected(id int) bool {
+	return slices.Contains(f.Ids, id)
+}
+
+type userSignupForm struct {
+	Name                string `form:"name"`
+	Handle              string `form:"handle"`
+	Email               string `form:"email"`
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+type userLoginForm struct {
+	Email               string `form:"email"`
+	Password            string `form:"password"`
+	RememberMe          bool   `form:"remember_me"`
+	validator.Validator `form:"-"`
+}
+
+type userReauthenticateForm struct {
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+type userLoginTwoFactorForm struct {
+	Code                string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+type accountNameUpdateForm struct {
+	Name                string `form:"name"`
+	validator.Validator `form:"-"`
+}
+
+type accountEmailUpdateForm struct {
+	Email               string `form:"email"`
+	CurrentPassword     string `form:"current_password"`
+	validator.Validator `form:"-"`
+}
+
+type accountPasswordUpdateForm struct {
+	CurrentPassword         string `form:"current_password"`
+	NewPassword             string `form:"new_password"`
+	NewPasswordConfirmation string `form:"new_password_confirmation"`
+	validator.Validator     `form:"-"`
+}
+
+type accountSessionRevokeForm struct {
+	Id int `form:"id"`
+}
+
+type accountTwoFactorEnableForm struct {
+	Code                string `form:"code"`
 	validator.Validator `form:"-"`
 }
 
-type userSignupForm struct {
-	Name                string `form:"name"`
-	Email               string `form:"email"`
-	Password            string `form:"password"`
-	validator.Validator `form:"-"`
-}
+type accountTwoFactorDisableForm struct {
+	CurrentPassword     string `form:"current_password"`
+	validator.Validator `form:"-"`
+}
+
+type passwordForgotForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+type passwordResetForm struct {
+	Token                string `form:"-"`
+	Password             string `form:"password"`
+	PasswordConfirmation string `form:"password_confirmation"`
+	validator.Validator  `form:"-"`
+}
+
+// home lists the latest snippets, or with ?sort=starred the ones starred most
+// in the last week.
+func (app *application) home(writer http.ResponseWriter, request *http.Request) {
+	var form homeForm
+
+	err := app.formDecoder.Decode(&form, request.URL.Query())
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	var snippets []*models.Snippet
+	var starsSince time.Time
+
+	switch form.Sort {
+	case "starred":
+		snippets, err = app.snippets.MostStarred(request.Context())
+		starsSince = time.Now().Add(-models.TrendingPeriod)
+	default:
+		form.Sort = "latest"
+		snippets, err = app.snippets.Latest(request.Context())
+	}
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	data := app.newTemplateData(request)
+	data.Snippets = snippets
+	data.Form = form
+
+	data.CommentCounts, err = app.commentCounts(request, snippets)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	data.StarCounts, err = app.starCounts(request, snippets, starsSince)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.render(writer, request, http.StatusOK, "home.tmpl", data)
+}
+
+func (app *application) snippetView(writer http.ResponseWriter, request *http.Request) {
+
+	params := httprouter.ParamsFromContext(request.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(writer)
+		return
+	}
+	snippet, err := app.snippets.Get(request.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(writer)
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	if !app.canView(request, snippet) {
+		app.notFound(writer)
+		return
+	}
+
+	var form snippetViewForm
+
+	err = app.formDecoder.Decode(&form, request.URL.Query())
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	data, err := app.snippetViewData(request, snippet)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+	data.ShowSource = form.Source
+
+	app.render(writer, request, http.StatusOK, "view.tmpl", data)
+}
+
+// snippetViewData gathers what the snippet page shows: its files and tags,
+// its author, the snippet it was forked from, its forks, its comments and its
+// stars, and the user's collections to add it to.
+func (app *application) snippetViewData(request *http.Request, snippet *models.Snippet) (*templateData, error) {
+	data := app.newTemplateData(request)
+	data.Snippet = snippet
+	data.Form = commentForm{}
+
+	var err error
+
+	data.Files, err = app.snippetFiles(request, snippet)
+	if err != nil {
+		return nil, err
+	}
+
+	data.Tags, err = app.snippets.Tags(request.Context(), snippet.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if snippet.ForkedFrom != 0 {
+		parent, err := app.snippets.Get(request.Context(), snippet.ForkedFrom)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			return nil, err
+		}
+		if parent != nil && app.canView(request, parent) {
+			data.Parent = parent
+		}
+	}
+
+	data.Forks, err = app.snippets.Forks(request.Context(), snippet.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if snippet.UserId != 0 {
+		data.Author, err = app.users.Get(request.Context(), snippet.UserId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data.Comments, err = app.comments.Thread(request.Context(), snippet.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	data.StarCounts, err = app.starCounts(request, []*models.Snippet{snippet}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	if data.IsAuthenticated {
+		data.Starred, err = app.stars.Starred(request.Context(), data.AuthenticatedUserId, snippet.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		data.Collections, err = app.collections.ByOwner(request.Context(), data.AuthenticatedUserId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// userProfile shows a user's public profile: who they are, their public
+// collections and a page of their public snippets. Disabled users don't have
+// a profile.
+func (app *application) userProfile(writer http.ResponseWriter, request *http.Request) {
+	params := httprouter.ParamsFromContext(request.Context())
+
+	user, err := app.users.GetByHandle(request.Context(), params.ByName("handle"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(writer)
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	if user.Disabled() {
+		app.notFound(writer)
+		return
+	}
+
+	page := 1
+	if s := request.URL.Query().Get("page"); s != "" {
+		page, err = strconv.Atoi(s)
+		if err != nil || page < 1 {
+			app.notFound(writer)
+			return
+		}
+	}
+
+	// One more than a page is fetched to find out if there is a next page.
+	snippets, err := app.snippets.PublicByUser(request.Context(), user.Id, profilePageSize+1, (page-1)*profilePageSize)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	data := app.newTemplateData(request)
+	data.Profile = user
+	data.Pagination = &pagination{Page: page, Prev: page - 1}
+
+	if len(snippets) > profilePageSize {
+		snippets = snippets[:profilePageSize]
+		data.Pagination.Next = page + 1
+	}
+	data.Snippets = snippets
+
+	data.CommentCounts, err = app.commentCounts(request, snippets)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	collections, err := app.collections.ByOwner(request.Context(), user.Id)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+	for _, c := range collections {
+		if c.Public {
+			data.Collections = append(data.Collections, c)
+		}
+	}
+
+	app.render(writer, request, http.StatusOK, "profile.tmpl", data)
+}
+
+func (app *application) snippetCreate(writer http.ResponseWriter, request *http.Request) {
+	user, err := app.users.Get(request.Context(), app.authenticatedUserId(request))
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	data := app.newTemplateData(request)
+	data.User = user
+
+	form := snippetCreateForm{
+		Files:   []snippetFileForm{{}},
+		Expires: 365,
+		Public:  user.EmailVerified(),
+	}
+
+	// ?fork=id starts the form off as a copy of another snippet.
+	if s := request.URL.Query().Get("fork"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil || id < 1 {
+			app.notFound(writer)
+			return
+		}
+
+		original, err := app.snippets.Get(request.Context(), id)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.notFound(writer)
+			} else {
+				app.serverError(writer, err)
+			}
+			return
+		}
+
+		if !app.canView(request, original) {
+			app.notFound(writer)
+			return
+		}
+
+		files, err := app.snippetFiles(request, original)
+		if err != nil {
+			app.serverError(writer, err)
+			return
+		}
+
+		tags, err := app.snippets.Tags(request.Context(), original.Id)
+		if err != nil {
+			app.serverError(writer, err)
+			return
+		}
+
+		form.Title = original.Title
+		form.Files = form.Files[:0]
+		for _, f := range files {
+			form.Files = append(form.Files, snippetFileForm{Filename: f.Filename, Language: f.Language, Content: f.Content})
+		}
+		form.Tags = strings.Join(tags, " ")
+		form.ForkedFrom = original.Id
+		data.Parent = original
+	}
+
+	data.Form = form
+	data.Languages = models.SnippetLanguages
+	app.render(writer, request, http.StatusOK, "create.tmpl", data)
+}
+
+// snippetCreatePost publishes a snippet, or adds or removes a file input and
+// shows the form again. The buttons are part of the form so that this works
+// without JavaScript.
+func (app *application) snippetCreatePost(writer http.ResponseWriter, request *http.Request) {
+	var form snippetCreateForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.users.Get(request.Context(), app.authenticatedUserId(request))
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	// A fork of a snippet that has since expired, been deleted or made
+	// private is saved as an original.
+	var parent *models.Snippet
+	if form.ForkedFrom != 0 {
+		parent, err = app.snippets.Get(request.Context(), form.ForkedFrom)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(writer, err)
+			return
+		}
+		if parent == nil || !app.canView(request, parent) {
+			parent = nil
+			form.ForkedFrom = 0
+		}
+	}
+
+	if form.Action == "add" || strings.HasPrefix(form.Action, "remove-") {
+		if form.Action == "add" && form.CanAddFile() {
+			form.Files = append(form.Files, snippetFileForm{})
+		}
+		if i, err := strconv.Atoi(strings.TrimPrefix(form.Action, "remove-")); err == nil && i >= 0 && i < len(form.Files) && len(form.Files) > 1 {
+			form.Files = slices.Delete(form.Files, i, i+1)
+		}
+		app.renderSnippetCreate(writer, request, http.StatusOK, user, parent, form)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
+	form.CheckField(validator.MaxChar(form.Title, 100), "title", "This field cannot be more than 100 characters long")
+	form.CheckField(len(form.Files) > 0, "files", "A snippet needs at least one file")
+	form.CheckField(len(form.Files) <= snippetMaxFiles, "files", fmt.Sprintf("A snippet can't have more than %d files", snippetMaxFiles))
+	form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365")
+	form.CheckField(!form.Public || user.EmailVerified(), "public", "You need to verify your email address before publishing public snippets")
+
+	seen := map[string]bool{}
+	for i, f := range form.Files {
+		key := fmt.Sprintf("files[%d].", i)
+
+		form.CheckField(validator.NotBlank(f.Content), key+"content", "This field cannot be blank")
+		form.CheckField(len(form.Files) == 1 || validator.NotBlank(f.Filename), key+"filename", "Each file needs a name when there are several")
+		form.CheckField(validator.MaxChar(f.Filename, 255), key+"filename", "This field cannot be more than 255 characters long")
+		form.CheckField(!strings.ContainsAny(f.Filename, `/\`) && !validator.PermittedValue(f.Filename, ".", ".."), key+"filename", "This field must be a file name, not a path")
+		form.CheckField(f.Filename == "" || !seen[f.Filename], key+"filename", "Another file already has this name")
+		form.CheckField(validator.PermittedValue(f.Language, models.SnippetLanguages...), key+"language", "This field must be a known language")
+
+		seen[f.Filename] = true
+	}
+
+	tags := parseTags(form.Tags)
+	form.CheckField(len(tags) <= snippetMaxTags, "tags", fmt.Sprintf("A snippet can't have more than %d tags", snippetMaxTags))
+	for _, tag := range tags {
+		form.CheckField(validTag(tag), "tags", fmt.Sprintf("Tags can only contain letters, numbers and -, and be up to %d characters long", models.TagMaxChars))
+	}
+
+	if !form.Valid() {
+		app.renderSnippetCreate(writer, request, http.StatusUnprocessableEntity, user, parent, form)
+		return
+	}
+
+	files := make([]*models.SnippetFile, len(form.Files))
+	for i, f := range form.Files {
+		files[i] = &models.SnippetFile{Filename: f.Filename, Language: f.Language, Content: f.Content}
+	}
+
+	id, err := app.snippets.Insert(request.Context(), user.Id, form.Title, files, tags, form.Expires, form.Public, form.ForkedFrom)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.metrics.snippetsCreated.Inc()
+
+	app.sessionManager.Put(request.Context(), "flash", "Snippet successfully created!")
+
+	http.Redirect(writer, request, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+}
+
+func (app *application) renderSnippetCreate(writer http.ResponseWriter, request *http.Request, status int, user *models.User, parent *models.Snippet, form snippetCreateForm) {
+	data := app.newTemplateData(request)
+	data.User = user
+	data.Parent = parent
+	data.Form = form
+	data.Languages = models.SnippetLanguages
+	app.render(writer, request, status, "create.tmpl", data)
+}
+
+// userSnippets lists every snippet the user owns, including private and
+// expired ones, for them to manage.
+func (app *application) userSnippets(writer http.ResponseWriter, request *http.Request) {
+	form := userSnippetsForm{Days: 7, Sort: request.URL.Query().Get("sort")}
+	app.renderUserSnippets(writer, request, http.StatusOK, form)
+}
+
+func (app *application) renderUserSnippets(writer http.ResponseWriter, request *http.Request, status int, form userSnippetsForm) {
+	if _, ok := models.SnippetSorts[form.Sort]; !ok {
+		form.Sort = "newest"
+	}
+
+	snippets, err := app.snippets.ByOwner(request.Context(), app.authenticatedUserId(request), form.Sort)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	data := app.newTemplateData(request)
+	data.Snippets = snippets
+	data.Form = form
+
+	data.CommentCounts, err = app.commentCounts(request, snippets)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.render(writer, request, status, "user_snippets.tmpl", data)
+}
+
+// userSnippetsPost applies a bulk action to the selected snippets. Snippets
+// the user doesn't own are skipped by the model, so the count in the flash
+// message is of the user's own, including any that were already as asked.
+func (app *application) userSnippetsPost(writer http.ResponseWriter, request *http.Request) {
+	var form userSnippetsForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.users.Get(request.Context(), app.authenticatedUserId(request))
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	form.CheckField(len(form.Ids) > 0, "id", "Select at least one snippet")
+	form.CheckField(validator.PermittedValue(form.Action, "delete", "extend", "public", "private"), "action", "Choose an action")
+	if form.Action == "extend" {
+		form.CheckField(validator.PermittedValue(form.Days, 1, 7, 365), "days", "This field must equal 1, 7 or 365")
+	}
+	form.CheckField(form.Action != "public" || user.EmailVerified(), "action", "You need to verify your email address before publishing public snippets")
+
+	if !form.Valid() {
+		app.renderUserSnippets(writer, request, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	var n int
+	var deleted []int
+	var done string
+
+	switch form.Action {
+	case "delete":
+		deleted, err = app.snippets.DeleteByOwner(request.Context(), user.Id, form.Ids)
+		n = len(deleted)
+		done = "deleted"
+	case "extend":
+		n, err = app.snippets.ExtendByOwner(request.Context(), user.Id, form.Ids, form.Days)
+		done = "extended"
+	case "public", "private":
+		n, err = app.snippets.SetPublicByOwner(request.Context(), user.Id, form.Ids, form.Action == "public")
+		done = "made " + form.Action
+	}
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	if len(deleted) > 0 {
+		ids := make([]string, len(deleted))
+		for i, id := range deleted {
+			ids[i] = strconv.Itoa(id)
+		}
+		app.audit(request, models.AuditSnippetDelete, user.Id, map[string]string{"snippet_ids": strings.Join(ids, ",")})
+	}
+
+	if n == 1 {
+		app.sessionManager.Put(request.Context(), "flash", "1 snippet "+done+".")
+	} else {
+		app.sessionManager.Put(request.Context(), "flash", fmt.Sprintf("%d snippets %s.", n, done))
+	}
+
+	path := "/user/snippets"
+	if _, ok := models.SnippetSorts[form.Sort]; ok {
+		path += "?sort=" + form.Sort
+	}
+
+	http.Redirect(writer, request, path, http.StatusSeeOther)
+}
+
+func (app *application) userSignup(writer http.ResponseWriter, request *http.Request) {
+	data := app.newTemplateData(request)
+	data.Form = userSignupForm{}
+	app.render(writer, request, http.StatusOK, "signup.tmpl", data)
+}
+
+func (app *application) userSignupPost(writer http.ResponseWriter, request *http.Request) {
+	var form userSignupForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+	form.Handle = strings.ToLower(strings.TrimSpace(form.Handle))
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.Handle, 3), "handle", "This field must be at least 3 characters long")
+	form.CheckField(validator.MaxChar(form.Handle, 30), "handle", "This field cannot be more than 30 characters long")
+	form.CheckField(validator.AllowedChars(form.Handle, validator.HandleChars), "handle", "This field can only contain letters, numbers, - and _")
+	form.CheckField(validator.NotPermittedValue(form.Handle, validator.ReservedHandles...), "handle", "This handle is reserved")
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
+
+	if !form.Valid() {
+		data := app.newTemplateData(request)
+		data.Form = form
+		app.render(writer, request, http.StatusUnprocessableEntity, "signup.tmpl", data)
+		return
+	}
+
+	id, err := app.users.Insert(request.Context(), form.Name, form.Handle, form.Email, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			form.AddFieldError("email", "Email address is already in use")
+			data := app.newTemplateData(request)
+			data.Form = form
+			app.render(writer, request, http.StatusUnprocessableEntity, "signup.tmpl", data)
+		} else if errors.Is(err, models.ErrDuplicateHandle) {
+			form.AddFieldError("handle", "Handle is already taken")
+			data := app.newTemplateData(request)
+			data.Form = form
+			app.render(writer, request, http.StatusUnprocessableEntity, "signup.tmpl", data)
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	app.audit(request, models.AuditSignup, id, map[string]string{"email": form.Email})
+
+	event := app.auditEvent(request, models.AuditTokenCreate, id, nil)
+	ctx := context.WithoutCancel(request.Context())
+	app.background(func() {
+		err := app.sendEmailVerification(ctx, id, form.Name, form.Email, event)
+		if err != nil {
+			app.errorLog.Print(err)
+		}
+	})
+
+	app.sessionManager.Put(request.Context(), "flash", "Your signup was successful. We've sent you an email to verify your address. Please log in.")
+
+	http.Redirect(writer, request, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) userLogin(writer http.ResponseWriter, request *http.Request) {
+	if next := request.URL.Query().Get("next"); isLocalPath(next) {
+		app.sessionManager.Put(request.Context(), "redirectPathAfterLogin", next)
+	}
+
+	data := app.newTemplateData(request)
+	data.Form = userLoginForm{}
+	app.render(writer, request, http.StatusOK, "login.tmpl", data)
+}
+
+func (app *application) userLoginPost(writer http.ResponseWriter, request *http.Request) {
+	var form userLoginForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+
+	if !form.Valid() {
+		data := app.newTemplateData(request)
+		data.Form = form
+		app.render(writer, request, http.StatusUnprocessableEntity, "login.tmpl", data)
+		return
+	}
+
+	ip := app.clientIP(request)
+
+	block, err := app.loginAttempts.Check(request.Context(), form.Email, ip)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	if block != nil {
+		app.audit(request, models.AuditLoginFailed, 0, map[string]string{"email": form.Email, "reason": "locked"})
+
+		form.AddNonFieldError(loginBlockMessage(block))
+
+		data := app.newTemplateData(request)
+		data.Form = form
+		app.render(writer, request, http.StatusTooManyRequests, "login.tmpl", data)
+		return
+	}
+
+	id, err := app.users.Authenticate(request.Context(), form.Email, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.metrics.logins.WithLabelValues("failure").Inc()
+			app.audit(request, models.AuditLoginFailed, 0, map[string]string{"email": form.Email, "reason": "password"})
+
+			block, err := app.loginAttempts.RecordFailure(request.Context(), form.Email, ip)
+			if err != nil {
+				app.serverError(writer, err)
+				return
+			}
+
+			form.AddNonFieldError("Email or password is incorrect")
+
+			if block != nil && block.Locked {
+				form.AddNonFieldError(loginBlockMessage(block))
+				// Looking up the account and sending mail happen in the
+				// background, so that the response takes as long whether or
+				// not the email has an account.
+				ctx := context.WithoutCancel(request.Context())
+				app.background(func() {
+					app.notifyLoginLocked(ctx, form.Email, ip, block)
+				})
+			}
+
+			data := app.newTemplateData(request)
+			data.Form = form
+			app.render(writer, request, http.StatusUnprocessableEntity, "login.tmpl", data)
+		} else if errors.Is(err, models.ErrAccountDisabled) {
+			app.audit(request, models.AuditLoginFailed, 0, map[string]string{"email": form.Email, "reason": "disabled"})
+
+			form.AddNonFieldError("This account has been disabled")
+
+			data := app.newTemplateData(request)
+			data.Form = form
+			app.render(writer, request, http.StatusUnprocessableEntity, "login.tmpl", data)
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	err = app.loginAttempts.Reset(request.Context(), form.Email)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	_, err = app.twoFactor.Get(request.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.logIn(writer, request, id, form.RememberMe)
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	// The password was right but the session isn't logged in until the
+	// second factor has been checked too.
+	app.startTwoFactorLogin(writer, request, id, form.RememberMe)
+}
+
+func (app *application) userLoginTwoFactor(writer http.ResponseWriter, request *http.Request) {
+	if app.twoFactorUserId(request) == 0 {
+		http.Redirect(writer, request, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(request)
+	data.Form = userLoginTwoFactorForm{}
+	app.render(writer, request, http.StatusOK, "login_2fa.tmpl", data)
+}
+
+func (app *application) userLoginTwoFactorPost(writer http.ResponseWriter, request *http.Request) {
+	id := app.twoFactorUserId(request)
+	if id == 0 {
+		app.sessionManager.Put(request.Context(), "flash", "Your login timed out. Please log in again.")
+		http.Redirect(writer, request, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	var form userLoginTwoFactorForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	form.Code = strings.ReplaceAll(strings.TrimSpace(form.Code), " ", "")
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	if !form.Valid() {
+		data := app.newTemplateData(request)
+		data.Form = form
+		app.render(writer, request, http.StatusUnprocessableEntity, "login_2fa.tmpl", data)
+		return
+	}
+
+	// Anything longer than a TOTP code is taken to be a recovery code.
+	recovery := len(form.Code) > totp.Digits
+	if recovery {
+		err = app.twoFactor.UseRecoveryCode(request.Context(), id, form.Code)
+	} else {
+		err = app.twoFactor.Verify(request.Context(), id, form.Code)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.metrics.logins.WithLabelValues("failure").Inc()
+			app.audit(request, models.AuditLoginFailed, 0, map[string]string{"user_id": strconv.Itoa(id), "reason": "two_factor"})
+
+			failures := app.sessionManager.GetInt(request.Context(), "twoFactorFailures") + 1
+			if failures >= twoFactorMaxAttempts {
+				app.clearTwoFactorLogin(request)
+				app.sessionManager.Put(request.Context(), "flash", "Too many incorrect codes. Please log in again.")
+				http.Redirect(writer, request, "/user/login", http.StatusSeeOther)
+				return
+			}
+			app.sessionManager.Put(request.Context(), "twoFactorFailures", failures)
+
+			form.AddNonFieldError("Code is incorrect")
+
+			data := app.newTemplateData(request)
+			data.Form = form
+			app.render(writer, request, http.StatusUnprocessableEntity, "login_2fa.tmpl", data)
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	remember := app.sessionManager.GetBool(request.Context(), "twoFactorRememberMe")
+	app.clearTwoFactorLogin(request)
+
+	if recovery {
+		tf, err := app.twoFactor.Get(request.Context(), id)
+		if err != nil {
+			app.serverError(writer, err)
+			return
+		}
+		app.sessionManager.Put(request.Context(), "flash", fmt.Sprintf("You used a recovery code. You have %d left.", tf.RecoveryCodes))
+	}
+
+	app.logIn(writer, request, id, remember)
+}
+
+func (app *application) userReauthenticate(writer http.ResponseWriter, request *http.Request) {
+	data := app.newTemplateData(request)
+	data.Form = userReauthenticateForm{}
+	app.render(writer, request, http.StatusOK, "reauthenticate.tmpl", data)
+}
+
+func (app *application) userReauthenticatePost(writer http.ResponseWriter, request *http.Request) {
+	var form userReauthenticateForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+
+	if !form.Valid() {
+		data := app.newTemplateData(request)
+		data.Form = form
+		app.render(writer, request, http.StatusUnprocessableEntity, "reauthenticate.tmpl", data)
+		return
+	}
+
+	user, err := app.users.Get(request.Context(), app.authenticatedUserId(request))
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	status, err := app.checkCurrentPassword(request, user, form.Password, &form.Validator)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	if status != 0 {
+		reason := "reauthenticate"
+		if status == http.StatusForbidden {
+			reason = "disabled"
+		}
+		if status != http.StatusTooManyRequests {
+			app.audit(request, models.AuditLoginFailed, user.Id, map[string]string{"reason": reason})
+		}
+
+		data := app.newTemplateData(request)
+		data.Form = form
+		app.render(writer, request, status, "reauthenticate.tmpl", data)
+		return
+	}
+
+	app.sessionManager.Put(request.Context(), "authenticatedAt", time.Now().Unix())
+
+	// The redirect path is only ever set by requireReauthentication from the
+	// request's own URL, so it is always on this site.
+	path := app.sessionManager.PopString(request.Context(), "reauthenticateRedirect")
+	if path == "" {
+		path = "/account"
+	}
+
+	http.Redirect(writer, request, path, http.StatusSeeOther)
+}
+
+func (app *application) userLogoutPost(writer http.ResponseWriter, request *http.Request) {
+	app.audit(request, models.AuditLogout, app.authenticatedUserId(request), nil)
+
+	if s := app.currentSession(request); s != nil {
+		err := app.userSessions.Delete(request.Context(), s.UserId, s.Id)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(writer, err)
+			return
+		}
+	}
+
+	err := app.logOut(request)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.sessionManager.Put(request.Context(), "flash", "You've been logged out successfully!")
+
+	http.Redirect(writer, request, "/", http.StatusSeeOther)
+}
+
+func (app *application) userPasswordForgot(writer http.ResponseWriter, request *http.Request) {
+	data := app.newTemplateData(request)
+	data.Form = passwordForgotForm{}
+	app.render(writer, request, http.StatusOK, "password_forgot.tmpl", data)
+}
+
+func (app *application) userPasswordForgotPost(writer http.ResponseWriter, request *http.Request) {
+	var form passwordForgotForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+
+	if !form.Valid() {
+		data := app.newTemplateData(request)
+		data.Form = form
+		app.render(writer, request, http.StatusUnprocessableEntity, "password_forgot.tmpl", data)
+		return
+	}
+
+	// The lookup and the email happen in the background so that the response,
+	// and how long it takes, is the same whether or not the address is
+	// registered.
+	event := app.auditEvent(request, models.AuditTokenCreate, 0, nil)
+	ctx := context.WithoutCancel(request.Context())
+	app.background(func() {
+		err := app.sendPasswordReset(ctx, form.Email, event)
+		if err != nil {
+			app.errorLog.Print(err)
+		}
+	})
+
+	app.sessionManager.Put(request.Context(), "flash", "If there's an account for that email address, we've sent it a link to reset the password.")
+
+	http.Redirect(writer, request, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) userPasswordReset(writer http.ResponseWriter, request *http.Request) {
+	params := httprouter.ParamsFromContext(request.Context())
+
+	data := app.newTemplateData(request)
+	data.Form = passwordResetForm{Token: params.ByName("token")}
+	app.render(writer, request, http.StatusOK, "password_reset.tmpl", data)
+}
+
+func (app *application) userPasswordResetPost(writer http.ResponseWriter, request *http.Request) {
+	params := httprouter.ParamsFromContext(request.Context())
+
+	form := passwordResetForm{Token: params.ByName("token")}
+
+	err := app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
+	form.CheckField(form.Password == form.PasswordConfirmation, "passwordConfirmation", "Passwords do not match")
+
+	if !form.Valid() {
+		data := app.newTemplateData(request)
+		data.Form = form
+		app.render(writer, request, http.StatusUnprocessableEntity, "password_reset.tmpl", data)
+		return
+	}
+
+	id, err := app.passwordResets.Consume(request.Context(), form.Token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			form.AddNonFieldError("This password reset link is invalid or has expired. Please request a new one.")
+
+			data := app.newTemplateData(request)
+			data.Form = form
+			app.render(writer, request, http.StatusUnprocessableEntity, "password_reset.tmpl", data)
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	err = app.users.UpdatePassword(request.Context(), id, form.Password)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.audit(request, models.AuditPasswordChange, id, map[string]string{"method": "reset"})
+
+	err = app.userSessions.DeleteAll(request.Context(), id)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	err = app.logOut(request)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.sessionManager.Put(request.Context(), "flash", "Your password has been reset. Please log in.")
+
+	http.Redirect(writer, request, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) userVerifyEmail(writer http.ResponseWriter, request *http.Request) {
+	params := httprouter.ParamsFromContext(request.Context())
+
+	id, email, err := app.emailVerifications.Consume(request.Context(), params.ByName("token"))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.sessionManager.Put(request.Context(), "flash", "This verification link is invalid or has expired. Please request a new one.")
+			http.Redirect(writer, request, "/user/verify", http.StatusSeeOther)
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	err = app.users.MarkEmailVerified(request.Context(), id, email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.sessionManager.Put(request.Context(), "flash", "Your email address has changed since this link was sent. Please request a new one.")
+			http.Redirect(writer, request, "/user/verify", http.StatusSeeOther)
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(request.Context(), "flash", "Your email address has been verified.")
 
-type userLoginForm struct {
-	Email               string `form:"email"`
-	Password            string `form:"password"`
-	validator.Validator `form:"-"`
+	http.Redirect(writer, request, "/", http.StatusSeeOther)
 }
 
-func (app *application) home(writer http.ResponseWriter, request *http.Request) {
-	snippets, err := app.snippets.Latest()
+func (app *application) userVerifyResend(writer http.ResponseWriter, request *http.Request) {
+	user, err := app.users.Get(request.Context(), app.authenticatedUserId(request))
 	if err != nil {
 		app.serverError(writer, err)
 		return
 	}
 
 	data := app.newTemplateData(request)
-	data.Snippets = snippets
-
-	app.render(writer, http.StatusOK, "home.tmpl", data)
+	data.User = user
+	app.render(writer, request, http.StatusOK, "verify.tmpl", data)
 }
 
-func (app *application) snippetView(writer http.ResponseWriter, request *http.Request) {
+func (app *application) userVerifyResendPost(writer http.ResponseWriter, request *http.Request) {
+	user, err := app.users.Get(request.Context(), app.authenticatedUserId(request))
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
 
-	params := httprouter.ParamsFromContext(request.Context())
+	if user.EmailVerified() {
+		http.Redirect(writer, request, "/user/verify", http.StatusSeeOther)
+		return
+	}
 
-	id, err := strconv.Atoi(params.ByName("id"))
-	if err != nil || id < 1 {
-		app.notFound(writer)
+	err = app.sendEmailVerification(request.Context(), user.Id, user.Name, user.Email, app.auditEvent(request, models.AuditTokenCreate, user.Id, nil))
+	if err != nil {
+		app.serverError(writer, err)
 		return
 	}
-	snippet, err := app.snippets.Get(id)
+
+	app.sessionManager.Put(request.Context(), "flash", "We've sent a new verification link to "+user.Email+".")
+
+	http.Redirect(writer, request, "/user/verify", http.StatusSeeOther)
+}
+
+func (app *application) accountView(writer http.ResponseWriter, request *http.Request) {
+	user, err := app.users.Get(request.Context(), app.authenticatedUserId(request))
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
-			app.notFound(writer)
+			http.Redirect(writer, request, "/user/login", http.StatusSeeOther)
 		} else {
 			app.serverError(writer, err)
 		}
 		return
 	}
 
-	data := app.newTemplateData(request)
-	data.Snippet = snippet
-
-	app.render(writer, http.StatusOK, "view.tmpl", data)
+	tf, err := app.twoFactor.Get(request.Context(), user.Id)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(writer, err)
+		return
+	}
 
+	data := app.newTemplateData(request)
+	data.User = user
+	data.TwoFactor = tf
+	app.render(writer, request, http.StatusOK, "account.tmpl", data)
 }
 
-func (app *application) snippetCreate(writer http.ResponseWriter, r *http.Request) {
-	data := app.newTemplateData(r)
-
-	data.Form = snippetCreateForm{
-		Expires: 365,
+func (app *application) accountNameUpdate(writer http.ResponseWriter, request *http.Request) {
+	user, err := app.users.Get(request.Context(), app.authenticatedUserId(request))
+	if err != nil {
+		app.serverError(writer, err)
+		return
 	}
-	app.render(writer, http.StatusOK, "create.tmpl", data)
+
+	data := app.newTemplateData(request)
+	data.Form = accountNameUpdateForm{Name: user.Name}
+	app.render(writer, request, http.StatusOK, "account_name.tmpl", data)
 }
 
-func (app *application) snippetCreatePost(writer http.ResponseWriter, request *http.Request) {
-	var form snippetCreateForm
+func (app *application) accountNameUpdatePost(writer http.ResponseWriter, request *http.Request) {
+	var form accountNameUpdateForm
 
 	err := app.decodePostForm(request, &form)
 	if err != nil {
@@ -89,82 +1198,107 @@ func (app *application) snippetCreatePost(writer http.ResponseWriter, request *h
 		return
 	}
 
-	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
-	form.CheckField(validator.MaxChar(form.Title, 100), "title", "This field cannot be more than 100 characters long")
-	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
-	form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365")
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChar(form.Name, 255), "name", "This field cannot be more than 255 characters long")
 
 	if !form.Valid() {
 		data := app.newTemplateData(request)
 		data.Form = form
-		app.render(writer, http.StatusUnprocessableEntity, "create.tmpl", data)
+		app.render(writer, request, http.StatusUnprocessableEntity, "account_name.tmpl", data)
 		return
 	}
 
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
+	err = app.users.UpdateName(request.Context(), app.authenticatedUserId(request), form.Name)
 	if err != nil {
 		app.serverError(writer, err)
 		return
 	}
 
-	app.sessionManager.Put(request.Context(), "flash", "Snippet successfully created!")
+	app.sessionManager.Put(request.Context(), "flash", "Your name has been updated.")
 
-	http.Redirect(writer, request, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+	http.Redirect(writer, request, "/account", http.StatusSeeOther)
 }
 
-func (app *application) userSignup(writer http.ResponseWriter, request *http.Request) {
+func (app *application) accountEmailUpdate(writer http.ResponseWriter, request *http.Request) {
 	data := app.newTemplateData(request)
-	data.Form = userSignupForm{}
-	app.render(writer, http.StatusOK, "signup.tmpl", data)
+	data.Form = accountEmailUpdateForm{}
+	app.render(writer, request, http.StatusOK, "account_email.tmpl", data)
 }
 
-func (app *application) userSignupPost(writer http.ResponseWriter, request *http.Request) {
-	var form userSignupForm
+func (app *application) accountEmailUpdatePost(writer http.ResponseWriter, request *http.Request) {
+	var form accountEmailUpdateForm
 
 	err := app.decodePostForm(request, &form)
 	if err != nil {
 		app.clientError(writer, http.StatusBadRequest)
 		return
 	}
-	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+
 	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
 	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
-	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
-	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
+	form.CheckField(validator.NotBlank(form.CurrentPassword), "currentPassword", "This field cannot be blank")
 
 	if !form.Valid() {
 		data := app.newTemplateData(request)
 		data.Form = form
-		app.render(writer, http.StatusUnprocessableEntity, "signup.tmpl", data)
+		app.render(writer, request, http.StatusUnprocessableEntity, "account_email.tmpl", data)
+		return
+	}
+
+	user, err := app.users.Get(request.Context(), app.authenticatedUserId(request))
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	status, err := app.checkCurrentPassword(request, user, form.CurrentPassword, &form.Validator)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	if status != 0 {
+		data := app.newTemplateData(request)
+		data.Form = form
+		app.render(writer, request, status, "account_email.tmpl", data)
 		return
 	}
 
-	err = app.users.Insert(form.Name, form.Email, form.Password)
+	err = app.users.UpdateEmail(request.Context(), user.Id, form.Email)
 	if err != nil {
 		if errors.Is(err, models.ErrDuplicateEmail) {
 			form.AddFieldError("email", "Email address is already in use")
+
 			data := app.newTemplateData(request)
 			data.Form = form
-			app.render(writer, http.StatusUnprocessableEntity, "signup.tmpl", data)
+			app.render(writer, request, http.StatusUnprocessableEntity, "account_email.tmpl", data)
 		} else {
 			app.serverError(writer, err)
 		}
 		return
 	}
 
-	app.sessionManager.Put(request.Context(), "flash", "Your signup was successful. Plese log in.")
+	app.audit(request, models.AuditEmailChange, user.Id, map[string]string{"old_email": user.Email, "new_email": form.Email})
 
-	http.Redirect(writer, request, "/user/login", http.StatusSeeOther)
+	err = app.sendEmailVerification(request.Context(), user.Id, user.Name, form.Email, app.auditEvent(request, models.AuditTokenCreate, user.Id, nil))
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.sessionManager.Put(request.Context(), "flash", "Your email address has been changed. We've sent a link to "+form.Email+" to verify it.")
+
+	http.Redirect(writer, request, "/account", http.StatusSeeOther)
 }
 
-func (app *application) userLogin(writer http.ResponseWriter, request *http.Request) {
+func (app *application) accountPasswordUpdate(writer http.ResponseWriter, request *http.Request) {
 	data := app.newTemplateData(request)
-	data.Form = userLoginForm{}
-	app.render(writer, http.StatusOK, "login.tmpl", data)
+	data.Form = accountPasswordUpdateForm{}
+	app.render(writer, request, http.StatusOK, "account_password.tmpl", data)
 }
 
-func (app *application) userLoginPost(writer http.ResponseWriter, request *http.Request) {
-	var form userLoginForm
+func (app *application) accountPasswordUpdatePost(writer http.ResponseWriter, request *http.Request) {
+	var form accountPasswordUpdateForm
 
 	err := app.decodePostForm(request, &form)
 	if err != nil {
@@ -172,54 +1306,290 @@ func (app *application) userLoginPost(writer http.ResponseWriter, request *http.
 		return
 	}
 
-	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
-	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
-	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.CurrentPassword), "currentPassword", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.NewPassword, 8), "newPassword", "This field must be at least 8 characters long")
+	form.CheckField(form.NewPassword == form.NewPasswordConfirmation, "newPasswordConfirmation", "Passwords do not match")
 
 	if !form.Valid() {
 		data := app.newTemplateData(request)
 		data.Form = form
-		app.render(writer, http.StatusUnprocessableEntity, "login.tmpl", data)
+		app.render(writer, request, http.StatusUnprocessableEntity, "account_password.tmpl", data)
 		return
 	}
 
-	id, err := app.users.Authenticate(form.Email, form.Password)
+	user, err := app.users.Get(request.Context(), app.authenticatedUserId(request))
 	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			form.AddNonFieldError("Email or password is incorrect")
+		app.serverError(writer, err)
+		return
+	}
 
-			data := app.newTemplateData(request)
-			data.Form = form
-			app.render(writer, http.StatusUnprocessableEntity, "login.tmpl", data)
+	status, err := app.checkCurrentPassword(request, user, form.CurrentPassword, &form.Validator)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	if status != 0 {
+		data := app.newTemplateData(request)
+		data.Form = form
+		app.render(writer, request, status, "account_password.tmpl", data)
+		return
+	}
+
+	err = app.users.UpdatePassword(request.Context(), user.Id, form.NewPassword)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.audit(request, models.AuditPasswordChange, user.Id, map[string]string{"method": "update"})
+
+	err = app.sessionManager.RenewToken(request.Context())
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.sessionManager.Put(request.Context(), "flash", "Your password has been updated.")
+
+	http.Redirect(writer, request, "/account", http.StatusSeeOther)
+}
+
+func (app *application) accountSessions(writer http.ResponseWriter, request *http.Request) {
+	sessions, err := app.userSessions.List(request.Context(), app.authenticatedUserId(request))
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	data := app.newTemplateData(request)
+	data.Sessions = sessions
+	data.CurrentSession = app.currentSession(request)
+	app.render(writer, request, http.StatusOK, "account_sessions.tmpl", data)
+}
+
+func (app *application) accountSessionRevokePost(writer http.ResponseWriter, request *http.Request) {
+	var form accountSessionRevokeForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil || form.Id < 1 {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	id := form.Id
+
+	err = app.userSessions.Delete(request.Context(), app.authenticatedUserId(request), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(writer)
 		} else {
 			app.serverError(writer, err)
 		}
 		return
 	}
 
-	err = app.sessionManager.RenewToken(request.Context())
+	app.audit(request, models.AuditLogout, app.authenticatedUserId(request), map[string]string{"session_id": strconv.Itoa(id)})
+
+	if s := app.currentSession(request); s != nil && s.Id == id {
+		err = app.logOut(request)
+		if err != nil {
+			app.serverError(writer, err)
+			return
+		}
+
+		app.sessionManager.Put(request.Context(), "flash", "You've been logged out successfully!")
+		http.Redirect(writer, request, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.sessionManager.Put(request.Context(), "flash", "The session has been logged out.")
+
+	http.Redirect(writer, request, "/account/sessions", http.StatusSeeOther)
+}
+
+// accountSessionRevokeAllPost logs out every session, including this one.
+func (app *application) accountSessionRevokeAllPost(writer http.ResponseWriter, request *http.Request) {
+	err := app.userSessions.DeleteAll(request.Context(), app.authenticatedUserId(request))
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.audit(request, models.AuditLogout, app.authenticatedUserId(request), map[string]string{"session_id": "all"})
+
+	err = app.logOut(request)
 	if err != nil {
 		app.serverError(writer, err)
 		return
 	}
 
-	app.sessionManager.Put(request.Context(), "authenticatedUserId", id)
+	app.sessionManager.Put(request.Context(), "flash", "You've been logged out everywhere.")
 
-	http.Redirect(writer, request, "/snippet/create", http.StatusSeeOther)
+	http.Redirect(writer, request, "/", http.StatusSeeOther)
 }
 
-func (app *application) userLogoutPost(writer http.ResponseWriter, request *http.Request) {
-	err := app.sessionManager.RenewToken(request.Context())
+// accountTwoFactor shows the user's two-factor status, or if it is off, a
+// new secret to enrol with. The secret is kept in the session until the user
+// confirms it with a code, so reloading the page doesn't change it.
+func (app *application) accountTwoFactor(writer http.ResponseWriter, request *http.Request) {
+	user, err := app.users.Get(request.Context(), app.authenticatedUserId(request))
 	if err != nil {
 		app.serverError(writer, err)
 		return
 	}
 
-	app.sessionManager.Remove(request.Context(), "authenticatedUserId")
+	data := app.newTemplateData(request)
+	data.User = user
 
-	app.sessionManager.Put(request.Context(), "flash", "You've been logged out successfully!")
+	tf, err := app.twoFactor.Get(request.Context(), user.Id)
+	if err == nil {
+		data.TwoFactor = tf
+		data.Form = accountTwoFactorDisableForm{}
+		app.render(writer, request, http.StatusOK, "account_2fa.tmpl", data)
+		return
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(writer, err)
+		return
+	}
 
-	http.Redirect(writer, request, "/", http.StatusSeeOther)
+	secret := app.sessionManager.GetBytes(request.Context(), "twoFactorSecret")
+	if secret == nil {
+		secret, err = totp.NewSecret()
+		if err != nil {
+			app.serverError(writer, err)
+			return
+		}
+		app.sessionManager.Put(request.Context(), "twoFactorSecret", secret)
+	}
+
+	data.TwoFactorSetup, err = newTwoFactorSetup(user.Email, secret)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	data.Form = accountTwoFactorEnableForm{}
+	app.render(writer, request, http.StatusOK, "account_2fa.tmpl", data)
+}
+
+func (app *application) accountTwoFactorEnablePost(writer http.ResponseWriter, request *http.Request) {
+	secret := app.sessionManager.GetBytes(request.Context(), "twoFactorSecret")
+	if secret == nil {
+		http.Redirect(writer, request, "/account/2fa", http.StatusSeeOther)
+		return
+	}
+
+	var form accountTwoFactorEnableForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.users.Get(request.Context(), app.authenticatedUserId(request))
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	form.Code = strings.ReplaceAll(strings.TrimSpace(form.Code), " ", "")
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	step, ok := totp.Validate(secret, form.Code, time.Now())
+	form.CheckField(ok, "code", "Code is incorrect")
+
+	if !form.Valid() {
+		data := app.newTemplateData(request)
+		data.User = user
+		data.Form = form
+
+		data.TwoFactorSetup, err = newTwoFactorSetup(user.Email, secret)
+		if err != nil {
+			app.serverError(writer, err)
+			return
+		}
+
+		app.render(writer, request, http.StatusUnprocessableEntity, "account_2fa.tmpl", data)
+		return
+	}
+
+	codes, err := app.twoFactor.Enable(request.Context(), user.Id, secret, step)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.sessionManager.Remove(request.Context(), "twoFactorSecret")
+	app.audit(request, models.AuditTwoFactorEnable, user.Id, nil)
+
+	// Only hashes of the recovery codes are stored, so they are shown here
+	// once rather than after a redirect.
+	data := app.newTemplateData(request)
+	data.User = user
+	data.RecoveryCodes = codes
+	app.render(writer, request, http.StatusOK, "account_2fa.tmpl", data)
+}
+
+func (app *application) accountTwoFactorDisablePost(writer http.ResponseWriter, request *http.Request) {
+	var form accountTwoFactorDisableForm
+
+	err := app.decodePostForm(request, &form)
+	if err != nil {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.users.Get(request.Context(), app.authenticatedUserId(request))
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	tf, err := app.twoFactor.Get(request.Context(), user.Id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.Redirect(writer, request, "/account", http.StatusSeeOther)
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.CurrentPassword), "currentPassword", "This field cannot be blank")
+
+	status := http.StatusUnprocessableEntity
+	if form.Valid() {
+		status, err = app.checkCurrentPassword(request, user, form.CurrentPassword, &form.Validator)
+		if err != nil {
+			app.serverError(writer, err)
+			return
+		}
+	}
+
+	if status != 0 {
+		data := app.newTemplateData(request)
+		data.User = user
+		data.TwoFactor = tf
+		data.Form = form
+		app.render(writer, request, status, "account_2fa.tmpl", data)
+		return
+	}
+
+	err = app.twoFactor.Disable(request.Context(), user.Id)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.audit(request, models.AuditTwoFactorDisable, user.Id, nil)
+
+	app.sessionManager.Put(request.Context(), "flash", "Two-factor authentication has been turned off.")
+
+	http.Redirect(writer, request, "/account", http.StatusSeeOther)
 }
 
 func ping(writer http.ResponseWriter, request *http.Request) {
@@ -1,13 +1,28 @@
 package main
 
 import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"snippetbox.jonnevuorela.com/internal/assert"
+	"snippetbox.jonnevuorela.com/internal/mailer"
+	"snippetbox.jonnevuorela.com/internal/models"
+	"snippetbox.jonnevuorela.com/internal/models/mocks"
+	"snippetbox.jonnevuorela.com/internal/ratelimit"
+	"snippetbox.jonnevuorela.com/internal/totp"
 )
 
+var verifyLinkRX = regexp.MustCompile(`https://\S+/user/verify/\S+`)
+
 func TestUserSignup(t *testing.T) {
 	app := newTestApplication(t)
 	ts := newTestServer(t, app.routes())
@@ -17,6 +32,7 @@ func TestUserSignup(t *testing.T) {
 
 	const (
 		validName     = "Bob"
+		validHandle   = "bob"
 		validPassword = "validPa$$word"
 		validEmail    = "bob@example.com"
 		formTag       = "<form action='/user/signup' method='POST' novalidate>"
@@ -25,6 +41,7 @@ func TestUserSignup(t *testing.T) {
 	tests := []struct {
 		name         string
 		userName     string
+		userHandle   string
 		userEmail    string
 		userPassword string
 		csrfToken    string
@@ -34,6 +51,7 @@ func TestUserSignup(t *testing.T) {
 		{
 			name:         "Valid submission",
 			userName:     validName,
+			userHandle:   validHandle,
 			userEmail:    validEmail,
 			userPassword: validPassword,
 			csrfToken:    validCSRFToken,
@@ -42,6 +60,7 @@ func TestUserSignup(t *testing.T) {
 		{
 			name:         "Invalid CSRF Token",
 			userName:     validName,
+			userHandle:   validHandle,
 			userEmail:    validEmail,
 			userPassword: validPassword,
 			csrfToken:    "wrongToken",
@@ -50,6 +69,7 @@ func TestUserSignup(t *testing.T) {
 		{
 			name:         "Empty name",
 			userName:     "",
+			userHandle:   validHandle,
 			userEmail:    validEmail,
 			userPassword: validPassword,
 			csrfToken:    validCSRFToken,
@@ -59,6 +79,7 @@ func TestUserSignup(t *testing.T) {
 		{
 			name:         "Empty email",
 			userName:     validName,
+			userHandle:   validHandle,
 			userEmail:    "",
 			userPassword: validPassword,
 			csrfToken:    validCSRFToken,
@@ -68,6 +89,7 @@ func TestUserSignup(t *testing.T) {
 		{
 			name:         "Empty password",
 			userName:     validName,
+			userHandle:   validHandle,
 			userEmail:    validEmail,
 			userPassword: "",
 			csrfToken:    validCSRFToken,
@@ -77,6 +99,7 @@ func TestUserSignup(t *testing.T) {
 		{
 			name:         "Invalid email",
 			userName:     validName,
+			userHandle:   validHandle,
 			userEmail:    "bob@example.",
 			userPassword: validPassword,
 			csrfToken:    validCSRFToken,
@@ -86,15 +109,57 @@ func TestUserSignup(t *testing.T) {
 		{
 			name:         "Short password",
 			userName:     validName,
+			userHandle:   validHandle,
 			userEmail:    validEmail,
 			userPassword: "pa$$",
 			csrfToken:    validCSRFToken,
 			wantCode:     http.StatusUnprocessableEntity,
 			wantFormTag:  formTag,
 		},
+		{
+			name:         "Handle with invalid characters",
+			userName:     validName,
+			userHandle:   "bob smith",
+			userEmail:    validEmail,
+			userPassword: validPassword,
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
+		{
+			name:         "Short handle",
+			userName:     validName,
+			userHandle:   "bo",
+			userEmail:    validEmail,
+			userPassword: validPassword,
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
+		{
+			name:         "Reserved handle",
+			userName:     validName,
+			userHandle:   "Admin",
+			userEmail:    validEmail,
+			userPassword: validPassword,
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
+		{
+			name:         "Duplicate handle",
+			userName:     validName,
+			userHandle:   "alice",
+			userEmail:    validEmail,
+			userPassword: validPassword,
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
 		{
 			name:         "Duplicate email",
 			userName:     validName,
+			userHandle:   validHandle,
 			userEmail:    "dupe@example.com",
 			userPassword: validPassword,
 			csrfToken:    validCSRFToken,
@@ -106,6 +171,7 @@ func TestUserSignup(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			form := url.Values{}
 			form.Add("name", tt.userName)
+			form.Add("handle", tt.userHandle)
 			form.Add("email", tt.userEmail)
 			form.Add("password", tt.userPassword)
 			form.Add("csrf_token", tt.csrfToken)
@@ -143,6 +209,11 @@ func TestSnippetView(t *testing.T) {
 			urlPath:  "/snippet/view/2",
 			wantCode: http.StatusNotFound,
 		},
+		{
+			name:     "Private snippet",
+			urlPath:  "/snippet/view/3",
+			wantCode: http.StatusNotFound,
+		},
 		{
 			name:     "Negative Id",
 			urlPath:  "/snippet/view/-1",
@@ -178,6 +249,70 @@ func TestSnippetView(t *testing.T) {
 	}
 }
 
+func TestSnippetViewMarkdown(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name        string
+		urlPath     string
+		wantCode    int
+		wantBody    []string
+		notWantBody []string
+	}{
+		{
+			name:     "Rendered",
+			urlPath:  "/snippet/view/6",
+			wantCode: http.StatusOK,
+			wantBody: []string{
+				"<h1>Frogs</h1>",
+				"<p>They <em>jump</em>.</p>",
+				"<a href='/snippet/view/6?source=true'>View source</a>",
+			},
+			notWantBody: []string{"<script>", "&lt;script&gt;"},
+		},
+		{
+			name:     "Source",
+			urlPath:  "/snippet/view/6?source=true",
+			wantCode: http.StatusOK,
+			wantBody: []string{
+				"<code class='language-markdown'><span class=\"hl-gh\"># Frogs\n</span>\nThey <span class=\"hl-ge\">*jump*</span>.\n\n&lt;script&gt;alert(1)&lt;/script&gt;</code>",
+				"<a href='/snippet/view/6'>View rendered</a>",
+			},
+			notWantBody: []string{"<h1>Frogs</h1>"},
+		},
+		{
+			name:     "Invalid source",
+			urlPath:  "/snippet/view/6?source=maybe",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:        "Code files",
+			urlPath:     "/snippet/view/5?source=true",
+			wantCode:    http.StatusOK,
+			wantBody:    []string{"<code class='language-bash'><span class=\"hl-nb\">echo</span> splash</code>"},
+			notWantBody: []string{"View rendered"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			for _, want := range tt.wantBody {
+				assert.StringContains(t, body, want)
+			}
+			for _, notWant := range tt.notWantBody {
+				assert.Equal(t, strings.Contains(body, notWant), false)
+			}
+		})
+	}
+}
+
 func TestPing(t *testing.T) {
 	app := newTestApplication(t)
 
@@ -189,3 +324,3139 @@ func TestPing(t *testing.T) {
 	assert.Equal(t, code, http.StatusOK)
 	assert.Equal(t, body, "OK")
 }
+
+func TestHealthz(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, body := ts.get(t, "/healthz")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "application/json")
+	assert.StringContains(t, body, `"status": "ok"`)
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name         string
+		failing      bool
+		shuttingDown bool
+		wantCode     int
+		wantBody     []string
+	}{
+		{
+			name:     "Ready",
+			wantCode: http.StatusOK,
+			wantBody: []string{`"status": "ready"`, `"templates": {`, `"sessions": {`},
+		},
+		{
+			name:     "Failing component",
+			failing:  true,
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: []string{`"status": "unavailable"`, `"status": "down"`},
+		},
+		{
+			name:         "Shutting down",
+			shuttingDown: true,
+			wantCode:     http.StatusServiceUnavailable,
+			wantBody:     []string{`"status": "shutting down"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+
+			if tt.failing {
+				app.readinessChecks = append(app.readinessChecks, healthCheck{
+					name: "database",
+					check: func(ctx context.Context) error {
+						return errors.New("connection refused")
+					},
+				})
+			}
+			app.shuttingDown.Store(tt.shuttingDown)
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			code, _, body := ts.get(t, "/readyz")
+
+			assert.Equal(t, code, tt.wantCode)
+			for _, want := range tt.wantBody {
+				assert.StringContains(t, body, want)
+			}
+			assert.Equal(t, strings.Contains(body, "connection refused"), false)
+			assert.Equal(t, strings.Contains(body, "latency_ms"), false)
+
+			admin := newTestServer(t, app.adminRoutes())
+			defer admin.Close()
+
+			code, _, body = admin.get(t, "/readyz")
+			assert.Equal(t, code, tt.wantCode)
+			if tt.failing {
+				assert.StringContains(t, body, `"error": "connection refused"`)
+			}
+		})
+	}
+}
+
+func TestUserLoginPost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	validCSRFToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		userEmail    string
+		userPassword string
+		wantCode     int
+		wantBody     string
+		wantMailTo   string
+	}{
+		{
+			name:         "Invalid credentials",
+			userEmail:    "bob@example.com",
+			userPassword: "wrongPa$$word",
+			wantCode:     http.StatusUnprocessableEntity,
+			wantBody:     "Email or password is incorrect",
+		},
+		{
+			name:         "Failure triggers lockout",
+			userEmail:    "alice@example.com",
+			userPassword: "wrongPa$$word",
+			wantCode:     http.StatusUnprocessableEntity,
+			wantBody:     "This account is temporarily locked, please try again in 15 minutes.",
+			wantMailTo:   "alice@example.com",
+		},
+		{
+			name:         "Locked account",
+			userEmail:    "locked@example.com",
+			userPassword: "pa$$word",
+			wantCode:     http.StatusTooManyRequests,
+			wantBody:     "This account is temporarily locked, please try again in 15 minutes.",
+		},
+		{
+			name:         "Backoff",
+			userEmail:    "slow@example.com",
+			userPassword: "pa$$word",
+			wantCode:     http.StatusTooManyRequests,
+			wantBody:     "Please wait 8 seconds before trying again.",
+		},
+		{
+			name:         "Disabled account",
+			userEmail:    "disabled@example.com",
+			userPassword: "pa$$word",
+			wantCode:     http.StatusUnprocessableEntity,
+			wantBody:     "This account has been disabled",
+		},
+		{
+			name:         "Valid credentials",
+			userEmail:    "alice@example.com",
+			userPassword: "pa$$word",
+			wantCode:     http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mail := &mailer.MemoryMailer{}
+			app.mailer = mail
+
+			form := url.Values{}
+			form.Add("email", tt.userEmail)
+			form.Add("password", tt.userPassword)
+			form.Add("csrf_token", validCSRFToken)
+
+			code, _, body := ts.postForm(t, "/user/login", form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+
+			app.wg.Wait()
+
+			sent := mail.Sent()
+			if tt.wantMailTo == "" {
+				assert.Equal(t, len(sent), 0)
+				return
+			}
+
+			assert.Equal(t, len(sent), 1)
+			assert.Equal(t, sent[0].To, tt.wantMailTo)
+			assert.Equal(t, sent[0].Subject, "Your Snippetbox account has been locked")
+		})
+	}
+}
+
+func TestUserPasswordForgotPost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/password/forgot")
+	validCSRFToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name      string
+		userEmail string
+		wantCode  int
+		wantMail  bool
+	}{
+		{
+			name:      "Registered email",
+			userEmail: "alice@example.com",
+			wantCode:  http.StatusSeeOther,
+			wantMail:  true,
+		},
+		{
+			name:      "Unknown email",
+			userEmail: "nobody@example.com",
+			wantCode:  http.StatusSeeOther,
+		},
+		{
+			name:      "Invalid email",
+			userEmail: "nobody@example.",
+			wantCode:  http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mail := &mailer.MemoryMailer{}
+			app.mailer = mail
+
+			form := url.Values{}
+			form.Add("email", tt.userEmail)
+			form.Add("csrf_token", validCSRFToken)
+
+			code, header, _ := ts.postForm(t, "/user/password/forgot", form)
+			app.wg.Wait()
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if code == http.StatusSeeOther {
+				assert.Equal(t, header.Get("Location"), "/user/login")
+			}
+
+			sent := mail.Sent()
+			if !tt.wantMail {
+				assert.Equal(t, len(sent), 0)
+				return
+			}
+
+			assert.Equal(t, len(sent), 1)
+			assert.Equal(t, sent[0].To, tt.userEmail)
+			assert.StringContains(t, sent[0].Body, "https://snippetbox.test/user/password/reset/valid-token")
+		})
+	}
+}
+
+func TestUserPasswordResetPost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/password/reset/valid-token")
+	validCSRFToken := extractCSRFToken(t, body)
+	assert.StringContains(t, body, "<form action='/user/password/reset/valid-token' method='POST' novalidate>")
+
+	tests := []struct {
+		name                 string
+		token                string
+		password             string
+		passwordConfirmation string
+		wantCode             int
+		wantBody             string
+	}{
+		{
+			name:                 "Mismatched passwords",
+			token:                "valid-token",
+			password:             "newPa$$word",
+			passwordConfirmation: "otherPa$$word",
+			wantCode:             http.StatusUnprocessableEntity,
+			wantBody:             "Passwords do not match",
+		},
+		{
+			name:                 "Short password",
+			token:                "valid-token",
+			password:             "pa$$",
+			passwordConfirmation: "pa$$",
+			wantCode:             http.StatusUnprocessableEntity,
+			wantBody:             "This field must be at least 8 characters long",
+		},
+		{
+			name:                 "Invalid token",
+			token:                "expired-token",
+			password:             "newPa$$word",
+			passwordConfirmation: "newPa$$word",
+			wantCode:             http.StatusUnprocessableEntity,
+			wantBody:             "This password reset link is invalid or has expired.",
+		},
+		{
+			name:                 "Valid token",
+			token:                "valid-token",
+			password:             "newPa$$word",
+			passwordConfirmation: "newPa$$word",
+			wantCode:             http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("password", tt.password)
+			form.Add("password_confirmation", tt.passwordConfirmation)
+			form.Add("csrf_token", validCSRFToken)
+
+			code, _, body := ts.postForm(t, "/user/password/reset/"+tt.token, form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestUserPasswordResetEndsSessions(t *testing.T) {
+	app := newTestApplication(t)
+
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+
+	other.login(t, "alice@example.com", "pa$$word")
+
+	code, _, _ := other.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusOK)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/password/reset/valid-token")
+
+	form := url.Values{}
+	form.Add("password", "newPa$$word")
+	form.Add("password_confirmation", "newPa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, _, _ = ts.postForm(t, "/user/password/reset/valid-token", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	code, header, _ := other.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+}
+
+func TestEmailVerification(t *testing.T) {
+	app := newTestApplication(t)
+	mail := &mailer.MemoryMailer{}
+	app.mailer = mail
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/signup")
+
+	form := url.Values{}
+	form.Add("name", "Carol")
+	form.Add("handle", "carol2")
+	form.Add("email", "carol@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, _, _ := ts.postForm(t, "/user/signup", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	app.wg.Wait()
+
+	sent := mail.Sent()
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].To, "carol@example.com")
+	assert.Equal(t, sent[0].Subject, "Verify your Snippetbox email address")
+
+	link := verifyLinkRX.FindString(sent[0].Body)
+	assert.Equal(t, link, "https://snippetbox.test/user/verify/verify-token")
+
+	code, header, _ := ts.get(t, strings.TrimPrefix(link, "https://snippetbox.test"))
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/")
+
+	_, _, body = ts.get(t, "/")
+	assert.StringContains(t, body, "Your email address has been verified.")
+
+	code, header, _ = ts.get(t, "/user/verify/used-token")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/verify")
+
+	// A link sent to the address carol had before doesn't verify the new one.
+	ts.login(t, "carol@example.com", "pa$$word")
+
+	code, header, _ = ts.get(t, "/user/verify/stale-token")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/verify")
+
+	_, _, body = ts.get(t, "/user/verify")
+	assert.StringContains(t, body, "Your email address has changed since this link was sent. Please request a new one.")
+}
+
+func TestUserVerifyResendPost(t *testing.T) {
+	app := newTestApplication(t)
+	mail := &mailer.MemoryMailer{}
+	app.mailer = mail
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "carol@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/user/verify")
+	assert.StringContains(t, body, "hasn't been verified yet")
+
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+
+	code, header, _ := ts.postForm(t, "/user/verify", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/verify")
+
+	sent := mail.Sent()
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].To, "carol@example.com")
+	assert.StringContains(t, sent[0].Body, "https://snippetbox.test/user/verify/verify-token")
+}
+
+func TestSnippetCreatePost(t *testing.T) {
+	tests := []struct {
+		name      string
+		userEmail string
+		public    string
+		tags      string
+		wantCode  int
+		wantBody  string
+	}{
+		{
+			name:      "Verified public",
+			userEmail: "alice@example.com",
+			public:    "true",
+			wantCode:  http.StatusSeeOther,
+		},
+		{
+			name:      "Unverified private",
+			userEmail: "carol@example.com",
+			public:    "false",
+			wantCode:  http.StatusSeeOther,
+		},
+		{
+			name:      "Unverified public",
+			userEmail: "carol@example.com",
+			public:    "true",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantBody:  "You need to verify your email address before publishing public snippets",
+		},
+		{
+			name:      "Tags",
+			userEmail: "alice@example.com",
+			public:    "true",
+			tags:      "go, Deploy go",
+			wantCode:  http.StatusSeeOther,
+		},
+		{
+			name:      "Invalid tag",
+			userEmail: "alice@example.com",
+			public:    "true",
+			tags:      "c++",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantBody:  "Tags can only contain letters, numbers and -, and be up to 32 characters long",
+		},
+		{
+			name:      "Too many tags",
+			userEmail: "alice@example.com",
+			public:    "true",
+			tags:      "a b c d e f",
+			wantCode:  http.StatusUnprocessableEntity,
+			wantBody:  "A snippet can&#39;t have more than 5 tags",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			csrfToken := ts.login(t, tt.userEmail, "pa$$word")
+
+			form := url.Values{}
+			form.Add("title", "O snail")
+			form.Add("files[0].content", "O snail\nClimb Mount Fuji")
+			form.Add("expires", "7")
+			form.Add("public", tt.public)
+			form.Add("tags", tt.tags)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAccountView(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/account")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/account")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "Alice Jones")
+	assert.StringContains(t, body, "alice@example.com")
+}
+
+func TestAccountPasswordUpdatePost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	tests := []struct {
+		name                    string
+		currentPassword         string
+		newPassword             string
+		newPasswordConfirmation string
+		wantCode                int
+		wantBody                string
+	}{
+		{
+			name:                    "Wrong current password",
+			currentPassword:         "wrongPa$$word",
+			newPassword:             "newPa$$word",
+			newPasswordConfirmation: "newPa$$word",
+			wantCode:                http.StatusUnprocessableEntity,
+			wantBody:                "Current password is incorrect",
+		},
+		{
+			name:                    "Mismatched passwords",
+			currentPassword:         "pa$$word",
+			newPassword:             "newPa$$word",
+			newPasswordConfirmation: "otherPa$$word",
+			wantCode:                http.StatusUnprocessableEntity,
+			wantBody:                "Passwords do not match",
+		},
+		{
+			name:                    "Short password",
+			currentPassword:         "pa$$word",
+			newPassword:             "pa$$",
+			newPasswordConfirmation: "pa$$",
+			wantCode:                http.StatusUnprocessableEntity,
+			wantBody:                "This field must be at least 8 characters long",
+		},
+		{
+			name:                    "Valid",
+			currentPassword:         "pa$$word",
+			newPassword:             "newPa$$word",
+			newPasswordConfirmation: "newPa$$word",
+			wantCode:                http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := ts.sessionCookie(t)
+
+			form := url.Values{}
+			form.Add("current_password", tt.currentPassword)
+			form.Add("new_password", tt.newPassword)
+			form.Add("new_password_confirmation", tt.newPasswordConfirmation)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/account/password/update", form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+
+			if code == http.StatusSeeOther {
+				assert.Equal(t, ts.sessionCookie(t) != before, true)
+			}
+		})
+	}
+}
+
+// lockedLoginAttempts reports every subject as locked out.
+type lockedLoginAttempts struct {
+	mocks.LoginAttemptModel
+}
+
+func (m *lockedLoginAttempts) Check(ctx context.Context, email, ip string) (*models.LoginBlock, error) {
+	return &models.LoginBlock{Until: time.Now().Add(15 * time.Minute), Locked: true}, nil
+}
+
+func TestAccountPasswordUpdateThrottle(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	form := url.Values{}
+	form.Add("current_password", "wrongPa$$word")
+	form.Add("new_password", "newPa$$word")
+	form.Add("new_password_confirmation", "newPa$$word")
+	form.Add("csrf_token", csrfToken)
+
+	// The failure that locks the account says so.
+	code, _, body := ts.postForm(t, "/account/password/update", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "Current password is incorrect")
+	assert.StringContains(t, body, "This account is temporarily locked, please try again in 15 minutes.")
+
+	// Once locked, even the right password isn't checked.
+	app.loginAttempts = &lockedLoginAttempts{}
+
+	form.Set("current_password", "pa$$word")
+
+	code, _, body = ts.postForm(t, "/account/password/update", form)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.StringContains(t, body, "This account is temporarily locked, please try again in 15 minutes.")
+}
+
+func TestAccountEmailUpdatePost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	tests := []struct {
+		name            string
+		email           string
+		currentPassword string
+		wantCode        int
+		wantBody        string
+		wantMail        bool
+	}{
+		{
+			name:            "Invalid email",
+			email:           "alice@example.",
+			currentPassword: "pa$$word",
+			wantCode:        http.StatusUnprocessableEntity,
+			wantBody:        "This field must be a valid email address",
+		},
+		{
+			name:            "Wrong current password",
+			email:           "alice@example.org",
+			currentPassword: "wrongPa$$word",
+			wantCode:        http.StatusUnprocessableEntity,
+			wantBody:        "Current password is incorrect",
+		},
+		{
+			name:            "Duplicate email",
+			email:           "dupe@example.com",
+			currentPassword: "pa$$word",
+			wantCode:        http.StatusUnprocessableEntity,
+			wantBody:        "Email address is already in use",
+		},
+		{
+			name:            "Valid",
+			email:           "alice@example.org",
+			currentPassword: "pa$$word",
+			wantCode:        http.StatusSeeOther,
+			wantMail:        true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mail := &mailer.MemoryMailer{}
+			app.mailer = mail
+
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("current_password", tt.currentPassword)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/account/email/update", form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+
+			sent := mail.Sent()
+			if !tt.wantMail {
+				assert.Equal(t, len(sent), 0)
+				return
+			}
+
+			assert.Equal(t, len(sent), 1)
+			assert.Equal(t, sent[0].To, tt.email)
+			assert.StringContains(t, sent[0].Body, "https://snippetbox.test/user/verify/verify-token")
+
+			events, err := app.auditEvents.List(context.Background(), models.AuditFilter{Action: models.AuditEmailChange})
+			assert.NilError(t, err)
+			assert.Equal(t, len(events), 1)
+			assert.Equal(t, events[0].ActorId, 1)
+			assert.Equal(t, events[0].Details["old_email"], "alice@example.com")
+			assert.Equal(t, events[0].Details["new_email"], tt.email)
+		})
+	}
+}
+
+func TestAccountNameUpdatePost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/account/name/update")
+	assert.StringContains(t, body, "value='Alice Jones'")
+
+	tests := []struct {
+		name     string
+		userName string
+		wantCode int
+	}{
+		{
+			name:     "Empty name",
+			userName: " ",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Valid",
+			userName: "Alice Smith",
+			wantCode: http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("name", tt.userName)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, "/account/name/update", form)
+
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestUserLoginTwoFactor(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("email", "dave@example.com")
+	form.Add("password", "pa$$word")
+	form.Add("csrf_token", csrfToken)
+
+	code, header, _ := ts.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login/2fa")
+
+	// The password alone doesn't log the session in.
+	code, header, _ = ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	_, _, body = ts.get(t, "/user/login/2fa")
+	csrfToken = extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		code         string
+		wantCode     int
+		wantBody     string
+		wantLocation string
+	}{
+		{
+			name:     "Blank code",
+			code:     "",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+		{
+			name:     "Wrong code",
+			code:     "654321",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Code is incorrect",
+		},
+		{
+			name:     "Wrong recovery code",
+			code:     "zzzz-zzzz-zzzz-zzzz",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Code is incorrect",
+		},
+		{
+			name:         "Valid code",
+			code:         "123 456",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/create",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("code", tt.code)
+			form.Add("csrf_token", csrfToken)
+
+			code, header, body := ts.postForm(t, "/user/login/2fa", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+
+	code, _, _ = ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusOK)
+
+	code, header, _ = ts.get(t, "/user/login/2fa")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+}
+
+func TestUserLoginTwoFactorRecoveryCode(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "dave@example.com", "pa$$word")
+
+	form := url.Values{}
+	form.Add("code", "aaaa-bbbb-cccc-dddd")
+	form.Add("csrf_token", csrfToken)
+
+	code, header, _ := ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/snippet/create")
+
+	code, _, body := ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "You used a recovery code. You have 10 left.")
+}
+
+func TestUserLoginTwoFactorAttempts(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "dave@example.com", "pa$$word")
+
+	form := url.Values{}
+	form.Add("code", "000000")
+	form.Add("csrf_token", csrfToken)
+
+	for range twoFactorMaxAttempts - 1 {
+		code, _, _ := ts.postForm(t, "/user/login/2fa", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	}
+
+	code, header, _ := ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	// The right code no longer works without the password again.
+	form.Set("code", "123456")
+
+	code, header, _ = ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	code, _, _ = ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+}
+
+var totpKeyRX = regexp.MustCompile(`<code class='totp-key'>([A-Z2-7 ]+)</code>`)
+
+func TestAccountTwoFactorEnablePost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/account/2fa")
+	assert.StringContains(t, body, "<img src='data:image/png;base64,")
+
+	matches := totpKeyRX.FindStringSubmatch(body)
+	if len(matches) < 2 {
+		t.Fatal("no TOTP key found in body")
+	}
+	key := matches[1]
+
+	// Reloading the page keeps the same secret.
+	_, _, body = ts.get(t, "/account/2fa")
+	assert.StringContains(t, body, key)
+
+	secret, err := totp.Encoding.DecodeString(strings.ReplaceAll(key, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Wrong code",
+			code:     totp.Code(secret, totp.Step(time.Now())-5),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Code is incorrect",
+		},
+		{
+			name:     "Valid code",
+			code:     totp.Code(secret, totp.Step(time.Now())),
+			wantCode: http.StatusOK,
+			wantBody: "aaaa-bbbb-cccc-dddd",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("code", tt.code)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/account/2fa/enable", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+
+	// The pending secret is gone once enrolment is confirmed.
+	_, _, body = ts.get(t, "/account/2fa")
+	if strings.Contains(body, key) {
+		t.Errorf("enrolment page still shows the confirmed secret")
+	}
+}
+
+func TestAccountTwoFactorDisablePost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "dave@example.com", "pa$$word")
+
+	form := url.Values{}
+	form.Add("code", "123456")
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ := ts.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	_, _, body := ts.get(t, "/account")
+	assert.StringContains(t, body, "<td>On</td>")
+
+	tests := []struct {
+		name            string
+		currentPassword string
+		locked          bool
+		wantCode        int
+		wantBody        string
+	}{
+		{
+			name:            "Wrong password",
+			currentPassword: "wrongPa$$word",
+			wantCode:        http.StatusUnprocessableEntity,
+			wantBody:        "Current password is incorrect",
+		},
+		{
+			name:            "Locked out",
+			currentPassword: "pa$$word",
+			locked:          true,
+			wantCode:        http.StatusTooManyRequests,
+			wantBody:        "This account is temporarily locked",
+		},
+		{
+			name:            "Valid",
+			currentPassword: "pa$$word",
+			wantCode:        http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.locked {
+				app.loginAttempts = &lockedLoginAttempts{}
+			} else {
+				app.loginAttempts = &mocks.LoginAttemptModel{}
+			}
+
+			form := url.Values{}
+			form.Add("current_password", tt.currentPassword)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/account/2fa/disable", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+var sessionIdRX = regexp.MustCompile(`name='id' value='(\d+)'`)
+
+func TestAccountSessions(t *testing.T) {
+	app := newTestApplication(t)
+
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+
+	other.login(t, "alice@example.com", "pa$$word")
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/account/sessions")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, len(sessionIdRX.FindAllString(body, -1)), 2)
+	assert.Equal(t, strings.Count(body, "(this session)"), 1)
+
+	tests := []struct {
+		name         string
+		id           string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:     "Invalid id",
+			id:       "abc",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Unknown session",
+			id:       "99",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:         "Other session",
+			id:           "1",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/account/sessions",
+		},
+		{
+			name:     "Already revoked",
+			id:       "1",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("id", tt.id)
+			form.Add("csrf_token", csrfToken)
+
+			code, header, _ := ts.postForm(t, "/account/sessions/revoke", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+		})
+	}
+
+	code, header, _ := other.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	code, _, _ = ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusOK)
+}
+
+func TestAccountSessionRevokeAllPost(t *testing.T) {
+	app := newTestApplication(t)
+
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+
+	other.login(t, "alice@example.com", "pa$$word")
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ := ts.postForm(t, "/account/sessions/revoke-all", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	_, _, body := ts.get(t, "/")
+	assert.StringContains(t, body, "You&#39;ve been logged out everywhere.")
+
+	for _, server := range []*testServer{ts, other} {
+		code, header, _ := server.get(t, "/snippet/create")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	}
+}
+
+func TestUserLoginRememberMe(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name        string
+		rememberMe  string
+		wantPersist bool
+	}{
+		{
+			name: "Browser session",
+		},
+		{
+			name:        "Remember me",
+			rememberMe:  "true",
+			wantPersist: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			_, _, body := ts.get(t, "/user/login")
+
+			form := url.Values{}
+			form.Add("email", "alice@example.com")
+			form.Add("password", "pa$$word")
+			form.Add("remember_me", tt.rememberMe)
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, header, _ := ts.postForm(t, "/user/login", form)
+			assert.Equal(t, code, http.StatusSeeOther)
+
+			var cookie string
+			for _, c := range header.Values("Set-Cookie") {
+				if strings.HasPrefix(c, "session=") {
+					cookie = c
+				}
+			}
+			assert.Equal(t, strings.Contains(cookie, "Expires="), tt.wantPersist)
+
+			sessions, err := app.userSessions.List(context.Background(), 1)
+			assert.NilError(t, err)
+			assert.Equal(t, sessions[0].Remember, tt.wantPersist)
+		})
+	}
+}
+
+// disabledAuthUsers has every account disabled when its password is checked,
+// as if an admin disabled it after the user logged in.
+type disabledAuthUsers struct {
+	mocks.UserModel
+}
+
+func (m *disabledAuthUsers) Authenticate(ctx context.Context, email, password string) (int, error) {
+	return 0, models.ErrAccountDisabled
+}
+
+func TestRequireReauthentication(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, _ := ts.get(t, "/account/password/update")
+	assert.Equal(t, code, http.StatusOK)
+
+	// Move the time the password was entered back past the window.
+	ctx, err := app.sessionManager.Load(context.Background(), ts.sessionCookie(t))
+	assert.NilError(t, err)
+	app.sessionManager.Put(ctx, "authenticatedAt", time.Now().Add(-reauthenticationWindow-time.Minute).Unix())
+	_, _, err = app.sessionManager.Commit(ctx)
+	assert.NilError(t, err)
+
+	code, header, _ := ts.get(t, "/account/password/update")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/reauthenticate")
+
+	// Pages that aren't sensitive still work.
+	code, _, _ = ts.get(t, "/account")
+	assert.Equal(t, code, http.StatusOK)
+
+	tests := []struct {
+		name         string
+		password     string
+		locked       bool
+		disabled     bool
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:     "Wrong password",
+			password: "wrongPa$$word",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Current password is incorrect",
+		},
+		{
+			name:     "Locked out",
+			password: "pa$$word",
+			locked:   true,
+			wantCode: http.StatusTooManyRequests,
+			wantBody: "This account is temporarily locked",
+		},
+		{
+			name:     "Disabled since login",
+			password: "pa$$word",
+			disabled: true,
+			wantCode: http.StatusForbidden,
+			wantBody: "This account has been disabled",
+		},
+		{
+			name:         "Valid",
+			password:     "pa$$word",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/account/password/update",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app.loginAttempts = &mocks.LoginAttemptModel{}
+			if tt.locked {
+				app.loginAttempts = &lockedLoginAttempts{}
+			}
+			app.users = &mocks.UserModel{}
+			if tt.disabled {
+				app.users = &disabledAuthUsers{}
+			}
+
+			form := url.Values{}
+			form.Add("password", tt.password)
+			form.Add("csrf_token", csrfToken)
+
+			code, header, body := ts.postForm(t, "/user/reauthenticate", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+
+	code, _, _ = ts.get(t, "/account/password/update")
+	assert.Equal(t, code, http.StatusOK)
+}
+
+func TestUserLoginRedirect(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name         string
+		visit        string
+		loginURL     string
+		email        string
+		wantLocation string
+	}{
+		{
+			name:         "Default",
+			loginURL:     "/user/login",
+			wantLocation: "/snippet/create",
+		},
+		{
+			name:         "Protected page",
+			visit:        "/account/sessions?sort=recent",
+			loginURL:     "/user/login",
+			wantLocation: "/account/sessions?sort=recent",
+		},
+		{
+			name:         "Protected page with two-factor login",
+			visit:        "/account",
+			loginURL:     "/user/login",
+			email:        "dave@example.com",
+			wantLocation: "/account",
+		},
+		{
+			name:         "Next parameter",
+			loginURL:     "/user/login?next=%2Fsnippet%2Fview%2F1",
+			wantLocation: "/snippet/view/1",
+		},
+		{
+			name:         "Absolute URL",
+			loginURL:     "/user/login?next=https%3A%2F%2Fevil.example.com%2F",
+			wantLocation: "/snippet/create",
+		},
+		{
+			name:         "Protocol-relative URL",
+			loginURL:     "/user/login?next=%2F%2Fevil.example.com",
+			wantLocation: "/snippet/create",
+		},
+		{
+			name:         "Backslash URL",
+			loginURL:     "/user/login?next=%2F%5Cevil.example.com",
+			wantLocation: "/snippet/create",
+		},
+		{
+			name:         "Relative path",
+			loginURL:     "/user/login?next=evil.example.com",
+			wantLocation: "/snippet/create",
+		},
+		{
+			name:         "Javascript URL",
+			loginURL:     "/user/login?next=javascript%3Aalert(1)",
+			wantLocation: "/snippet/create",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			if tt.visit != "" {
+				code, header, _ := ts.get(t, tt.visit)
+				assert.Equal(t, code, http.StatusSeeOther)
+				assert.Equal(t, header.Get("Location"), "/user/login")
+			}
+
+			email := tt.email
+			if email == "" {
+				email = "alice@example.com"
+			}
+
+			_, _, body := ts.get(t, tt.loginURL)
+			csrfToken := extractCSRFToken(t, body)
+
+			form := url.Values{}
+			form.Add("email", email)
+			form.Add("password", "pa$$word")
+			form.Add("csrf_token", csrfToken)
+
+			code, header, _ := ts.postForm(t, "/user/login", form)
+			assert.Equal(t, code, http.StatusSeeOther)
+
+			if header.Get("Location") == "/user/login/2fa" {
+				form := url.Values{}
+				form.Add("code", "123456")
+				form.Add("csrf_token", csrfToken)
+
+				code, header, _ = ts.postForm(t, "/user/login/2fa", form)
+				assert.Equal(t, code, http.StatusSeeOther)
+			}
+
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name         string
+		userEmail    string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Anonymous",
+			urlPath:      "/admin",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/login",
+		},
+		{
+			name:      "User",
+			userEmail: "alice@example.com",
+			urlPath:   "/admin",
+			wantCode:  http.StatusForbidden,
+		},
+		{
+			name:      "Moderator",
+			userEmail: "mod@example.com",
+			urlPath:   "/admin/snippets",
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:      "Moderator managing users",
+			userEmail: "mod@example.com",
+			urlPath:   "/admin/users",
+			wantCode:  http.StatusForbidden,
+		},
+		{
+			name:      "Admin",
+			userEmail: "admin@example.com",
+			urlPath:   "/admin/users",
+			wantCode:  http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			if tt.userEmail != "" {
+				ts.login(t, tt.userEmail, "pa$$word")
+			}
+
+			code, header, _ := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+		})
+	}
+}
+
+func TestAdminSnippets(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "mod@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/snippet/view/3")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "Only for Alice")
+
+	_, _, body = ts.get(t, "/admin/snippets")
+	assert.StringContains(t, body, "Private notes")
+
+	tests := []struct {
+		name     string
+		id       string
+		wantCode int
+	}{
+		{
+			name:     "Private snippet",
+			id:       "3",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Non-existent snippet",
+			id:       "2",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Invalid id",
+			id:       "foo",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("id", tt.id)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, "/admin/snippets/delete", form)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestAdminUsers(t *testing.T) {
+	app := newTestApplication(t)
+
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+
+	other.login(t, "alice@example.com", "pa$$word")
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "admin@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/admin/users")
+	assert.StringContains(t, body, "Dan Disabled (disabled)")
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		id       string
+		role     string
+		wantCode int
+	}{
+		{
+			name:     "Change role",
+			urlPath:  "/admin/users/role",
+			id:       "1",
+			role:     "moderator",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Unknown role",
+			urlPath:  "/admin/users/role",
+			id:       "1",
+			role:     "owner",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Own account",
+			urlPath:  "/admin/users/role",
+			id:       "5",
+			role:     "user",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Non-existent user",
+			urlPath:  "/admin/users/disable",
+			id:       "99",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Enable",
+			urlPath:  "/admin/users/enable",
+			id:       "6",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Disable",
+			urlPath:  "/admin/users/disable",
+			id:       "1",
+			wantCode: http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("id", tt.id)
+			form.Add("role", tt.role)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+
+	// Disabling Alice logged out her existing session.
+	code, header, _ := other.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+}
+
+func TestAdminAudit(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+
+	form := url.Values{}
+	form.Add("email", "bob@example.com")
+	form.Add("password", "wrongPa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	ts.postForm(t, "/user/login", form)
+
+	ts.login(t, "admin@example.com", "pa$$word")
+
+	events, err := app.auditEvents.List(context.Background(), models.AuditFilter{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 2)
+	assert.Equal(t, events[0].Action, models.AuditLogin)
+	assert.Equal(t, events[0].ActorId, 5)
+	assert.Equal(t, events[1].Action, models.AuditLoginFailed)
+	assert.Equal(t, events[1].Details["email"], "bob@example.com")
+
+	tests := []struct {
+		name            string
+		query           string
+		wantCode        int
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "Page",
+			query:           "",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "bob@example.com",
+		},
+		{
+			name:            "Filtered by actor",
+			query:           "?actor=admin@example.com&action=login_failed",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "There are no matching events.",
+		},
+		{
+			name:            "CSV",
+			query:           "?action=login_failed&format=csv",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/csv; charset=utf-8",
+			wantBody:        `login_failed,0,,`,
+		},
+		{
+			name:            "JSON",
+			query:           "?action=login&format=json",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/json",
+			wantBody:        `"actor_id": 5`,
+		},
+		{
+			name:     "Unknown action",
+			query:    "?action=reboot",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must be a known action",
+		},
+		{
+			name:     "Invalid date",
+			query:    "?from=yesterday",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must be a date",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.get(t, "/admin/audit"+tt.query)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+
+			if tt.wantContentType != "" {
+				assert.Equal(t, header.Get("Content-Type"), tt.wantContentType)
+			}
+		})
+	}
+}
+
+func TestCSVSafe(t *testing.T) {
+	assert.Equal(t, csvSafe("=HYPERLINK(\"x\")"), "'=HYPERLINK(\"x\")")
+	assert.Equal(t, csvSafe("Mozilla/5.0"), "Mozilla/5.0")
+	assert.Equal(t, csvSafe(""), "")
+}
+
+func TestUserProfile(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Profile",
+			urlPath:  "/u/alice",
+			wantCode: http.StatusOK,
+			wantBody: "An old silet pond",
+		},
+		{
+			name:     "Handle",
+			urlPath:  "/u/alice",
+			wantCode: http.StatusOK,
+			wantBody: "@alice",
+		},
+		{
+			name:     "No snippets",
+			urlPath:  "/u/carol",
+			wantCode: http.StatusOK,
+			wantBody: "Carol Smith hasn't shared any snippets.",
+		},
+		{
+			name:     "Later page",
+			urlPath:  "/u/alice?page=2",
+			wantCode: http.StatusOK,
+			wantBody: "?page=1'>Newer</a>",
+		},
+		{
+			name:     "Invalid page",
+			urlPath:  "/u/alice?page=0",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Disabled user",
+			urlPath:  "/u/dan",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Unknown handle",
+			urlPath:  "/u/nobody",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+
+	_, _, body := ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, "By <a href='/u/alice'>Alice Jones</a>")
+}
+
+func TestUserSnippets(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/user/snippets?sort=title")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<strong>Title</strong>")
+	assert.StringContains(t, body, "<span class='badge badge-expired'>expired</span>")
+	assert.StringContains(t, body, "<span class='badge badge-live'>live</span>")
+	assert.StringContains(t, body, "<span class='badge badge-expiring'>expiring soon</span>")
+	assert.StringContains(t, body, "<span class='badge'>private</span>")
+
+	tests := []struct {
+		name     string
+		ids      []string
+		action   string
+		days     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Delete",
+			ids:      []string{"1", "3"},
+			action:   "delete",
+			wantCode: http.StatusSeeOther,
+			wantBody: "2 snippets deleted.",
+		},
+		{
+			name:     "Extend",
+			ids:      []string{"4"},
+			action:   "extend",
+			days:     "7",
+			wantCode: http.StatusSeeOther,
+			wantBody: "1 snippet extended.",
+		},
+		{
+			name:     "Someone else's snippet",
+			ids:      []string{"99"},
+			action:   "private",
+			wantCode: http.StatusSeeOther,
+			wantBody: "0 snippets made private.",
+		},
+		{
+			name:     "Invalid days",
+			ids:      []string{"4"},
+			action:   "extend",
+			days:     "30",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field must equal 1, 7 or 365",
+		},
+		{
+			name:     "Nothing selected",
+			action:   "delete",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Select at least one snippet",
+		},
+		{
+			name:     "Unknown action",
+			ids:      []string{"1"},
+			action:   "archive",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Choose an action",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form["id"] = tt.ids
+			form.Add("action", tt.action)
+			form.Add("days", tt.days)
+			form.Add("sort", "title")
+			form.Add("csrf_token", csrfToken)
+
+			code, header, body := ts.postForm(t, "/user/snippets", form)
+			assert.Equal(t, code, tt.wantCode)
+
+			if code == http.StatusSeeOther {
+				assert.Equal(t, header.Get("Location"), "/user/snippets?sort=title")
+				_, _, body = ts.get(t, header.Get("Location"))
+			}
+
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestUserSnippetsDeleteAudit(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	form := url.Values{}
+	form["id"] = []string{"1", "99", "3"}
+	form.Add("action", "delete")
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ := ts.postForm(t, "/user/snippets", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	events, err := app.auditEvents.List(context.Background(), models.AuditFilter{Action: models.AuditSnippetDelete})
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 1)
+	assert.Equal(t, events[0].Details["snippet_ids"], "1,3")
+}
+
+func TestUserSnippetsPublishUnverified(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "carol@example.com", "pa$$word")
+
+	form := url.Values{}
+	form.Add("id", "1")
+	form.Add("action", "public")
+	form.Add("csrf_token", csrfToken)
+
+	code, _, body := ts.postForm(t, "/user/snippets", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "You need to verify your email address before publishing public snippets")
+}
+
+func TestSnippetFork(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, "<h3>1 fork</h3>")
+	assert.StringContains(t, body, "<a href='/snippet/view/5'>A newer pond</a>")
+	assert.Equal(t, strings.Contains(body, "?fork=1"), false)
+
+	_, _, body = ts.get(t, "/snippet/view/5")
+	assert.StringContains(t, body, "Forked from <a href='/snippet/view/1'>An old silet pond</a>")
+
+	csrfToken := ts.login(t, "carol@example.com", "pa$$word")
+
+	_, _, body = ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, "<a href='/snippet/create?fork=1'>Fork</a>")
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Public snippet",
+			urlPath:  "/snippet/create?fork=1",
+			wantCode: http.StatusOK,
+			wantBody: "<textarea name='files[0].content'>An old silent pond...</textarea>",
+		},
+		{
+			name:     "Tags",
+			urlPath:  "/snippet/create?fork=1",
+			wantCode: http.StatusOK,
+			wantBody: "<input type='text' name='tags' value='haiku pond'",
+		},
+		{
+			name:     "Someone else's private snippet",
+			urlPath:  "/snippet/create?fork=3",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Non-existent snippet",
+			urlPath:  "/snippet/create?fork=2",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Invalid id",
+			urlPath:  "/snippet/create?fork=foo",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+				assert.StringContains(t, body, "<input type='hidden' name='forked_from' value='1'>")
+			}
+		})
+	}
+
+	form := url.Values{}
+	form.Add("title", "An old silet pond")
+	form.Add("files[0].content", "An old silent pond...")
+	form.Add("expires", "7")
+	form.Add("public", "false")
+	form.Add("forked_from", "1")
+	form.Add("csrf_token", csrfToken)
+
+	code, header, _ := ts.postForm(t, "/snippet/create", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/snippet/view/2")
+}
+
+func TestSnippetComments(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/")
+	assert.StringContains(t, body, "<td>2</td>")
+
+	_, _, body = ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, "<p>What a <em>lovely</em> pond.</p>")
+	assert.StringContains(t, body, "<div class='comment' id='comment-2'>")
+	assert.StringContains(t, body, "<a href='/user/login'>Log in</a> to comment.")
+	assert.Equal(t, strings.Contains(body, "/comment/edit/1"), false)
+
+	_, _, body = ts.get(t, "/snippet/view/5")
+	assert.StringContains(t, body, "Comments are turned off.")
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body = ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, "<a href='/comment/edit/1'>Edit</a>")
+	assert.Equal(t, strings.Contains(body, "/comment/edit/2"), false)
+	assert.StringContains(t, body, "<button>Turn off comments</button>")
+	assert.StringContains(t, body, "<input type='hidden' name='parent_id' value='2'>")
+
+	_, _, body = ts.get(t, "/snippet/view/5")
+	assert.Equal(t, strings.Contains(body, "Turn on comments"), false)
+	assert.Equal(t, strings.Contains(body, "name='parent_id'"), false)
+}
+
+func TestCommentCreateRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limits.create = ratelimit.Limit{Burst: 1, Period: time.Hour}
+	app.config.limits.comment = ratelimit.Limit{Burst: 1, Period: time.Hour}
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	form := url.Values{}
+	form.Add("body", "Nice one")
+	form.Add("csrf_token", csrfToken)
+
+	// Using up the snippet creation limit leaves comments alone.
+	code, _, _ := ts.postForm(t, "/snippet/create", form)
+	assert.Equal(t, code != http.StatusTooManyRequests, true)
+	code, _, _ = ts.postForm(t, "/snippet/create", form)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+
+	code, _, _ = ts.postForm(t, "/snippet/view/1/comment", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	code, _, _ = ts.postForm(t, "/snippet/view/1/comment", form)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+}
+
+func TestCommentCreatePost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		body         string
+		parentId     string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:         "Valid comment",
+			urlPath:      "/snippet/view/1/comment",
+			body:         "Nice one",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1#comment-3",
+		},
+		{
+			name:         "Valid reply",
+			urlPath:      "/snippet/view/1/comment",
+			body:         "Thanks",
+			parentId:     "2",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1#comment-3",
+		},
+		{
+			name:     "Blank comment",
+			urlPath:  "/snippet/view/1/comment",
+			body:     "   ",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+		{
+			name:     "Long comment",
+			urlPath:  "/snippet/view/1/comment",
+			body:     strings.Repeat("a", commentMaxChars+1),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be more than 5000 characters long",
+		},
+		{
+			name:     "Unknown parent",
+			urlPath:  "/snippet/view/1/comment",
+			body:     "Hello",
+			parentId: "99",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Comments turned off",
+			urlPath:  "/snippet/view/5/comment",
+			body:     "Hello",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Non-existent snippet",
+			urlPath:  "/snippet/view/2/comment",
+			body:     "Hello",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("body", tt.body)
+			form.Add("parent_id", tt.parentId)
+			form.Add("csrf_token", csrfToken)
+
+			code, header, body := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestCommentCreateUnverified(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "carol@example.com", "pa$$word")
+
+	form := url.Values{}
+	form.Add("body", "Hello")
+	form.Add("csrf_token", csrfToken)
+
+	code, _, body := ts.postForm(t, "/snippet/view/1/comment", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "You need to verify your email address before commenting on public snippets")
+}
+
+func TestCommentEditPost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/comment/edit/1")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<textarea name='body'>What a *lovely* pond.</textarea>")
+
+	code, _, _ = ts.get(t, "/comment/edit/2")
+	assert.Equal(t, code, http.StatusForbidden)
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		body         string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Own comment",
+			urlPath:      "/comment/edit/1",
+			body:         "An even lovelier pond.",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1#comment-1",
+		},
+		{
+			name:     "Blank",
+			urlPath:  "/comment/edit/1",
+			body:     "",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Someone else's comment",
+			urlPath:  "/comment/edit/2",
+			body:     "Mine now",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Non-existent comment",
+			urlPath:  "/comment/edit/99",
+			body:     "Hello",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("body", tt.body)
+			form.Add("csrf_token", csrfToken)
+
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+		})
+	}
+}
+
+func TestCommentOnHiddenSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "carol@example.com", "pa$$word")
+
+	code, _, _ := ts.get(t, "/comment/edit/3")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	form := url.Values{}
+	form.Add("body", "Edited")
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ = ts.postForm(t, "/comment/edit/3", form)
+	assert.Equal(t, code, http.StatusNotFound)
+
+	code, _, _ = ts.postForm(t, "/comment/delete/3", form)
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestCommentDeletePost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ := ts.postForm(t, "/comment/delete/2", form)
+	assert.Equal(t, code, http.StatusForbidden)
+
+	code, header, _ := ts.postForm(t, "/comment/delete/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/snippet/view/1#comments")
+}
+
+func TestSnippetCommentsPost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	form := url.Values{}
+	form.Add("enabled", "false")
+	form.Add("csrf_token", csrfToken)
+
+	code, header, _ := ts.postForm(t, "/snippet/view/1/comments", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/snippet/view/1")
+
+	code, _, _ = ts.postForm(t, "/snippet/view/5/comments", form)
+	assert.Equal(t, code, http.StatusForbidden)
+}
+
+func TestHomeSort(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Latest",
+			urlPath:  "/",
+			wantCode: http.StatusOK,
+			wantBody: "<h2>Latest Snippets</h2>",
+		},
+		{
+			name:     "Most starred",
+			urlPath:  "/?sort=starred",
+			wantCode: http.StatusOK,
+			wantBody: "<h2>Most Starred This Week</h2>",
+		},
+		{
+			name:     "Unknown sort",
+			urlPath:  "/?sort=foo",
+			wantCode: http.StatusOK,
+			wantBody: "<strong>Latest</strong>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+
+	_, _, body := ts.get(t, "/?sort=starred")
+	assert.StringContains(t, body, "<a href='/snippet/view/5'>A newer pond</a>")
+	assert.StringContains(t, body, "<th>Stars this week</th>")
+	assert.StringContains(t, body, "<td>1</td>")
+	assert.Equal(t, strings.Contains(body, "<td>3</td>"), false)
+}
+
+func TestSnippetStarPost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, "<p>3 stars</p>")
+	assert.Equal(t, strings.Contains(body, "/snippet/view/1/star"), false)
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body = ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, "<button>Star</button>")
+
+	_, _, body = ts.get(t, "/snippet/view/5")
+	assert.StringContains(t, body, "<button>Unstar</button>")
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+		wantFlash    string
+	}{
+		{
+			name:         "Star",
+			urlPath:      "/snippet/view/1/star",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1",
+			wantFlash:    "Snippet starred.",
+		},
+		{
+			name:         "Unstar",
+			urlPath:      "/snippet/view/5/star",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/5",
+			wantFlash:    "Snippet unstarred.",
+		},
+		{
+			name:     "Non-existent snippet",
+			urlPath:  "/snippet/view/2/star",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+
+			if tt.wantFlash != "" {
+				_, _, body := ts.get(t, tt.wantLocation)
+				assert.StringContains(t, body, tt.wantFlash)
+			}
+		})
+	}
+}
+
+func TestUserStarred(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/user/starred")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/user/starred")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<a href='/snippet/view/5'>A newer pond</a>")
+
+	ts.login(t, "carol@example.com", "pa$$word")
+
+	_, _, body = ts.get(t, "/user/starred")
+	assert.StringContains(t, body, "You haven't starred any snippets yet.")
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Runbooks", "runbooks"},
+		{"  Deploy: step by step!  ", "deploy-step-by-step"},
+		{"Ünïcode only ☃", "n-code-only"},
+		{"!!!", "collection"},
+		{strings.Repeat("a", 100), strings.Repeat("a", collectionSlugMaxChars)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			assert.Equal(t, slugify(tt.title), tt.want)
+		})
+	}
+}
+
+func TestCollectionView(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/c/runbooks")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<h2>Runbooks</h2>")
+	assert.StringContains(t, body, "<a href='/snippet/view/1'>An old silet pond</a>")
+	assert.Equal(t, strings.Contains(body, "/snippet/view/3"), false)
+	assert.Equal(t, strings.Contains(body, "/c/runbooks/move"), false)
+
+	code, _, _ = ts.get(t, "/c/drafts")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	code, _, _ = ts.get(t, "/c/missing")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body = ts.get(t, "/c/runbooks")
+	assert.StringContains(t, body, "<a href='/snippet/view/3'>Private notes</a>")
+	assert.StringContains(t, body, "<form class='inline' action='/c/runbooks/move' method='POST'>")
+
+	code, _, _ = ts.get(t, "/c/drafts")
+	assert.Equal(t, code, http.StatusOK)
+
+	_, _, body = ts.get(t, "/snippet/view/5")
+	assert.StringContains(t, body, "<option value='runbooks'>Runbooks</option>")
+
+	_, _, body = ts.get(t, "/u/alice")
+	assert.StringContains(t, body, "<a href='/c/runbooks'>Runbooks</a>")
+	assert.Equal(t, strings.Contains(body, "/c/drafts"), false)
+}
+
+func TestCollectionCreatePost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	tests := []struct {
+		name           string
+		title          string
+		wantCode       int
+		wantLocation   string
+		wantLocationRx string
+		wantBody       string
+	}{
+		{
+			name:         "Valid",
+			title:        "Deploy runbook",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/c/deploy-runbook",
+		},
+		{
+			name:           "Taken slug",
+			title:          "Runbooks",
+			wantCode:       http.StatusSeeOther,
+			wantLocationRx: `^/c/runbooks-[0-9a-f]{6}$`,
+		},
+		{
+			name:           "Taken long slug",
+			title:          strings.Repeat("a", 80),
+			wantCode:       http.StatusSeeOther,
+			wantLocationRx: `^/c/a{53}-[0-9a-f]{6}$`,
+		},
+		{
+			name:     "Blank title",
+			title:    "",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", tt.title)
+			form.Add("description", "")
+			form.Add("public", "true")
+			form.Add("csrf_token", csrfToken)
+
+			code, header, body := ts.postForm(t, "/collection/create", form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantLocationRx != "" {
+				assert.Equal(t, regexp.MustCompile(tt.wantLocationRx).MatchString(header.Get("Location")), true)
+			} else {
+				assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			}
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestCollectionCreateRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limits.create = ratelimit.Limit{Burst: 1, Period: time.Hour}
+	app.config.limits.collection = ratelimit.Limit{Burst: 1, Period: time.Hour}
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	form := url.Values{}
+	form.Add("title", "Deploy runbook")
+	form.Add("public", "true")
+	form.Add("csrf_token", csrfToken)
+
+	// Using up the snippet creation limit leaves collections alone.
+	ts.postForm(t, "/snippet/create", form)
+	code, _, _ := ts.postForm(t, "/snippet/create", form)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+
+	code, _, _ = ts.postForm(t, "/collection/create", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	code, _, _ = ts.postForm(t, "/collection/create", form)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+}
+
+func TestCollectionCreateUnverified(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "carol@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/collection/create")
+	assert.StringContains(t, body, "<input type='radio' name='public' value='false' checked>")
+
+	tests := []struct {
+		name     string
+		public   string
+		wantCode int
+	}{
+		{"Private", "false", http.StatusSeeOther},
+		{"Public", "true", http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "Carol's notes")
+			form.Add("public", tt.public)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/collection/create", form)
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantCode == http.StatusUnprocessableEntity {
+				assert.StringContains(t, body, "You need to verify your email address before publishing public collections")
+			}
+		})
+	}
+}
+
+func TestCollectionMovePost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	tests := []struct {
+		name      string
+		urlPath   string
+		snippetId string
+		direction string
+		wantCode  int
+	}{
+		{
+			name:      "Up",
+			urlPath:   "/c/runbooks/move",
+			snippetId: "3",
+			direction: "up",
+			wantCode:  http.StatusSeeOther,
+		},
+		{
+			name:      "Down",
+			urlPath:   "/c/runbooks/move",
+			snippetId: "1",
+			direction: "down",
+			wantCode:  http.StatusSeeOther,
+		},
+		{
+			name:      "Bad direction",
+			urlPath:   "/c/runbooks/move",
+			snippetId: "1",
+			direction: "sideways",
+			wantCode:  http.StatusBadRequest,
+		},
+		{
+			name:      "Not in collection",
+			urlPath:   "/c/runbooks/move",
+			snippetId: "5",
+			direction: "up",
+			wantCode:  http.StatusNotFound,
+		},
+		{
+			name:      "Remove",
+			urlPath:   "/c/runbooks/remove",
+			snippetId: "1",
+			wantCode:  http.StatusSeeOther,
+		},
+		{
+			name:      "Non-existent collection",
+			urlPath:   "/c/missing/move",
+			snippetId: "1",
+			direction: "up",
+			wantCode:  http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("snippet_id", tt.snippetId)
+			form.Add("direction", tt.direction)
+			form.Add("csrf_token", csrfToken)
+
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if code == http.StatusSeeOther {
+				assert.Equal(t, header.Get("Location"), "/c/runbooks")
+			}
+		})
+	}
+
+	csrfToken = ts.login(t, "carol@example.com", "pa$$word")
+
+	form := url.Values{}
+	form.Add("snippet_id", "1")
+	form.Add("direction", "up")
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ := ts.postForm(t, "/c/runbooks/move", form)
+	assert.Equal(t, code, http.StatusForbidden)
+}
+
+func TestSnippetCollectPost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	form := url.Values{}
+	form.Add("collection", "runbooks")
+	form.Add("csrf_token", csrfToken)
+
+	code, header, _ := ts.postForm(t, "/snippet/view/5/collect", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/snippet/view/5")
+
+	_, _, body := ts.get(t, "/snippet/view/5")
+	assert.StringContains(t, body, "Snippet added to Runbooks.")
+
+	csrfToken = ts.login(t, "carol@example.com", "pa$$word")
+
+	form.Set("csrf_token", csrfToken)
+
+	code, _, _ = ts.postForm(t, "/snippet/view/5/collect", form)
+	assert.Equal(t, code, http.StatusBadRequest)
+}
+
+func TestSnippetCreateFiles(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com", "pa$$word")
+
+	tests := []struct {
+		name     string
+		action   string
+		files    [][2]string
+		wantCode int
+		wantBody []string
+		dontWant []string
+	}{
+		{
+			name:     "Add file",
+			action:   "add",
+			files:    [][2]string{{"Dockerfile", "FROM golang"}},
+			wantCode: http.StatusOK,
+			wantBody: []string{
+				"<input type='text' name='files[0].filename' value='Dockerfile'>",
+				"<textarea name='files[1].content'></textarea>",
+				"<button name='action' value='remove-1'>Remove file</button>",
+			},
+		},
+		{
+			name:     "Remove file",
+			action:   "remove-0",
+			files:    [][2]string{{"Dockerfile", "FROM golang"}, {"run.sh", "go run ."}},
+			wantCode: http.StatusOK,
+			wantBody: []string{"<input type='text' name='files[0].filename' value='run.sh'>"},
+			dontWant: []string{"Dockerfile", "remove-0"},
+		},
+		{
+			name:     "Unnamed file among several",
+			action:   "publish",
+			files:    [][2]string{{"Dockerfile", "FROM golang"}, {"", "go run ."}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: []string{"Each file needs a name when there are several"},
+		},
+		{
+			name:     "Duplicate names",
+			action:   "publish",
+			files:    [][2]string{{"run.sh", "FROM golang"}, {"run.sh", "go run ."}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: []string{"Another file already has this name"},
+		},
+		{
+			name:     "Path as name",
+			action:   "publish",
+			files:    [][2]string{{"../run.sh", "go run ."}},
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: []string{"This field must be a file name, not a path"},
+		},
+		{
+			name:     "Several files",
+			action:   "publish",
+			files:    [][2]string{{"Dockerfile", "FROM golang"}, {"run.sh", "go run ."}},
+			wantCode: http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "Deploy")
+			for i, f := range tt.files {
+				form.Add(fmt.Sprintf("files[%d].filename", i), f[0])
+				form.Add(fmt.Sprintf("files[%d].content", i), f[1])
+			}
+			form.Add("expires", "7")
+			form.Add("public", "true")
+			form.Add("action", tt.action)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			for _, want := range tt.wantBody {
+				assert.StringContains(t, body, want)
+			}
+			for _, dontWant := range tt.dontWant {
+				assert.Equal(t, strings.Contains(body, dontWant), false)
+			}
+		})
+	}
+}
+
+func TestSnippetDownload(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/snippet/view/5")
+	assert.StringContains(t, body, "<strong>splash.sh</strong>")
+	assert.StringContains(t, body, "<code class='language-bash'><span class=\"hl-nb\">echo</span> splash</code>")
+
+	code, header, body := ts.get(t, "/snippet/download/5")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "application/zip")
+	assert.Equal(t, header.Get("Content-Disposition"), `attachment; filename="snippet-5.zip"`)
+
+	zr, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+	assert.NilError(t, err)
+	assert.Equal(t, len(zr.File), 2)
+	assert.Equal(t, zr.File[0].Name, "frog.txt")
+	assert.Equal(t, zr.File[1].Name, "splash.sh")
+
+	code, _, body = ts.get(t, "/snippet/download/1")
+	assert.Equal(t, code, http.StatusOK)
+
+	zr, err = zip.NewReader(strings.NewReader(body), int64(len(body)))
+	assert.NilError(t, err)
+	assert.Equal(t, zr.File[0].Name, "snippet-1.txt")
+
+	code, _, _ = ts.get(t, "/snippet/download/3")
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestZipEntryName(t *testing.T) {
+	assert.Equal(t, zipEntryName("main.go", 0), "main.go")
+	assert.Equal(t, zipEntryName("../../etc/passwd", 0), ".._.._etc_passwd")
+	assert.Equal(t, zipEntryName("..", 2), "snippet-3.txt")
+	assert.Equal(t, zipEntryName("", 0), "snippet-1.txt")
+}
+
+func TestFeeds(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []string
+	}{
+		{
+			name:     "Latest",
+			urlPath:  "/feed.atom",
+			wantCode: http.StatusOK,
+			wantBody: []string{
+				`<feed xmlns="http://www.w3.org/2005/Atom">`,
+				`<id>https://snippetbox.test/feed.atom</id>`,
+				`<link rel="alternate" type="text/html" href="https://snippetbox.test/snippet/view/1">`,
+				`<summary type="text">An old silent pond...</summary>`,
+				`<author><name>Alice Jones</name><uri>https://snippetbox.test/u/alice</uri></author>`,
+			},
+		},
+		{
+			name:     "User",
+			urlPath:  "/u/alice/feed.atom",
+			wantCode: http.StatusOK,
+			wantBody: []string{
+				`<title>Snippetbox: snippets by Alice Jones</title>`,
+				`<link rel="alternate" type="text/html" href="https://snippetbox.test/u/alice">`,
+				`<id>https://snippetbox.test/snippet/view/1</id>`,
+			},
+		},
+		{
+			name:     "User without snippets",
+			urlPath:  "/u/carol/feed.atom",
+			wantCode: http.StatusOK,
+			wantBody: []string{`<updated>1970-01-01T00:00:00Z</updated>`},
+		},
+		{
+			name:     "Tag",
+			urlPath:  "/tags/pond/feed.atom",
+			wantCode: http.StatusOK,
+			wantBody: []string{
+				`<title>Snippetbox: snippets tagged pond</title>`,
+				`<link rel="alternate" type="text/html" href="https://snippetbox.test/tags/pond">`,
+				`<id>https://snippetbox.test/snippet/view/5</id>`,
+				`<id>https://snippetbox.test/snippet/view/1</id>`,
+			},
+		},
+		{
+			name:     "Tag without snippets",
+			urlPath:  "/tags/rust/feed.atom",
+			wantCode: http.StatusOK,
+			wantBody: []string{`<updated>1970-01-01T00:00:00Z</updated>`},
+		},
+		{
+			name:     "Invalid tag",
+			urlPath:  "/tags/Pond!/feed.atom",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Unknown user",
+			urlPath:  "/u/nobody/feed.atom",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Disabled user",
+			urlPath:  "/u/dan/feed.atom",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if code == http.StatusOK {
+				assert.Equal(t, header.Get("Content-Type"), "application/atom+xml; charset=utf-8")
+			}
+			for _, want := range tt.wantBody {
+				assert.StringContains(t, body, want)
+			}
+		})
+	}
+}
+
+func TestFeedConditionalGet(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, header, _ := ts.get(t, "/feed.atom")
+
+	etag := header.Get("ETag")
+	assert.Equal(t, etag != "", true)
+
+	lastModified := header.Get("Last-Modified")
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	earlier := modified.Add(-time.Second).Format(http.TimeFormat)
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		wantCode int
+	}{
+		{"Matching ETag", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"Other ETag", map[string]string{"If-None-Match": `"stale"`}, http.StatusOK},
+		{"Not modified since", map[string]string{"If-Modified-Since": lastModified}, http.StatusNotModified},
+		{"Modified since", map[string]string{"If-Modified-Since": earlier}, http.StatusOK},
+		{"ETag over date", map[string]string{"If-None-Match": `"stale"`, "If-Modified-Since": lastModified}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/feed.atom", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			rs, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs.Body.Close()
+
+			assert.Equal(t, rs.StatusCode, tt.wantCode)
+		})
+	}
+}
+
+func TestSnippetFeedEscaping(t *testing.T) {
+	app := newTestApplication(t)
+
+	snippets := []*models.Snippet{{
+		Id:      7,
+		Title:   "Tom & Jerry",
+		Content: "<script>alert(1)</script>" + strings.Repeat("x", feedSummaryChars),
+		Created: time.Now(),
+	}}
+
+	feed, err := app.snippetFeed(httptest.NewRequest(http.MethodGet, "/feed.atom", nil), "/feed.atom", "/", "Latest", snippets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	app.serveFeed(rr, httptest.NewRequest(http.MethodGet, "/feed.atom", nil), feed)
+
+	body := rr.Body.String()
+	assert.StringContains(t, body, "<title>Tom &amp; Jerry</title>")
+	assert.StringContains(t, body, "&lt;script&gt;alert(1)&lt;/script&gt;")
+	assert.StringContains(t, body, "x…</summary>")
+	assert.Equal(t, strings.Contains(body, "<script>"), false)
+}
+
+func TestSnippetFeedETag(t *testing.T) {
+	app := newTestApplication(t)
+
+	older := &models.Snippet{Id: 7, Title: "Older", UserId: 1, Created: time.Now().Add(-time.Hour)}
+	newer := &models.Snippet{Id: 8, Title: "Newer", UserId: 1, Created: time.Now()}
+
+	etag := func(snippets ...*models.Snippet) string {
+		feed, err := app.snippetFeed(httptest.NewRequest(http.MethodGet, "/feed.atom", nil), "/feed.atom", "/", "Latest", snippets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return feed.etag()
+	}
+
+	both := etag(newer, older)
+	assert.Equal(t, etag(newer, older), both)
+
+	// Removing an older entry doesn't change the feed's updated date, but
+	// it has to change its ETag.
+	assert.Equal(t, etag(newer) != both, true)
+	assert.Equal(t, etag(older) != both, true)
+	assert.Equal(t, etag() != etag(newer), true)
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", ""},
+		{"go", "go"},
+		{" Go,deploy  go, ,", "go deploy"},
+		{"c++\tk8s", "c++ k8s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			assert.Equal(t, strings.Join(parseTags(tt.input), " "), tt.want)
+		})
+	}
+}
+
+func TestTagView(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Tag",
+			urlPath:  "/tags/pond",
+			wantCode: http.StatusOK,
+			wantBody: "<a href='/snippet/view/5'>A newer pond</a>",
+		},
+		{
+			name:     "Feed link",
+			urlPath:  "/tags/pond",
+			wantCode: http.StatusOK,
+			wantBody: "<link rel='alternate' type='application/atom+xml' title='Snippets tagged pond' href='/tags/pond/feed.atom'>",
+		},
+		{
+			name:     "No snippets",
+			urlPath:  "/tags/rust",
+			wantCode: http.StatusOK,
+			wantBody: "There are no public snippets tagged rust.",
+		},
+		{
+			name:     "Later page",
+			urlPath:  "/tags/pond?page=2",
+			wantCode: http.StatusOK,
+			wantBody: "?page=1'>Newer</a>",
+		},
+		{
+			name:     "Invalid page",
+			urlPath:  "/tags/pond?page=0",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Invalid tag",
+			urlPath:  "/tags/Pond!",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+
+	_, _, body := ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, "Tags: <a href='/tags/haiku'>haiku</a>, <a href='/tags/pond'>pond</a>")
+}
+
+func TestSnippetEmbed(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Public snippet",
+			urlPath:  "/snippet/embed/5",
+			wantCode: http.StatusOK,
+			wantBody: "<code class='language-bash'><span class=\"hl-nb\">echo</span> splash</code>",
+		},
+		{
+			name:     "Private snippet",
+			urlPath:  "/snippet/embed/3",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Non-existent Id",
+			urlPath:  "/snippet/embed/2",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("X-Frame-Options"), "")
+			assert.StringContains(t, header.Get("Content-Security-Policy"), "; frame-ancestors 'self' https://wiki.example.com")
+
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+				assert.Equal(t, strings.Contains(body, "<nav>"), false)
+			}
+		})
+	}
+
+	// Other pages still can't be framed.
+	_, header, _ := ts.get(t, "/snippet/view/5")
+	assert.Equal(t, header.Get("X-Frame-Options"), "deny")
+}
+
+func TestOEmbed(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		query    url.Values
+		wantCode int
+		wantBody []string
+	}{
+		{
+			name:     "Snippet page",
+			query:    url.Values{"url": {"https://snippetbox.test/snippet/view/5"}},
+			wantCode: http.StatusOK,
+			wantBody: []string{
+				`"type": "rich"`,
+				`"title": "A newer pond"`,
+				`"author_url": "https://snippetbox.test/u/mike"`,
+				`"html": "\u003ciframe src=\"https://snippetbox.test/snippet/embed/5\" width=\"600\" height=\"400\" title=\"A newer pond\"\u003e\u003c/iframe\u003e"`,
+			},
+		},
+		{
+			name:     "Embed URL with max size",
+			query:    url.Values{"url": {"https://snippetbox.test/snippet/embed/1"}, "maxwidth": {"300"}, "maxheight": {"1000"}},
+			wantCode: http.StatusOK,
+			wantBody: []string{`"width": 300`, `"height": 400`},
+		},
+		{
+			name:     "JSON format",
+			query:    url.Values{"url": {"https://snippetbox.test/snippet/view/1"}, "format": {"json"}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "XML format",
+			query:    url.Values{"url": {"https://snippetbox.test/snippet/view/1"}, "format": {"xml"}},
+			wantCode: http.StatusNotImplemented,
+		},
+		{
+			name:     "Private snippet",
+			query:    url.Values{"url": {"https://snippetbox.test/snippet/view/3"}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Other site",
+			query:    url.Values{"url": {"https://example.com/snippet/view/1"}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Not a snippet",
+			query:    url.Values{"url": {"https://snippetbox.test/u/alice"}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Bad max width",
+			query:    url.Values{"url": {"https://snippetbox.test/snippet/view/1"}, "maxwidth": {"wide"}},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, "/oembed?"+tt.query.Encode())
+
+			assert.Equal(t, code, tt.wantCode)
+
+			for _, want := range tt.wantBody {
+				assert.StringContains(t, body, want)
+			}
+		})
+	}
+
+	_, _, body := ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, "<link rel='alternate' type='application/json+oembed' href='https://snippetbox.test/oembed?url=https%3a%2f%2fsnippetbox.test/snippet/view/1'")
+}
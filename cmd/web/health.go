@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// componentStatus is the result of one readiness check. The public /readyz
+// only gives the status; the latency and error are for the admin listener.
+type componentStatus struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type readinessReport struct {
+	Status     string                     `json:"status"`
+	Components map[string]componentStatus `json:"components"`
+}
+
+func databaseCheck(db *sql.DB) healthCheck {
+	return healthCheck{
+		name:  "database",
+		check: db.PingContext,
+	}
+}
+
+// sessionStoreCheck looks up a token that never exists. The store only
+// returns an error if it cannot be reached, so a miss means it is healthy.
+func sessionStoreCheck(store scs.Store) healthCheck {
+	return healthCheck{
+		name: "sessions",
+		check: func(ctx context.Context) error {
+			errCh := make(chan error, 1)
+			go func() {
+				_, _, err := store.Find("readiness-probe")
+				errCh <- err
+			}()
+
+			select {
+			case err := <-errCh:
+				return err
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+}
+
+func (app *application) templateCacheCheck() healthCheck {
+	return healthCheck{
+		name: "templates",
+		check: func(ctx context.Context) error {
+			if len(app.templateCache) == 0 {
+				return errors.New("template cache is empty")
+			}
+			return nil
+		},
+	}
+}
+
+func healthz(writer http.ResponseWriter, request *http.Request) {
+	writeJSON(writer, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz is the public readiness probe. Failing checks are logged, but their
+// errors can name hosts and drivers, so only the status of each is shown.
+func (app *application) readyz(writer http.ResponseWriter, request *http.Request) {
+	report, status := app.readiness(request.Context())
+
+	for name, cs := range report.Components {
+		if cs.Error != "" {
+			app.errorLog.Printf("readyz: %s: %s", name, cs.Error)
+		}
+		report.Components[name] = componentStatus{Status: cs.Status}
+	}
+
+	writeJSON(writer, status, report)
+}
+
+// readyzDetail is the readiness probe for the admin listener, with the
+// latency and error of each check.
+func (app *application) readyzDetail(writer http.ResponseWriter, request *http.Request) {
+	report, status := app.readiness(request.Context())
+	writeJSON(writer, status, report)
+}
+
+// readiness runs the readiness checks in parallel and returns their report
+// with the HTTP status to serve it with.
+func (app *application) readiness(ctx context.Context) (readinessReport, int) {
+	report := readinessReport{
+		Status:     "ready",
+		Components: make(map[string]componentStatus, len(app.readinessChecks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, hc := range app.readinessChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, app.config.readinessTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := hc.check(ctx)
+			cs := componentStatus{
+				Status:    "up",
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				cs.Status = "down"
+				cs.Error = err.Error()
+			}
+
+			mu.Lock()
+			report.Components[hc.name] = cs
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	status := http.StatusOK
+	for _, cs := range report.Components {
+		if cs.Status != "up" {
+			report.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	if app.shuttingDown.Load() {
+		report.Status = "shutting down"
+		status = http.StatusServiceUnavailable
+	}
+
+	return report, status
+}
@@ -2,14 +2,59 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 
+	"snippetbox.jonnevuorela.com/internal/models"
+	"snippetbox.jonnevuorela.com/internal/totp"
+	"snippetbox.jonnevuorela.com/internal/validator"
+
 	"github.com/go-playground/form/v4"
 	"github.com/justinas/nosurf"
+	"rsc.io/qr"
+)
+
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 48 * time.Hour
+
+	// twoFactorLoginTTL is how long a user has to enter their second factor
+	// after giving the right password, and twoFactorMaxAttempts how many
+	// wrong codes they can enter before having to start again.
+	twoFactorLoginTTL    = 5 * time.Minute
+	twoFactorMaxAttempts = 5
+
+	totpIssuer = "Snippetbox"
+
+	// profilePageSize is how many snippets a profile page lists.
+	profilePageSize = 20
+
+	// snippetMaxFiles is how many files a snippet can be made of, and
+	// snippetMaxTags how many tags it can have.
+	snippetMaxFiles = 10
+	snippetMaxTags  = 5
+
+	// sessionTouchInterval is how often a session's last seen time is
+	// updated, so that not every request has to write to the database.
+	sessionTouchInterval = time.Minute
+
+	// reauthenticationWindow is how long after entering their password a
+	// user can reach sensitive pages without entering it again. It stops a
+	// long-lived "remember me" session being enough on its own.
+	reauthenticationWindow = 15 * time.Minute
 )
 
 func (app *application) isAuthenticated(request *http.Request) bool {
@@ -20,6 +65,128 @@ func (app *application) isAuthenticated(request *http.Request) bool {
 	return isAuthenticated
 }
 
+// clientIP returns the address of the client that made the request. If the
+// connection comes from a trusted proxy, X-Forwarded-For is walked from the
+// right and the first address that isn't a trusted proxy is used, so a
+// client can't pick its own address by sending the header itself.
+func (app *application) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	addr = addr.Unmap()
+
+	if !app.isTrustedProxy(addr) {
+		return addr.String()
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+
+		if !app.isTrustedProxy(addr) {
+			break
+		}
+	}
+
+	return addr.String()
+}
+
+func (app *application) isTrustedProxy(addr netip.Addr) bool {
+	for _, prefix := range app.config.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// userKey identifies the logged-in user for rate limiting, falling back to
+// the client IP for anonymous requests.
+func (app *application) userKey(r *http.Request) string {
+	id := app.authenticatedUserId(r)
+	if id == 0 {
+		return "ip:" + app.clientIP(r)
+	}
+	return "user:" + strconv.Itoa(id)
+}
+
+// ipKey identifies the client IP for rate limiting.
+func (app *application) ipKey(r *http.Request) string {
+	return "ip:" + app.clientIP(r)
+}
+
+func (app *application) authenticatedUserId(request *http.Request) int {
+	return app.sessionManager.GetInt(request.Context(), "authenticatedUserId")
+}
+
+// role returns the logged-in user's role, or "" if the request isn't
+// authenticated.
+func (app *application) role(request *http.Request) models.Role {
+	role, _ := request.Context().Value(roleContextKey).(models.Role)
+	return role
+}
+
+// currentSession returns the record for the logged-in session, or nil if the
+// request isn't authenticated.
+func (app *application) currentSession(request *http.Request) *models.UserSession {
+	s, _ := request.Context().Value(userSessionContextKey).(*models.UserSession)
+	return s
+}
+
+// sessionExpiry returns when a session created at created expires if it is
+// used now, taking both the absolute and the idle timeout into account.
+// Sessions where the user asked to be remembered get the longer lifetime.
+func (app *application) sessionExpiry(created time.Time, remember bool) time.Time {
+	lifetime := app.config.session.lifetime
+	if remember {
+		lifetime = app.config.session.rememberLifetime
+	}
+
+	expires := created.Add(lifetime)
+
+	if idle := app.config.session.idleTimeout; idle > 0 {
+		if deadline := time.Now().Add(idle); deadline.Before(expires) {
+			expires = deadline
+		}
+	}
+	return expires
+}
+
+// isOwner reports whether the logged-in user is userId.
+func (app *application) isOwner(request *http.Request, userId int) bool {
+	return app.isAuthenticated(request) && userId != 0 && app.authenticatedUserId(request) == userId
+}
+
+// snippetFiles returns the snippet's files. A snippet saved before snippets
+// could have several files is treated as a single file holding its content.
+func (app *application) snippetFiles(request *http.Request, snippet *models.Snippet) ([]*models.SnippetFile, error) {
+	files, err := app.snippets.Files(request.Context(), snippet.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		files = []*models.SnippetFile{{Content: snippet.Content}}
+	}
+	return files, nil
+}
+
+// canView reports whether the request may see the snippet: it is public, or
+// the user owns it or is a moderator.
+func (app *application) canView(request *http.Request, snippet *models.Snippet) bool {
+	return snippet.Public || app.isOwner(request, snippet.UserId) || app.role(request).AtLeast(models.RoleModerator)
+}
+
 func (app *application) decodePostForm(r *http.Request, dst any) error {
 	err := r.ParseForm()
 	if err != nil {
@@ -48,6 +215,11 @@ func (app *application) serverError(w http.ResponseWriter, err error) {
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
+func (app *application) sessionError(w http.ResponseWriter, r *http.Request, err error) {
+	app.metrics.sessionStoreErrors.Inc()
+	app.serverError(w, err)
+}
+
 func (app *application) clientError(w http.ResponseWriter, status int) {
 	http.Error(w, http.StatusText(status), status)
 }
@@ -56,7 +228,10 @@ func (app *application) notFound(w http.ResponseWriter) {
 	app.clientError(w, http.StatusNotFound)
 }
 
-func (app *application) render(w http.ResponseWriter, status int, page string, data *templateData) {
+func (app *application) render(w http.ResponseWriter, r *http.Request, status int, page string, data *templateData) {
+	_, span := tracer.Start(r.Context(), "render "+page)
+	defer span.End()
+
 	ts, ok := app.templateCache[page]
 	if !ok {
 		err := fmt.Errorf("the template %s does not exist", page)
@@ -66,7 +241,9 @@ func (app *application) render(w http.ResponseWriter, status int, page string, d
 
 	buf := new(bytes.Buffer)
 
+	start := time.Now()
 	err := ts.ExecuteTemplate(buf, "base", data)
+	app.metrics.renderDuration.WithLabelValues(page).Observe(time.Since(start).Seconds())
 	if err != nil {
 		app.serverError(w, err)
 	}
@@ -77,10 +254,351 @@ func (app *application) render(w http.ResponseWriter, status int, page string, d
 }
 
 func (app *application) newTemplateData(r *http.Request) *templateData {
-	return &templateData{
+	data := &templateData{
 		CurrentYear:     time.Now().Year(),
 		Flash:           app.sessionManager.PopString(r.Context(), "flash"),
 		IsAuthenticated: app.isAuthenticated(r),
+		Role:            app.role(r),
 		CSRFToken:       nosurf.Token(r),
+		BaseURL:         app.config.baseURL,
+	}
+
+	if data.IsAuthenticated {
+		data.AuthenticatedUserId = app.authenticatedUserId(r)
+	}
+
+	if app.oidc != nil {
+		data.OIDCName = app.oidc.name
+	}
+
+	return data
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+}
+
+// humanDuration rounds d up to whole seconds or minutes for display.
+func humanDuration(d time.Duration) string {
+	if d <= time.Minute {
+		seconds := int(math.Ceil(d.Seconds()))
+		if seconds == 1 {
+			return "1 second"
+		}
+		return fmt.Sprintf("%d seconds", seconds)
 	}
+
+	minutes := int(math.Ceil(d.Minutes()))
+	return fmt.Sprintf("%d minutes", minutes)
+}
+
+func loginBlockMessage(block *models.LoginBlock) string {
+	wait := humanDuration(time.Until(block.Until))
+	if block.Locked {
+		return fmt.Sprintf("Too many failed login attempts. This account is temporarily locked, please try again in %s.", wait)
+	}
+	return fmt.Sprintf("Too many failed login attempts. Please wait %s before trying again.", wait)
+}
+
+// checkCurrentPassword checks the password a logged-in user gave to confirm
+// a change to their account. It is throttled like logging in, so that a
+// stolen session can't be used to guess the password. If the check fails it
+// adds the reason to v and returns the status to respond with: 422 for a
+// wrong password, 429 while locked out and 403 if the account has been
+// disabled. Otherwise it returns 0.
+func (app *application) checkCurrentPassword(request *http.Request, user *models.User, password string, v *validator.Validator) (int, error) {
+	ip := app.clientIP(request)
+
+	block, err := app.loginAttempts.Check(request.Context(), user.Email, ip)
+	if err != nil {
+		return 0, err
+	}
+
+	if block != nil {
+		v.AddNonFieldError(loginBlockMessage(block))
+		return http.StatusTooManyRequests, nil
+	}
+
+	_, err = app.users.Authenticate(request.Context(), user.Email, password)
+	if err != nil {
+		if errors.Is(err, models.ErrAccountDisabled) {
+			v.AddNonFieldError("This account has been disabled")
+			return http.StatusForbidden, nil
+		}
+		if !errors.Is(err, models.ErrInvalidCredentials) {
+			return 0, err
+		}
+
+		block, err := app.loginAttempts.RecordFailure(request.Context(), user.Email, ip)
+		if err != nil {
+			return 0, err
+		}
+
+		v.AddFieldError("currentPassword", "Current password is incorrect")
+		if block != nil && block.Locked {
+			v.AddNonFieldError(loginBlockMessage(block))
+		}
+		return http.StatusUnprocessableEntity, nil
+	}
+
+	return 0, app.loginAttempts.Reset(request.Context(), user.Email)
+}
+
+// notifyLoginLocked tells the owner of email, if there is one, that their
+// account has been locked. It runs in the background, so failures are logged
+// rather than returned.
+func (app *application) notifyLoginLocked(ctx context.Context, email, ip string, block *models.LoginBlock) {
+	user, err := app.users.GetByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, models.ErrNoRecord) {
+			app.errorLog.Print(err)
+		}
+		return
+	}
+
+	data := map[string]any{
+		"Name":  user.Name,
+		"Until": block.Until,
+		"IP":    ip,
+	}
+
+	err = app.sendMail(ctx, user.Email, "login_locked.tmpl", data)
+	if err != nil {
+		app.errorLog.Print(err)
+	}
+}
+
+// background runs fn in a goroutine that main waits for on shutdown. A
+// panic in fn is logged rather than crashing the server.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.errorLog.Print(fmt.Errorf("%s", err))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// auditEvent starts an audit event for something the request did. actorId is
+// the user responsible, or 0 if nobody is logged in. The event is built up
+// front so that it can be recorded after the request has finished.
+func (app *application) auditEvent(request *http.Request, action string, actorId int, details map[string]string) *models.AuditEvent {
+	if details == nil {
+		details = map[string]string{}
+	}
+
+	return &models.AuditEvent{
+		Action:    action,
+		ActorId:   actorId,
+		IP:        app.clientIP(request),
+		UserAgent: request.UserAgent(),
+		Details:   details,
+	}
+}
+
+// recordAudit adds the event to the audit log. The action being recorded has
+// already happened, so a failure is logged rather than failing the request.
+func (app *application) recordAudit(ctx context.Context, event *models.AuditEvent) {
+	err := app.auditEvents.Record(ctx, event)
+	if err != nil {
+		app.errorLog.Print(fmt.Errorf("audit %s: %w", event.Action, err))
+	}
+}
+
+// audit records an event for the request in the audit log.
+func (app *application) audit(request *http.Request, action string, actorId int, details map[string]string) {
+	app.recordAudit(request.Context(), app.auditEvent(request, action, actorId, details))
+}
+
+// sendPasswordReset emails a reset link to the owner of email, and records
+// the token in the audit log with event. An unknown address is not an error.
+func (app *application) sendPasswordReset(ctx context.Context, email string, event *models.AuditEvent) error {
+	user, err := app.users.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return nil
+		}
+		return err
+	}
+
+	token, err := app.passwordResets.New(ctx, user.Id, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	event.Details["token"] = "password_reset"
+	event.Details["user_id"] = strconv.Itoa(user.Id)
+	app.recordAudit(ctx, event)
+
+	data := map[string]any{
+		"Name":    user.Name,
+		"URL":     app.config.baseURL + "/user/password/reset/" + token,
+		"Expires": time.Now().Add(passwordResetTTL),
+	}
+
+	return app.sendMail(ctx, user.Email, "password_reset.tmpl", data)
+}
+
+// sendEmailVerification emails the user a link that proves they own email,
+// and records the token in the audit log with event.
+func (app *application) sendEmailVerification(ctx context.Context, userId int, name, email string, event *models.AuditEvent) error {
+	token, err := app.emailVerifications.New(ctx, userId, email, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	event.Details["token"] = "email_verification"
+	event.Details["email"] = email
+	app.recordAudit(ctx, event)
+
+	data := map[string]any{
+		"Name":    name,
+		"URL":     app.config.baseURL + "/user/verify/" + token,
+		"Expires": time.Now().Add(emailVerificationTTL),
+	}
+
+	return app.sendMail(ctx, email, "verify_email.tmpl", data)
+}
+
+// logIn finishes logging in the user once every factor has been checked, and
+// records the session so that it can be listed and revoked. If remember is
+// set the session cookie outlives the browser.
+func (app *application) logIn(writer http.ResponseWriter, request *http.Request, userId int, remember bool) {
+	err := app.sessionManager.RenewToken(request.Context())
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.sessionManager.RememberMe(request.Context(), remember)
+
+	key, err := app.userSessions.New(request.Context(), userId, app.clientIP(request), request.UserAgent(), remember, app.sessionExpiry(time.Now(), remember))
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.sessionManager.Put(request.Context(), "authenticatedUserId", userId)
+	app.sessionManager.Put(request.Context(), "authenticatedAt", time.Now().Unix())
+	app.sessionManager.Put(request.Context(), "sessionKey", key)
+	app.metrics.logins.WithLabelValues("success").Inc()
+	app.audit(request, models.AuditLogin, userId, map[string]string{"remember": strconv.FormatBool(remember)})
+
+	path := app.sessionManager.PopString(request.Context(), "redirectPathAfterLogin")
+	if !isLocalPath(path) {
+		path = "/snippet/create"
+	}
+
+	http.Redirect(writer, request, path, http.StatusSeeOther)
+}
+
+// isLocalPath reports whether path is safe to redirect to after login: an
+// absolute path on this site. Anything with a scheme or host, including
+// protocol-relative //host and the /\host form that browsers treat the same
+// way, is rejected so the login page can't be used as an open redirect.
+func isLocalPath(path string) bool {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.ContainsAny(path, "\\\r\n\t") {
+		return false
+	}
+
+	u, err := url.Parse(path)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "" && u.Host == "" && u.User == nil
+}
+
+// logOut removes the login from the session, under a new token so that the
+// old one can't be reused.
+func (app *application) logOut(request *http.Request) error {
+	err := app.sessionManager.RenewToken(request.Context())
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.RememberMe(request.Context(), false)
+	app.sessionManager.Remove(request.Context(), "authenticatedUserId")
+	app.sessionManager.Remove(request.Context(), "authenticatedAt")
+	app.sessionManager.Remove(request.Context(), "sessionKey")
+	return nil
+}
+
+// startTwoFactorLogin sends a user who has proved who they are, but has two
+// factor authentication turned on, to enter their second factor. The session
+// isn't logged in until they do.
+func (app *application) startTwoFactorLogin(writer http.ResponseWriter, request *http.Request, id int, rememberMe bool) {
+	err := app.sessionManager.RenewToken(request.Context())
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.clearTwoFactorLogin(request)
+	app.sessionManager.Put(request.Context(), "twoFactorUserId", id)
+	app.sessionManager.Put(request.Context(), "twoFactorExpires", time.Now().Add(twoFactorLoginTTL).Unix())
+	app.sessionManager.Put(request.Context(), "twoFactorRememberMe", rememberMe)
+
+	http.Redirect(writer, request, "/user/login/2fa", http.StatusSeeOther)
+}
+
+// twoFactorUserId returns the user that has given the right password and
+// still has to enter their second factor, or 0 if there isn't one or they
+// took too long.
+func (app *application) twoFactorUserId(request *http.Request) int {
+	ctx := request.Context()
+
+	if time.Now().Unix() > app.sessionManager.GetInt64(ctx, "twoFactorExpires") {
+		app.clearTwoFactorLogin(request)
+		return 0
+	}
+	return app.sessionManager.GetInt(ctx, "twoFactorUserId")
+}
+
+func (app *application) clearTwoFactorLogin(request *http.Request) {
+	ctx := request.Context()
+
+	app.sessionManager.Remove(ctx, "twoFactorUserId")
+	app.sessionManager.Remove(ctx, "twoFactorExpires")
+	app.sessionManager.Remove(ctx, "twoFactorFailures")
+	app.sessionManager.Remove(ctx, "twoFactorRememberMe")
+}
+
+// newTwoFactorSetup renders the QR code for secret as a PNG data: URI, which
+// the CSP's img-src allows, so the secret never leaves the server.
+func newTwoFactorSetup(email string, secret []byte) (*twoFactorSetup, error) {
+	code, err := qr.Encode(totp.URI(totpIssuer, email, secret), qr.M)
+	if err != nil {
+		return nil, err
+	}
+	code.Scale = 4
+
+	key := totp.Encoding.EncodeToString(secret)
+
+	var groups []string
+	for len(key) > 4 {
+		groups = append(groups, key[:4])
+		key = key[4:]
+	}
+	groups = append(groups, key)
+
+	return &twoFactorSetup{
+		QRCode: template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG())),
+		Key:    strings.Join(groups, " "),
+	}, nil
 }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"text/template"
+
+	"snippetbox.jonnevuorela.com/internal/mailer"
+	"snippetbox.jonnevuorela.com/ui"
+)
+
+// sendMail renders the "subject" and "plainBody" templates from
+// ui/mail/<name> and sends the result to recipient.
+func (app *application) sendMail(ctx context.Context, recipient, name string, data any) error {
+	ts, err := template.New(name).Funcs(template.FuncMap(functions)).ParseFS(ui.Files, "mail/"+name)
+	if err != nil {
+		return err
+	}
+
+	subject := new(bytes.Buffer)
+	err = ts.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return err
+	}
+
+	body := new(bytes.Buffer)
+	err = ts.ExecuteTemplate(body, "plainBody", data)
+	if err != nil {
+		return err
+	}
+
+	return app.mailer.Send(ctx, mailer.Message{
+		To:      recipient,
+		Subject: subject.String(),
+		Body:    strings.TrimSpace(body.String()) + "\n",
+	})
+}
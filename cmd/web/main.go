@@ -1,16 +1,27 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"snippetbox.jonnevuorela.com/internal/mailer"
 	"snippetbox.jonnevuorela.com/internal/models"
+	"snippetbox.jonnevuorela.com/internal/ratelimit"
 
 	"github.com/alexedwards/scs/mysqlstore"
 	"github.com/alexedwards/scs/v2"
@@ -18,26 +29,150 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 )
 
+type config struct {
+	addr             string
+	baseURL          string
+	dsn              string
+	adminAddr        string
+	readinessTimeout time.Duration
+	shutdownDelay    time.Duration
+	trace            struct {
+		exporter     string
+		otlpEndpoint string
+		sampleRatio  float64
+	}
+	limits struct {
+		login      ratelimit.Limit
+		signup     ratelimit.Limit
+		create     ratelimit.Limit
+		comment    ratelimit.Limit
+		collection ratelimit.Limit
+		reset      ratelimit.Limit
+	}
+	session struct {
+		lifetime         time.Duration
+		rememberLifetime time.Duration
+		idleTimeout      time.Duration
+	}
+	oidc struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+		name         string
+	}
+	trustedProxies      []netip.Prefix
+	embedFrameAncestors []string
+	mail                struct {
+		backend      string
+		dir          string
+		from         string
+		smtpAddr     string
+		smtpUsername string
+		smtpPassword string
+	}
+}
+
 type application struct {
-	errorLog       *log.Logger
-	infoLog        *log.Logger
-	snippets       models.SnippetModelInterface
-	users          models.UserModelInterface
-	templateCache  map[string]*template.Template
-	formDecoder    *form.Decoder
-	sessionManager *scs.SessionManager
+	config             config
+	errorLog           *log.Logger
+	infoLog            *log.Logger
+	snippets           models.SnippetModelInterface
+	users              models.UserModelInterface
+	loginAttempts      models.LoginAttemptModelInterface
+	passwordResets     models.PasswordResetModelInterface
+	emailVerifications models.EmailVerificationModelInterface
+	twoFactor          models.TwoFactorModelInterface
+	userSessions       models.UserSessionModelInterface
+	identities         models.IdentityModelInterface
+	auditEvents        models.AuditModelInterface
+	comments           models.CommentModelInterface
+	stars              models.StarModelInterface
+	collections        models.CollectionModelInterface
+	templateCache      map[string]*template.Template
+	formDecoder        *form.Decoder
+	sessionManager     *scs.SessionManager
+	metrics            *metrics
+	rateLimiter        ratelimit.Store
+	mailer             mailer.Mailer
+	oidc               *oidcProvider
+	readinessChecks    []healthCheck
+	shuttingDown       atomic.Bool
+	wg                 sync.WaitGroup
 }
 
 func main() {
-	addr := flag.String("addr", ":4000", "HTTP network address")
-	dsn := flag.String("dsn", "web:pass@/snippetbox?parseTime=true", "MySQL data source name")
+	var cfg config
+
+	flag.StringVar(&cfg.addr, "addr", ":4000", "HTTP network address")
+	flag.StringVar(&cfg.baseURL, "base-url", "https://localhost:4000", "Public URL of the site, used for links in emails and feeds")
+	flag.StringVar(&cfg.dsn, "dsn", "web:pass@/snippetbox?parseTime=true", "MySQL data source name")
+	flag.StringVar(&cfg.adminAddr, "admin-addr", "localhost:4001", "Admin HTTP network address for /metrics (empty to disable)")
+	flag.DurationVar(&cfg.readinessTimeout, "readiness-timeout", 2*time.Second, "Timeout for each /readyz dependency check")
+	flag.DurationVar(&cfg.shutdownDelay, "shutdown-delay", 5*time.Second, "Time to fail /readyz before closing listeners on shutdown")
+	flag.DurationVar(&cfg.session.lifetime, "session-lifetime", 12*time.Hour, "Absolute time after which a session expires")
+	flag.DurationVar(&cfg.session.rememberLifetime, "session-remember-lifetime", 30*24*time.Hour, "Absolute time after which a \"remember me\" session expires")
+	flag.DurationVar(&cfg.session.idleTimeout, "session-idle-timeout", 0, "Time without requests after which a session expires (0 to disable)")
+	flag.StringVar(&cfg.trace.exporter, "trace-exporter", "none", "Trace exporter (none|stdout|otlp)")
+	flag.StringVar(&cfg.trace.otlpEndpoint, "otlp-endpoint", "localhost:4318", "OTLP/HTTP collector endpoint")
+	flag.Float64Var(&cfg.trace.sampleRatio, "trace-sample-ratio", 1, "Fraction of new traces to sample")
+
+	cfg.limits.login = ratelimit.Limit{Burst: 10, Period: time.Minute}
+	cfg.limits.signup = ratelimit.Limit{Burst: 5, Period: time.Hour}
+	cfg.limits.create = ratelimit.Limit{Burst: 30, Period: time.Hour}
+	cfg.limits.comment = ratelimit.Limit{Burst: 60, Period: time.Hour}
+	cfg.limits.collection = ratelimit.Limit{Burst: 10, Period: time.Hour}
+	cfg.limits.reset = ratelimit.Limit{Burst: 5, Period: time.Hour}
+	flag.Var(&cfg.limits.login, "limit-login", "Login attempts allowed per client IP, as burst/period or off")
+	flag.Var(&cfg.limits.signup, "limit-signup", "Signups allowed per client IP, as burst/period or off")
+	flag.Var(&cfg.limits.create, "limit-create", "Snippets a user may create, as burst/period or off")
+	flag.Var(&cfg.limits.comment, "limit-comment", "Comments a user may post, as burst/period or off")
+	flag.Var(&cfg.limits.collection, "limit-collection", "Collections a user may create, as burst/period or off")
+	flag.Var(&cfg.limits.reset, "limit-password-reset", "Password reset and verification emails allowed per client, as burst/period or off")
+	flag.Func("trusted-proxies", "Comma-separated CIDRs of proxies whose X-Forwarded-For is trusted", func(s string) error {
+		for _, cidr := range strings.Split(s, ",") {
+			prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+			if err != nil {
+				return err
+			}
+			cfg.trustedProxies = append(cfg.trustedProxies, prefix)
+		}
+		return nil
+	})
+	cfg.embedFrameAncestors = []string{"'self'"}
+	flag.Func("embed-frame-ancestors", "Comma-separated CSP sources, such as https://wiki.example.com, allowed to frame embedded snippets (default 'self')", func(s string) error {
+		cfg.embedFrameAncestors = nil
+		for _, source := range strings.Split(s, ",") {
+			source = strings.TrimSpace(source)
+			if source == "" || strings.ContainsAny(source, "; \t") {
+				return fmt.Errorf("invalid frame ancestor %q", source)
+			}
+			cfg.embedFrameAncestors = append(cfg.embedFrameAncestors, source)
+		}
+		return nil
+	})
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "OpenID Connect issuer URL for SSO login (empty to disable)")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", "", "OpenID Connect client secret")
+	flag.StringVar(&cfg.oidc.name, "oidc-name", "SSO", "Name of the identity provider shown on the login page")
+	flag.StringVar(&cfg.mail.backend, "mailer", "file", "Mail backend (file|smtp)")
+	flag.StringVar(&cfg.mail.dir, "mail-dir", "./tmp/mail", "Directory the file mailer writes messages to")
+	flag.StringVar(&cfg.mail.from, "mail-from", "Snippetbox <no-reply@snippetbox.local>", "Sender address for outgoing mail")
+	flag.StringVar(&cfg.mail.smtpAddr, "smtp-addr", "localhost:25", "SMTP server address")
+	flag.StringVar(&cfg.mail.smtpUsername, "smtp-username", "", "SMTP username")
+	flag.StringVar(&cfg.mail.smtpPassword, "smtp-password", "", "SMTP password")
 
 	flag.Parse()
 
 	infoLog := log.New(os.Stdout, "\033[42;30mINFO\033[0m\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stderr, "\033[41;30mERROR\033[0m\t", log.Ldate|log.Ltime|log.Lshortfile)
 
-	db, err := openDB(*dsn)
+	tp, err := newTracerProvider(cfg)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	defer tp.Shutdown(context.Background())
+
+	db, err := openDB(cfg.dsn)
 	if err != nil {
 		errorLog.Fatal(err)
 	}
@@ -48,29 +183,67 @@ func main() {
 		errorLog.Fatal(err)
 	}
 
+	mailSender, err := newMailer(cfg)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	oidcProvider, err := newOIDCProvider(context.Background(), cfg)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
 	formDecoder := form.NewDecoder()
 
 	sessionManager := scs.New()
 	sessionManager.Store = mysqlstore.New(db)
-	sessionManager.Lifetime = 12 * time.Hour
+	// The store keeps sessions for as long as the longest lifetime. Each
+	// login's own expiry is enforced through its user_sessions record, and
+	// only "remember me" logins get a cookie that outlives the browser.
+	sessionManager.Lifetime = max(cfg.session.lifetime, cfg.session.rememberLifetime)
+	sessionManager.IdleTimeout = cfg.session.idleTimeout
+	sessionManager.Cookie.Persist = false
 
 	sessionManager.Cookie.Secure = true
 
 	app := &application{
-		errorLog:       errorLog,
-		infoLog:        infoLog,
-		snippets:       &models.SnippetModel{DB: db},
-		users:          &models.UserModel{DB: db},
-		templateCache:  templateCache,
-		formDecoder:    formDecoder,
-		sessionManager: sessionManager,
+		config:             cfg,
+		errorLog:           errorLog,
+		infoLog:            infoLog,
+		snippets:           &models.SnippetModel{DB: db},
+		users:              &models.UserModel{DB: db},
+		loginAttempts:      &models.LoginAttemptModel{DB: db},
+		passwordResets:     &models.PasswordResetModel{DB: db},
+		emailVerifications: &models.EmailVerificationModel{DB: db},
+		twoFactor:          &models.TwoFactorModel{DB: db},
+		userSessions:       &models.UserSessionModel{DB: db},
+		identities:         &models.IdentityModel{DB: db},
+		auditEvents:        &models.AuditModel{DB: db},
+		comments:           &models.CommentModel{DB: db},
+		stars:              &models.StarModel{DB: db},
+		collections:        &models.CollectionModel{DB: db},
+		templateCache:      templateCache,
+		formDecoder:        formDecoder,
+		sessionManager:     sessionManager,
+		metrics:            newMetrics(db),
+		rateLimiter:        ratelimit.NewMemoryStore(),
+		mailer:             mailSender,
+		oidc:               oidcProvider,
+	}
+
+	sessionManager.ErrorFunc = app.sessionError
+
+	app.readinessChecks = []healthCheck{
+		databaseCheck(db),
+		sessionStoreCheck(sessionManager.Store),
+		app.templateCacheCheck(),
 	}
 
 	tlsConfig := &tls.Config{
 		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
 	}
 	srv := &http.Server{
-		Addr:         *addr,
+		Addr:         cfg.addr,
 		ErrorLog:     errorLog,
 		Handler:      app.routes(),
 		TLSConfig:    tlsConfig,
@@ -79,10 +252,80 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
-	infoLog.Printf("Starting server on http://localhost%s", *addr)
+	if cfg.adminAddr != "" {
+		adminSrv := &http.Server{
+			Addr:         cfg.adminAddr,
+			ErrorLog:     errorLog,
+			Handler:      app.adminRoutes(),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+
+		go func() {
+			infoLog.Printf("Starting admin server on http://%s", cfg.adminAddr)
+			errorLog.Fatal(adminSrv.ListenAndServe())
+		}()
+	}
+
+	shutdownErr := make(chan error)
+	go app.shutdownOnSignal(srv, shutdownErr)
+
+	infoLog.Printf("Starting server on http://localhost%s", cfg.addr)
 	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	errorLog.Fatal(err)
+	if !errors.Is(err, http.ErrServerClosed) {
+		errorLog.Fatal(err)
+	}
+
+	err = <-shutdownErr
+	if err != nil {
+		errorLog.Fatal(err)
+	}
 
+	infoLog.Print("Server stopped")
+}
+
+// shutdownOnSignal marks the application as shutting down so that /readyz
+// starts failing, waits for the load balancer to notice, and then closes the
+// server once in-flight requests have completed.
+func (app *application) shutdownOnSignal(srv *http.Server, shutdownErr chan<- error) {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	s := <-quit
+
+	app.infoLog.Printf("Caught signal %s, draining for %s", s, app.config.shutdownDelay)
+	app.shuttingDown.Store(true)
+	time.Sleep(app.config.shutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		shutdownErr <- err
+		return
+	}
+
+	app.infoLog.Print("Waiting for background tasks to finish")
+	app.wg.Wait()
+
+	shutdownErr <- nil
+}
+
+func newMailer(cfg config) (mailer.Mailer, error) {
+	switch cfg.mail.backend {
+	case "file":
+		return &mailer.FileMailer{Dir: cfg.mail.dir, From: cfg.mail.from}, nil
+	case "smtp":
+		return &mailer.SMTPMailer{
+			Addr:     cfg.mail.smtpAddr,
+			Username: cfg.mail.smtpUsername,
+			Password: cfg.mail.smtpPassword,
+			From:     cfg.mail.from,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", cfg.mail.backend)
+	}
 }
 
 func openDB(dsn string) (*sql.DB, error) {
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type metrics struct {
+	registry           *prometheus.Registry
+	requestsTotal      *prometheus.CounterVec
+	requestDuration    *prometheus.HistogramVec
+	requestsInFlight   prometheus.Gauge
+	renderDuration     *prometheus.HistogramVec
+	snippetsCreated    prometheus.Counter
+	logins             *prometheus.CounterVec
+	sessionStoreErrors prometheus.Counter
+}
+
+func newMetrics(db *sql.DB) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "snippetbox",
+			Name:      "http_requests_total",
+			Help:      "Total number of HTTP requests by route pattern, method and status code.",
+		}, []string{"route", "method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "snippetbox",
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route pattern and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		requestsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "snippetbox",
+			Name:      "http_requests_in_flight",
+			Help:      "Number of HTTP requests currently being served.",
+		}),
+		renderDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "snippetbox",
+			Name:      "template_render_duration_seconds",
+			Help:      "Time spent executing page templates.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1},
+		}, []string{"page"}),
+		snippetsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "snippetbox",
+			Name:      "snippets_created_total",
+			Help:      "Total number of snippets created.",
+		}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "snippetbox",
+			Name:      "logins_total",
+			Help:      "Total number of login attempts by result.",
+		}, []string{"result"}),
+		sessionStoreErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "snippetbox",
+			Name:      "session_store_errors_total",
+			Help:      "Total number of errors loading or saving sessions.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requestsTotal,
+		m.requestDuration,
+		m.requestsInFlight,
+		m.renderDuration,
+		m.snippetsCreated,
+		m.logins,
+		m.sessionStoreErrors,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	if db != nil {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(db, "snippetbox"))
+	}
+
+	// Pre-create both label values so that rate() queries work before the
+	// first failed login.
+	m.logins.WithLabelValues("success")
+	m.logins.WithLabelValues("failure")
+
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// instrument records request counts and latency for a single route. The
+// route pattern is used as the label rather than the request path so that
+// /snippet/view/:id is a single series however many snippets there are.
+func (m *metrics) instrument(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(sw, r)
+
+		m.requestsTotal.WithLabelValues(route, r.Method, strconv.Itoa(sw.status)).Inc()
+		m.requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+func (m *metrics) trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.requestsInFlight.Inc()
+		defer m.requestsInFlight.Dec()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// instrumentedRouter registers every route through metrics.instrument and
+// traceRoute, so routes() can keep using the plain httprouter methods.
+type instrumentedRouter struct {
+	*httprouter.Router
+	metrics *metrics
+}
+
+func (ir *instrumentedRouter) Handler(method, path string, handler http.Handler) {
+	ir.Router.Handler(method, path, ir.metrics.instrument(path, traceRoute(path, handler)))
+}
+
+func (ir *instrumentedRouter) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	ir.Handler(method, path, handler)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.get(t, "/snippet/view/1")
+	ts.get(t, "/snippet/view/2")
+	ts.get(t, "/missing")
+
+	admin := newTestServer(t, app.adminRoutes())
+	defer admin.Close()
+
+	code, _, body := admin.get(t, "/metrics")
+	assert.Equal(t, code, http.StatusOK)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{
+			name: "Route pattern label",
+			want: `snippetbox_http_requests_total{code="200",method="GET",route="/snippet/view/:id"} 1`,
+		},
+		{
+			name: "Not found by pattern",
+			want: `snippetbox_http_requests_total{code="404",method="GET",route="/snippet/view/:id"} 1`,
+		},
+		{
+			name: "Unmatched route",
+			want: `snippetbox_http_requests_total{code="404",method="GET",route="unmatched"} 1`,
+		},
+		{
+			name: "Template render duration",
+			want: `snippetbox_template_render_duration_seconds_count{page="view.tmpl"} 1`,
+		},
+		{
+			name: "Login failures",
+			want: `snippetbox_logins_total{result="failure"} 0`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.StringContains(t, body, tt.want)
+		})
+	}
+
+	if strings.Contains(body, `route="/snippet/view/1"`) {
+		t.Errorf("metrics contain a per-ID route label")
+	}
+}
@@ -2,12 +2,25 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"snippetbox.jonnevuorela.com/internal/models"
+	"snippetbox.jonnevuorela.com/internal/ratelimit"
+
+	"github.com/justinas/alice"
 	"github.com/justinas/nosurf"
 )
 
+// authenticate marks the request as authenticated if the session is logged
+// in and hasn't been revoked, and adds the user's role to the context. A
+// revoked session, or one whose user has been disabled, is logged out, but
+// kept, so that a flash message can still be shown.
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id := app.sessionManager.GetInt(r.Context(), "authenticatedUserId")
@@ -16,17 +29,46 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		exists, err := app.users.Exists(id)
-		if err != nil {
+		s, err := app.userSessions.Get(r.Context(), app.sessionManager.GetString(r.Context(), "sessionKey"))
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
 			app.serverError(w, err)
 			return
 		}
 
-		if exists {
-			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
-			r = r.WithContext(ctx)
+		if s == nil || s.UserId != id {
+			app.sessionManager.Remove(r.Context(), "authenticatedUserId")
+			app.sessionManager.Remove(r.Context(), "sessionKey")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := app.clientIP(r)
+		if time.Since(s.LastSeen) > sessionTouchInterval || s.IP != ip {
+			err = app.userSessions.Touch(r.Context(), s.Id, ip, app.sessionExpiry(s.Created, s.Remember))
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+		}
+
+		user, err := app.users.Get(r.Context(), id)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, err)
+			return
 		}
 
+		if user == nil || user.Disabled() {
+			app.sessionManager.Remove(r.Context(), "authenticatedUserId")
+			app.sessionManager.Remove(r.Context(), "sessionKey")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+		ctx = context.WithValue(ctx, roleContextKey, user.Role)
+		ctx = context.WithValue(ctx, userSessionContextKey, s)
+		r = r.WithContext(ctx)
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -44,6 +86,11 @@ func noSurf(next http.Handler) http.Handler {
 func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !app.isAuthenticated(r) {
+			// Remember where a GET was going, so that the user can be sent
+			// back there once they have logged in.
+			if r.Method == http.MethodGet {
+				app.sessionManager.Put(r.Context(), "redirectPathAfterLogin", r.URL.RequestURI())
+			}
 			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 			return
 		}
@@ -54,14 +101,48 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// requireRole responds with 403 Forbidden unless the user's role is at least
+// min. It goes after requireAuthentication in a chain.
+func (app *application) requireRole(min models.Role) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.role(r).AtLeast(min) {
+				app.clientError(w, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireReauthentication sends the user to enter their password again if
+// they last did so more than reauthenticationWindow ago. GET requests are
+// sent back where they were going afterwards.
+func (app *application) requireReauthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authenticatedAt := time.Unix(app.sessionManager.GetInt64(r.Context(), "authenticatedAt"), 0)
+
+		if time.Since(authenticatedAt) > reauthenticationWindow {
+			if r.Method == http.MethodGet {
+				app.sessionManager.Put(r.Context(), "reauthenticateRedirect", r.URL.RequestURI())
+			}
+			http.Redirect(w, r, "/user/reauthenticate", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+const contentSecurityPolicy = "default-src 'self'; " +
+	"style-src 'self' fonts.googleapis.com; " +
+	"font-src fonts.gstatic.com; " +
+	"img-src 'self' blob: data:"
+
 func secureHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set(
-			"Content-Security-Policy",
-			"default-src 'self'; "+
-				"style-src 'self' fonts.googleapis.com; "+
-				"font-src fonts.gstatic.com; "+
-				"img-src 'self' blob: data:")
+		w.Header().Set("Content-Security-Policy", contentSecurityPolicy)
 		w.Header().Set("Referrer-Policy", "origin-when-cross-origin")
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "deny")
@@ -71,6 +152,20 @@ func secureHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// frameable lets the pages it wraps be put in a frame by the sites in the
+// embed-frame-ancestors allowlist. It runs inside secureHeaders and replaces
+// the X-Frame-Options: deny that it sets with a frame-ancestors directive.
+func (app *application) frameable(next http.Handler) http.Handler {
+	policy := contentSecurityPolicy + "; frame-ancestors " + strings.Join(app.config.embedFrameAncestors, " ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", policy)
+		w.Header().Del("X-Frame-Options")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		app.infoLog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
@@ -90,3 +185,29 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// rateLimit returns middleware that takes a token from the named group's
+// bucket for each request, keyed by the result of key. Requests over the
+// limit get a 429 with Retry-After. If the store fails the request is let
+// through, since an outage of a shared store shouldn't take the site down.
+func (app *application) rateLimit(group string, limit ratelimit.Limit, key func(*http.Request) string) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, retryAfter, err := app.rateLimiter.Take(r.Context(), group+":"+key(r), limit)
+			if err != nil {
+				app.errorLog.Printf("rate limit store: %s", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !ok {
+				seconds := int(math.Ceil(retryAfter.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				app.clientError(w, http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -5,9 +5,12 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"testing"
+	"time"
 
 	"snippetbox.jonnevuorela.com/internal/assert"
+	"snippetbox.jonnevuorela.com/internal/ratelimit"
 )
 
 func TestSecureHeaders(t *testing.T) {
@@ -26,7 +29,7 @@ func TestSecureHeaders(t *testing.T) {
 
 	rs := rr.Result()
 
-	expectedValue := "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com"
+	expectedValue := "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com; img-src 'self' blob: data:"
 	assert.Equal(t, rs.Header.Get("Content-Security-Policy"), expectedValue)
 
 	expectedValue = "origin-when-cross-origin"
@@ -52,3 +55,168 @@ func TestSecureHeaders(t *testing.T) {
 
 	assert.Equal(t, string(body), "OK")
 }
+
+func TestFrameable(t *testing.T) {
+	app := newTestApplication(t)
+
+	rr := httptest.NewRecorder()
+
+	r, err := http.NewRequest(http.MethodGet, "/snippet/embed/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	secureHeaders(app.frameable(next)).ServeHTTP(rr, r)
+
+	rs := rr.Result()
+
+	expectedValue := "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com; img-src 'self' blob: data:; frame-ancestors 'self' https://wiki.example.com"
+	assert.Equal(t, rs.Header.Get("Content-Security-Policy"), expectedValue)
+
+	assert.Equal(t, rs.Header.Get("X-Frame-Options"), "")
+	assert.Equal(t, rs.Header.Get("X-Content-Type-Options"), "nosniff")
+}
+
+func TestRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	limit := ratelimit.Limit{Burst: 2, Period: time.Minute}
+	h := app.rateLimit("test", limit, app.ipKey)(next)
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		wantCode       int
+		wantRetryAfter string
+	}{
+		{name: "First request", remoteAddr: "192.0.2.1:1234", wantCode: http.StatusOK},
+		{name: "Second request", remoteAddr: "192.0.2.1:1234", wantCode: http.StatusOK},
+		{name: "Over limit", remoteAddr: "192.0.2.1:5678", wantCode: http.StatusTooManyRequests, wantRetryAfter: "30"},
+		{name: "Other client", remoteAddr: "192.0.2.2:1234", wantCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			r, err := http.NewRequest(http.MethodPost, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.RemoteAddr = tt.remoteAddr
+
+			h.ServeHTTP(rr, r)
+
+			rs := rr.Result()
+			assert.Equal(t, rs.StatusCode, tt.wantCode)
+			assert.Equal(t, rs.Header.Get("Retry-After"), tt.wantRetryAfter)
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		wantIP       string
+	}{
+		{
+			name:       "Direct",
+			remoteAddr: "192.0.2.1:1234",
+			wantIP:     "192.0.2.1",
+		},
+		{
+			name:         "Untrusted proxy",
+			remoteAddr:   "192.0.2.1:1234",
+			forwardedFor: "198.51.100.7",
+			wantIP:       "192.0.2.1",
+		},
+		{
+			name:         "Trusted proxy",
+			remoteAddr:   "10.0.0.5:1234",
+			forwardedFor: "198.51.100.7",
+			wantIP:       "198.51.100.7",
+		},
+		{
+			name:         "Spoofed hop",
+			remoteAddr:   "10.0.0.5:1234",
+			forwardedFor: "203.0.113.9, 198.51.100.7, 10.0.0.6",
+			wantIP:       "198.51.100.7",
+		},
+		{
+			name:       "Trusted proxy without header",
+			remoteAddr: "10.0.0.5:1234",
+			wantIP:     "10.0.0.5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+
+			assert.Equal(t, app.clientIP(r), tt.wantIP)
+		})
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	app := newTestApplication(t)
+
+	created := time.Now().Add(-11 * time.Hour)
+
+	tests := []struct {
+		name        string
+		remember    bool
+		idleTimeout time.Duration
+		want        time.Time
+	}{
+		{
+			name: "Absolute timeout only",
+			want: created.Add(12 * time.Hour),
+		},
+		{
+			name:     "Remembered",
+			remember: true,
+			want:     created.Add(30 * 24 * time.Hour),
+		},
+		{
+			name:        "Idle timeout first",
+			idleTimeout: 30 * time.Minute,
+			want:        time.Now().Add(30 * time.Minute),
+		},
+		{
+			name:        "Absolute timeout first",
+			idleTimeout: 2 * time.Hour,
+			want:        created.Add(12 * time.Hour),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app.config.session.idleTimeout = tt.idleTimeout
+
+			got := app.sessionExpiry(created, tt.remember)
+
+			assert.Equal(t, got.Sub(tt.want).Abs() < time.Second, true)
+		})
+	}
+}
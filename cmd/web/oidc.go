@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/models"
+	"snippetbox.jonnevuorela.com/internal/validator"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// oidcProvider logs users in with an external OpenID Connect identity
+// provider, using the authorization code flow with PKCE.
+type oidcProvider struct {
+	name     string
+	issuer   string
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	client   *http.Client
+}
+
+// oidcClaims are the ID token claims used to find or create the user.
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// newOIDCProvider fetches the provider's discovery document. It returns nil
+// if no issuer is configured, in which case SSO login is turned off.
+func newOIDCProvider(ctx context.Context, cfg config) (*oidcProvider, error) {
+	if cfg.oidc.issuer == "" {
+		return nil, nil
+	}
+
+	p := &oidcProvider{
+		name:   cfg.oidc.name,
+		issuer: cfg.oidc.issuer,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	provider, err := oidc.NewProvider(p.context(ctx), cfg.oidc.issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	p.oauth2 = oauth2.Config{
+		ClientID:     cfg.oidc.clientID,
+		ClientSecret: cfg.oidc.clientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  cfg.baseURL + "/user/login/oidc/callback",
+		Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: cfg.oidc.clientID})
+
+	return p, nil
+}
+
+// context makes the oidc and oauth2 packages use the provider's HTTP client,
+// which has a timeout, for discovery, JWKS and token requests.
+func (p *oidcProvider) context(ctx context.Context) context.Context {
+	ctx = oidc.ClientContext(ctx, p.client)
+	return context.WithValue(ctx, oauth2.HTTPClient, p.client)
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// userLoginOIDC sends the user to the identity provider. The state, nonce
+// and PKCE verifier are kept in the session to check the callback against.
+func (app *application) userLoginOIDC(writer http.ResponseWriter, request *http.Request) {
+	state, err := randomString()
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	nonce, err := randomString()
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	verifier := oauth2.GenerateVerifier()
+
+	app.sessionManager.Put(request.Context(), "oidcState", state)
+	app.sessionManager.Put(request.Context(), "oidcNonce", nonce)
+	app.sessionManager.Put(request.Context(), "oidcVerifier", verifier)
+
+	url := app.oidc.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+
+	http.Redirect(writer, request, url, http.StatusSeeOther)
+}
+
+func (app *application) userLoginOIDCCallback(writer http.ResponseWriter, request *http.Request) {
+	ctx := request.Context()
+
+	state := app.sessionManager.PopString(ctx, "oidcState")
+	nonce := app.sessionManager.PopString(ctx, "oidcNonce")
+	verifier := app.sessionManager.PopString(ctx, "oidcVerifier")
+
+	query := request.URL.Query()
+
+	if state == "" || query.Get("state") != state {
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	if query.Get("error") != "" {
+		app.infoLog.Printf("oidc login failed: %s: %s", query.Get("error"), query.Get("error_description"))
+		app.oidcLoginFailed(writer, request, "Login with "+app.oidc.name+" failed. Please try again.")
+		return
+	}
+
+	token, err := app.oidc.oauth2.Exchange(app.oidc.context(ctx), query.Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		app.errorLog.Printf("oidc token exchange: %v", err)
+		app.oidcLoginFailed(writer, request, "Login with "+app.oidc.name+" failed. Please try again.")
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		app.errorLog.Print("oidc token response has no id_token")
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	idToken, err := app.oidc.verifier.Verify(app.oidc.context(ctx), rawIDToken)
+	if err != nil {
+		app.errorLog.Printf("oidc id token: %v", err)
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	if idToken.Nonce != nonce {
+		app.errorLog.Print("oidc id token: nonce does not match")
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	var claims oidcClaims
+
+	err = idToken.Claims(&claims)
+	if err != nil {
+		app.errorLog.Printf("oidc id token claims: %v", err)
+		app.clientError(writer, http.StatusBadRequest)
+		return
+	}
+
+	id, err := app.oidcUser(ctx, idToken.Subject, claims)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			app.oidcLoginFailed(writer, request, "An account with this email address already exists. Please log in with your password.")
+		} else if errors.Is(err, errUnverifiedAccount) {
+			app.oidcLoginFailed(writer, request, "An account with this email address already exists, but the address hasn't been verified on it. Please log in with your password, or reset it, and verify your email address first.")
+		} else if errors.Is(err, errNoEmail) {
+			app.oidcLoginFailed(writer, request, app.oidc.name+" didn't share your email address, so we can't log you in.")
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	user, err := app.users.Get(ctx, id)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	if user.Disabled() {
+		app.audit(request, models.AuditLoginFailed, 0, map[string]string{"email": user.Email, "reason": "disabled"})
+		app.oidcLoginFailed(writer, request, "This account has been disabled.")
+		return
+	}
+
+	// The identity provider may not ask for a second factor, and the account
+	// may have been linked by email address alone, so a user with two factor
+	// authentication turned on still has to enter theirs.
+	_, err = app.twoFactor.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.logIn(writer, request, id, false)
+		} else {
+			app.serverError(writer, err)
+		}
+		return
+	}
+
+	app.startTwoFactorLogin(writer, request, id, false)
+}
+
+var (
+	errNoEmail           = errors.New("oidc: id token has no email claim")
+	errUnverifiedAccount = errors.New("oidc: existing account has not verified its email")
+)
+
+// oidcUser returns the user linked to the identity. On first login the
+// identity is linked to the user with the same email address, if both the
+// provider and the account have verified that address, or else a new user is
+// created. An account that never verified its address may have been signed
+// up by someone else in the owner's name, with a password and second factor
+// they still hold, so it is never linked. A user created here has a random
+// password, which they can replace with the password reset flow if they want
+// to log in without SSO.
+func (app *application) oidcUser(ctx context.Context, subject string, claims oidcClaims) (int, error) {
+	id, err := app.identities.Get(ctx, app.oidc.issuer, subject)
+	if err == nil {
+		return id, nil
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		return 0, err
+	}
+
+	if claims.Email == "" {
+		return 0, errNoEmail
+	}
+
+	user, err := app.users.GetByEmail(ctx, claims.Email)
+	switch {
+	case err == nil:
+		if !claims.EmailVerified {
+			return 0, models.ErrDuplicateEmail
+		}
+		if !user.EmailVerified() {
+			return 0, errUnverifiedAccount
+		}
+		id = user.Id
+	case errors.Is(err, models.ErrNoRecord):
+		name := claims.Name
+		if name == "" {
+			name, _, _ = strings.Cut(claims.Email, "@")
+		}
+
+		password, err := randomString()
+		if err != nil {
+			return 0, err
+		}
+
+		id, err = app.insertOIDCUser(ctx, name, claims.Email, password)
+		if err != nil {
+			return 0, err
+		}
+
+		if claims.EmailVerified {
+			// The account's address is claims.Email, so ErrNoRecord only
+			// means it was verified a moment ago.
+			err = app.users.MarkEmailVerified(ctx, id, claims.Email)
+			if err != nil && !errors.Is(err, models.ErrNoRecord) {
+				return 0, err
+			}
+		}
+	default:
+		return 0, err
+	}
+
+	err = app.identities.Link(ctx, id, app.oidc.issuer, subject)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// insertOIDCUser creates a user for an SSO login. SSO users don't choose a
+// handle, so one is made from their email address, with a random suffix if
+// it is taken.
+func (app *application) insertOIDCUser(ctx context.Context, name, email, password string) (int, error) {
+	handle := handleFromEmail(email)
+
+	for attempt := 0; ; attempt++ {
+		h := handle
+		if attempt > 0 {
+			suffix, err := randomString()
+			if err != nil {
+				return 0, err
+			}
+			h = handle + "-" + strings.ToLower(suffix[:4])
+		}
+
+		id, err := app.users.Insert(ctx, name, h, email, password)
+		if errors.Is(err, models.ErrDuplicateHandle) && attempt < 5 {
+			continue
+		}
+		return id, err
+	}
+}
+
+// handleFromEmail makes a valid handle out of the local part of an email
+// address.
+func handleFromEmail(email string) string {
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+
+	var b strings.Builder
+	for _, r := range local {
+		if strings.ContainsRune(validator.HandleChars, r) {
+			b.WriteRune(r)
+		}
+	}
+
+	handle := b.String()
+	if len(handle) > 20 {
+		handle = handle[:20]
+	}
+	if len(handle) < 3 || validator.PermittedValue(handle, validator.ReservedHandles...) {
+		handle = "user-" + handle
+	}
+	return handle
+}
+
+func (app *application) oidcLoginFailed(writer http.ResponseWriter, request *http.Request, message string) {
+	app.sessionManager.Put(request.Context(), "flash", message)
+	http.Redirect(writer, request, "/user/login", http.StatusSeeOther)
+}
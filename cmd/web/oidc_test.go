@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+)
+
+// fakeIdP is a minimal OpenID Connect provider, so that the SSO login flow
+// can be tested without a network. It checks the PKCE verifier and client
+// credentials like a real provider, and issues RS256 ID tokens for whatever
+// identity the test sets.
+type fakeIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu       sync.Mutex
+	requests map[string]fakeAuthRequest
+
+	subject       string
+	email         string
+	emailVerified bool
+	nonce         string
+}
+
+type fakeAuthRequest struct {
+	challenge string
+	nonce     string
+}
+
+const (
+	fakeClientID     = "snippetbox"
+	fakeClientSecret = "client-secret"
+)
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &fakeIdP{key: key, requests: map[string]fakeAuthRequest{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("GET /jwks", idp.jwks)
+	mux.HandleFunc("GET /authorize", idp.authorize)
+	mux.HandleFunc("POST /token", idp.token)
+
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+
+	return idp
+}
+
+func (idp *fakeIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                idp.URL,
+		"authorization_endpoint":                idp.URL + "/authorize",
+		"token_endpoint":                        idp.URL + "/token",
+		"jwks_uri":                              idp.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (idp *fakeIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (idp *fakeIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	if q.Get("response_type") != "code" || q.Get("client_id") != fakeClientID || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	code, err := randomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	idp.mu.Lock()
+	idp.requests[code] = fakeAuthRequest{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	idp.mu.Unlock()
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	redirect.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (idp *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != fakeClientID || clientSecret != fakeClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	idp.mu.Lock()
+	req, ok := idp.requests[r.PostForm.Get("code")]
+	delete(idp.requests, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != req.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	nonce := req.nonce
+	if idp.nonce != "" {
+		nonce = idp.nonce
+	}
+
+	idToken := idp.sign(map[string]any{
+		"iss":            idp.URL,
+		"sub":            idp.subject,
+		"aud":            fakeClientID,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          idp.email,
+		"email_verified": idp.emailVerified,
+	})
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+func (idp *fakeIdP) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	sum := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, sum[:])
+	if err != nil {
+		panic(err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// loginOIDC runs the browser's side of the SSO flow: it follows the redirect
+// to the IdP and back, and returns the response to the callback.
+func (idp *fakeIdP) loginOIDC(t *testing.T, ts *testServer) (int, http.Header, string) {
+	code, header, _ := ts.get(t, "/user/login/oidc")
+	if code != http.StatusSeeOther {
+		t.Fatalf("got status %d starting SSO login", code)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	rs, err := client.Get(header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+
+	if rs.StatusCode != http.StatusFound {
+		t.Fatalf("got status %d from the IdP", rs.StatusCode)
+	}
+
+	callback, err := url.Parse(rs.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ts.get(t, callback.Path+"?"+callback.RawQuery)
+}
+
+func newTestOIDCProvider(t *testing.T, app *application, idp *fakeIdP) {
+	cfg := app.config
+	cfg.oidc.issuer = idp.URL
+	cfg.oidc.clientID = fakeClientID
+	cfg.oidc.clientSecret = fakeClientSecret
+	cfg.oidc.name = "Example SSO"
+
+	var err error
+	app.oidc, err = newOIDCProvider(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserLoginOIDC(t *testing.T) {
+	idp := newFakeIdP(t)
+
+	app := newTestApplication(t)
+	newTestOIDCProvider(t, app, idp)
+
+	tests := []struct {
+		name          string
+		subject       string
+		email         string
+		emailVerified bool
+		nonce         string
+		wantCode      int
+		wantLocation  string
+		wantFlash     string
+	}{
+		{
+			name:         "Linked identity",
+			subject:      "alice-subject",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/create",
+		},
+		{
+			name:          "Existing user with verified email",
+			subject:       "alice-other-subject",
+			email:         "alice@example.com",
+			emailVerified: true,
+			wantCode:      http.StatusSeeOther,
+			wantLocation:  "/snippet/create",
+		},
+		{
+			name:         "Linked identity with two factor",
+			subject:      "dave-subject",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/login/2fa",
+		},
+		{
+			name:          "Existing user with two factor",
+			subject:       "dave-other-subject",
+			email:         "dave@example.com",
+			emailVerified: true,
+			wantCode:      http.StatusSeeOther,
+			wantLocation:  "/user/login/2fa",
+		},
+		{
+			name:         "Existing user with unverified email",
+			subject:      "alice-other-subject",
+			email:        "alice@example.com",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/login",
+			wantFlash:    "An account with this email address already exists.",
+		},
+		{
+			name:          "Existing user who never verified their email",
+			subject:       "carol-subject",
+			email:         "carol@example.com",
+			emailVerified: true,
+			wantCode:      http.StatusSeeOther,
+			wantLocation:  "/user/login",
+			wantFlash:     "the address hasn&#39;t been verified on it",
+		},
+		{
+			name:          "New user",
+			subject:       "new-subject",
+			email:         "new@example.com",
+			emailVerified: true,
+			wantCode:      http.StatusSeeOther,
+			wantLocation:  "/snippet/create",
+		},
+		{
+			name:         "No email",
+			subject:      "new-subject",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/login",
+			wantFlash:    "Example SSO didn&#39;t share your email address",
+		},
+		{
+			name:     "Wrong nonce",
+			subject:  "alice-subject",
+			nonce:    "replayed-nonce",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			idp.subject = tt.subject
+			idp.email = tt.email
+			idp.emailVerified = tt.emailVerified
+			idp.nonce = tt.nonce
+
+			code, header, _ := idp.loginOIDC(t, ts)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+
+			if tt.wantFlash != "" {
+				_, _, body := ts.get(t, "/user/login")
+				assert.StringContains(t, body, tt.wantFlash)
+			}
+
+			code, _, _ = ts.get(t, "/snippet/create")
+			assert.Equal(t, code == http.StatusOK, tt.wantLocation == "/snippet/create")
+		})
+	}
+}
+
+func TestUserLoginOIDCState(t *testing.T) {
+	idp := newFakeIdP(t)
+
+	app := newTestApplication(t)
+	newTestOIDCProvider(t, app, idp)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	assert.StringContains(t, body, "Log in with Example SSO")
+
+	code, header, _ := ts.get(t, "/user/login/oidc")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	authURL, err := url.Parse(header.Get("Location"))
+	assert.NilError(t, err)
+
+	q := authURL.Query()
+	assert.Equal(t, q.Get("code_challenge_method"), "S256")
+	assert.Equal(t, q.Get("redirect_uri"), "https://snippetbox.test/user/login/oidc/callback")
+
+	// A callback with a state that this session didn't start is rejected.
+	code, _, _ = ts.get(t, "/user/login/oidc/callback?code=abc&state=forged")
+	assert.Equal(t, code, http.StatusBadRequest)
+}
+
+func TestHandleFromEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{"alice@example.com", "alice"},
+		{"Bob.Smith+work@example.com", "bobsmithwork"},
+		{"al@example.com", "user-al"},
+		{"admin@example.com", "user-admin"},
+		{"a.very.long.address.indeed@example.com", "averylongaddressinde"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			assert.Equal(t, handleFromEmail(tt.email), tt.want)
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 
+	"snippetbox.jonnevuorela.com/internal/models"
 	"snippetbox.jonnevuorela.com/ui"
 
 	"github.com/julienschmidt/httprouter"
@@ -11,32 +12,142 @@ import (
 
 func (app *application) routes() http.Handler {
 
-	router := httprouter.New()
+	router := &instrumentedRouter{Router: httprouter.New(), metrics: app.metrics}
 
-	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	router.NotFound = app.metrics.instrument("unmatched", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		app.notFound(w)
-	})
+	}))
 
 	fileServer := http.FileServer(http.FS(ui.Files))
 	router.Handler(http.MethodGet, "/static/*filepath", fileServer)
 
 	router.HandlerFunc(http.MethodGet, "/ping", ping)
+	router.HandlerFunc(http.MethodGet, "/healthz", healthz)
+	router.HandlerFunc(http.MethodGet, "/readyz", app.readyz)
+
+	// Feeds are read by feed readers, not people, so they skip the session.
+	router.HandlerFunc(http.MethodGet, "/feed.atom", app.feedLatest)
+	router.HandlerFunc(http.MethodGet, "/u/:handle/feed.atom", app.feedUser)
+	router.HandlerFunc(http.MethodGet, "/tags/:tag/feed.atom", app.feedTag)
+
+	// Embeds are shown on other sites, so they skip the session too, and may
+	// be framed by the sites in the embed-frame-ancestors allowlist.
+	router.Handler(http.MethodGet, "/snippet/embed/:id", alice.New(traceMiddleware("frameable", app.frameable)).ThenFunc(app.snippetEmbed))
+	router.HandlerFunc(http.MethodGet, "/oembed", app.oembed)
 
-	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authenticate)
+	dynamic := alice.New(
+		traceMiddleware("session", app.sessionManager.LoadAndSave),
+		traceMiddleware("noSurf", noSurf),
+		traceMiddleware("authenticate", app.authenticate),
+	)
+
+	loginLimit := app.rateLimit("login", app.config.limits.login, app.ipKey)
+	signupLimit := app.rateLimit("signup", app.config.limits.signup, app.ipKey)
+	createLimit := app.rateLimit("create", app.config.limits.create, app.userKey)
+	commentLimit := app.rateLimit("comment", app.config.limits.comment, app.userKey)
+	collectionLimit := app.rateLimit("collection", app.config.limits.collection, app.userKey)
+	resetLimit := app.rateLimit("reset", app.config.limits.reset, app.ipKey)
+	verifyLimit := app.rateLimit("verify", app.config.limits.reset, app.userKey)
 
 	router.Handler(http.MethodGet, "/", dynamic.ThenFunc(app.home))
 	router.Handler(http.MethodGet, "/snippet/view/:id", dynamic.ThenFunc(app.snippetView))
+	router.Handler(http.MethodGet, "/snippet/download/:id", dynamic.ThenFunc(app.snippetDownload))
+	router.Handler(http.MethodGet, "/u/:handle", dynamic.ThenFunc(app.userProfile))
+	router.Handler(http.MethodGet, "/c/:slug", dynamic.ThenFunc(app.collectionView))
+	router.Handler(http.MethodGet, "/tags/:tag", dynamic.ThenFunc(app.tagView))
 	router.Handler(http.MethodGet, "/user/signup", dynamic.ThenFunc(app.userSignup))
-	router.Handler(http.MethodPost, "/user/signup", dynamic.ThenFunc(app.userSignupPost))
+	router.Handler(http.MethodPost, "/user/signup", dynamic.Append(signupLimit).ThenFunc(app.userSignupPost))
 	router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(app.userLogin))
-	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLoginPost))
+	router.Handler(http.MethodPost, "/user/login", dynamic.Append(loginLimit).ThenFunc(app.userLoginPost))
+	router.Handler(http.MethodGet, "/user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactor))
+	router.Handler(http.MethodPost, "/user/login/2fa", dynamic.Append(loginLimit).ThenFunc(app.userLoginTwoFactorPost))
+
+	if app.oidc != nil {
+		router.Handler(http.MethodGet, "/user/login/oidc", dynamic.Append(loginLimit).ThenFunc(app.userLoginOIDC))
+		router.Handler(http.MethodGet, "/user/login/oidc/callback", dynamic.ThenFunc(app.userLoginOIDCCallback))
+	}
 
-	protected := dynamic.Append(app.requireAuthentication)
+	router.Handler(http.MethodGet, "/user/password/forgot", dynamic.ThenFunc(app.userPasswordForgot))
+	router.Handler(http.MethodPost, "/user/password/forgot", dynamic.Append(resetLimit).ThenFunc(app.userPasswordForgotPost))
+	router.Handler(http.MethodGet, "/user/password/reset/:token", dynamic.ThenFunc(app.userPasswordReset))
+	router.Handler(http.MethodPost, "/user/password/reset/:token", dynamic.ThenFunc(app.userPasswordResetPost))
+	router.Handler(http.MethodGet, "/user/verify/:token", dynamic.ThenFunc(app.userVerifyEmail))
+
+	protected := dynamic.Append(traceMiddleware("requireAuthentication", app.requireAuthentication))
 
 	router.Handler(http.MethodGet, "/snippet/create", protected.ThenFunc(app.snippetCreate))
-	router.Handler(http.MethodPost, "/snippet/create", protected.ThenFunc(app.snippetCreatePost))
+	router.Handler(http.MethodPost, "/snippet/create", protected.Append(createLimit).ThenFunc(app.snippetCreatePost))
+	router.Handler(http.MethodPost, "/snippet/view/:id/comment", protected.Append(commentLimit).ThenFunc(app.commentCreatePost))
+	router.Handler(http.MethodPost, "/snippet/view/:id/comments", protected.ThenFunc(app.snippetCommentsPost))
+	router.Handler(http.MethodPost, "/snippet/view/:id/star", protected.ThenFunc(app.snippetStarPost))
+	router.Handler(http.MethodGet, "/user/starred", protected.ThenFunc(app.userStarred))
+	router.Handler(http.MethodPost, "/snippet/view/:id/collect", protected.ThenFunc(app.snippetCollectPost))
+	router.Handler(http.MethodGet, "/user/collections", protected.ThenFunc(app.userCollections))
+	router.Handler(http.MethodGet, "/collection/create", protected.ThenFunc(app.collectionCreate))
+	router.Handler(http.MethodPost, "/collection/create", protected.Append(collectionLimit).ThenFunc(app.collectionCreatePost))
+	router.Handler(http.MethodGet, "/c/:slug/edit", protected.ThenFunc(app.collectionEdit))
+	router.Handler(http.MethodPost, "/c/:slug/edit", protected.ThenFunc(app.collectionEditPost))
+	router.Handler(http.MethodPost, "/c/:slug/delete", protected.ThenFunc(app.collectionDeletePost))
+	router.Handler(http.MethodPost, "/c/:slug/move", protected.ThenFunc(app.collectionMovePost))
+	router.Handler(http.MethodPost, "/c/:slug/remove", protected.ThenFunc(app.collectionRemovePost))
+	router.Handler(http.MethodGet, "/comment/edit/:id", protected.ThenFunc(app.commentEdit))
+	router.Handler(http.MethodPost, "/comment/edit/:id", protected.ThenFunc(app.commentEditPost))
+	router.Handler(http.MethodPost, "/comment/delete/:id", protected.ThenFunc(app.commentDeletePost))
 	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogoutPost))
+	router.Handler(http.MethodGet, "/user/snippets", protected.ThenFunc(app.userSnippets))
+	router.Handler(http.MethodPost, "/user/snippets", protected.ThenFunc(app.userSnippetsPost))
+	router.Handler(http.MethodGet, "/user/verify", protected.ThenFunc(app.userVerifyResend))
+	router.Handler(http.MethodPost, "/user/verify", protected.Append(verifyLimit).ThenFunc(app.userVerifyResendPost))
+	router.Handler(http.MethodGet, "/account", protected.ThenFunc(app.accountView))
+	router.Handler(http.MethodGet, "/account/name/update", protected.ThenFunc(app.accountNameUpdate))
+	router.Handler(http.MethodPost, "/account/name/update", protected.ThenFunc(app.accountNameUpdatePost))
+	router.Handler(http.MethodGet, "/user/reauthenticate", protected.ThenFunc(app.userReauthenticate))
+	router.Handler(http.MethodPost, "/user/reauthenticate", protected.Append(loginLimit).ThenFunc(app.userReauthenticatePost))
+	router.Handler(http.MethodGet, "/account/sessions", protected.ThenFunc(app.accountSessions))
+	router.Handler(http.MethodPost, "/account/sessions/revoke", protected.ThenFunc(app.accountSessionRevokePost))
+	router.Handler(http.MethodPost, "/account/sessions/revoke-all", protected.ThenFunc(app.accountSessionRevokeAllPost))
+
+	sensitive := protected.Append(traceMiddleware("requireReauthentication", app.requireReauthentication))
+
+	router.Handler(http.MethodGet, "/account/email/update", sensitive.ThenFunc(app.accountEmailUpdate))
+	router.Handler(http.MethodPost, "/account/email/update", sensitive.Append(verifyLimit).ThenFunc(app.accountEmailUpdatePost))
+	router.Handler(http.MethodGet, "/account/password/update", sensitive.ThenFunc(app.accountPasswordUpdate))
+	router.Handler(http.MethodPost, "/account/password/update", sensitive.ThenFunc(app.accountPasswordUpdatePost))
+	router.Handler(http.MethodGet, "/account/2fa", sensitive.ThenFunc(app.accountTwoFactor))
+	router.Handler(http.MethodPost, "/account/2fa/enable", sensitive.ThenFunc(app.accountTwoFactorEnablePost))
+	router.Handler(http.MethodPost, "/account/2fa/disable", sensitive.ThenFunc(app.accountTwoFactorDisablePost))
+
+	moderator := protected.Append(traceMiddleware("requireRole", app.requireRole(models.RoleModerator)))
 
-	standard := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
+	router.Handler(http.MethodGet, "/admin", moderator.ThenFunc(app.admin))
+	router.Handler(http.MethodGet, "/admin/snippets", moderator.ThenFunc(app.adminSnippets))
+	router.Handler(http.MethodPost, "/admin/snippets/delete", moderator.ThenFunc(app.adminSnippetDeletePost))
+
+	admin := protected.Append(traceMiddleware("requireRole", app.requireRole(models.RoleAdmin)))
+
+	router.Handler(http.MethodGet, "/admin/users", admin.ThenFunc(app.adminUsers))
+	router.Handler(http.MethodPost, "/admin/users/disable", admin.ThenFunc(app.adminUserDisablePost))
+	router.Handler(http.MethodPost, "/admin/users/enable", admin.ThenFunc(app.adminUserEnablePost))
+	router.Handler(http.MethodPost, "/admin/users/role", admin.ThenFunc(app.adminUserRolePost))
+	router.Handler(http.MethodGet, "/admin/audit", admin.ThenFunc(app.adminAudit))
+
+	standard := alice.New(
+		traceRequests,
+		app.recoverPanic,
+		app.logRequest,
+		app.metrics.trackInFlight,
+		traceMiddleware("secureHeaders", secureHeaders),
+	)
 	return standard.Then(router)
 }
+
+// adminRoutes is served on a separate listener so that operational endpoints
+// are never exposed alongside the public site.
+func (app *application) adminRoutes() http.Handler {
+	router := httprouter.New()
+
+	router.Handler(http.MethodGet, "/metrics", app.metrics.handler())
+	router.HandlerFunc(http.MethodGet, "/readyz", app.readyzDetail)
+
+	return app.recoverPanic(router)
+}
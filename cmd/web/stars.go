@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/models"
+)
+
+// snippetStarPost stars the snippet for the user, or unstars it if they had
+// already starred it. It is a plain form post so that it works without
+// JavaScript.
+func (app *application) snippetStarPost(writer http.ResponseWriter, request *http.Request) {
+	snippet, ok := app.snippetFromParams(writer, request)
+	if !ok {
+		return
+	}
+
+	starred, err := app.stars.Toggle(request.Context(), app.authenticatedUserId(request), snippet.Id)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	if starred {
+		app.sessionManager.Put(request.Context(), "flash", "Snippet starred.")
+	} else {
+		app.sessionManager.Put(request.Context(), "flash", "Snippet unstarred.")
+	}
+
+	http.Redirect(writer, request, fmt.Sprintf("/snippet/view/%d", snippet.Id), http.StatusSeeOther)
+}
+
+func (app *application) userStarred(writer http.ResponseWriter, request *http.Request) {
+	snippets, err := app.snippets.StarredBy(request.Context(), app.authenticatedUserId(request))
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	data := app.newTemplateData(request)
+	data.Snippets = snippets
+
+	data.StarCounts, err = app.starCounts(request, snippets, time.Time{})
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.render(writer, request, http.StatusOK, "starred.tmpl", data)
+}
+
+// starCounts returns how many stars each of the snippets has been given since
+// the given time, or ever if it is zero, for the snippet listings.
+func (app *application) starCounts(request *http.Request, snippets []*models.Snippet, since time.Time) (map[int]int, error) {
+	ids := make([]int, len(snippets))
+	for i, s := range snippets {
+		ids[i] = s.Id
+	}
+	return app.stars.Counts(request.Context(), ids, since)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"snippetbox.jonnevuorela.com/internal/models"
+	"snippetbox.jonnevuorela.com/internal/validator"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// parseTags splits what was typed into a snippet's tags field at commas and
+// spaces. Tags are lower cased, and repeats are dropped.
+func parseTags(s string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, tag := range fields {
+		if !seen[tag] {
+			tags = append(tags, tag)
+			seen[tag] = true
+		}
+	}
+	return tags
+}
+
+// validTag reports whether tag is one a snippet can have.
+func validTag(tag string) bool {
+	return validator.NotBlank(tag) && validator.MaxChar(tag, models.TagMaxChars) && validator.AllowedChars(tag, models.TagChars)
+}
+
+// tagView lists the public snippets with a tag, newest first, a page at a
+// time like a profile.
+func (app *application) tagView(writer http.ResponseWriter, request *http.Request) {
+	params := httprouter.ParamsFromContext(request.Context())
+
+	tag := params.ByName("tag")
+	if !validTag(tag) {
+		app.notFound(writer)
+		return
+	}
+
+	page := 1
+	if s := request.URL.Query().Get("page"); s != "" {
+		var err error
+		page, err = strconv.Atoi(s)
+		if err != nil || page < 1 {
+			app.notFound(writer)
+			return
+		}
+	}
+
+	// One more than a page is fetched to find out if there is a next page.
+	snippets, err := app.snippets.PublicByTag(request.Context(), tag, profilePageSize+1, (page-1)*profilePageSize)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	data := app.newTemplateData(request)
+	data.Tag = tag
+	data.Pagination = &pagination{Page: page, Prev: page - 1}
+
+	if len(snippets) > profilePageSize {
+		snippets = snippets[:profilePageSize]
+		data.Pagination.Next = page + 1
+	}
+	data.Snippets = snippets
+
+	data.CommentCounts, err = app.commentCounts(request, snippets)
+	if err != nil {
+		app.serverError(writer, err)
+		return
+	}
+
+	app.render(writer, request, http.StatusOK, "tag.tmpl", data)
+}
@@ -6,18 +6,67 @@ import (
 	"path/filepath"
 	"time"
 
+	"snippetbox.jonnevuorela.com/internal/highlight"
+	"snippetbox.jonnevuorela.com/internal/markdown"
 	"snippetbox.jonnevuorela.com/internal/models"
 	"snippetbox.jonnevuorela.com/ui"
 )
 
+// twoFactorSetup is what a user needs to add their TOTP secret to an
+// authenticator app: a QR code of the otpauth URI and the key to type in by
+// hand if they can't scan it.
+type twoFactorSetup struct {
+	QRCode template.URL
+	Key    string
+}
+
+// pagination links a page of a list to its neighbours. Prev and Next are the
+// neighbouring page numbers, or 0 if there isn't one.
+type pagination struct {
+	Page int
+	Prev int
+	Next int
+}
+
 type templateData struct {
-	CurrentYear     int
-	Snippet         *models.Snippet
-	Snippets        []*models.Snippet
-	Form            any
-	Flash           string
-	IsAuthenticated bool
-	CSRFToken       string
+	CurrentYear         int
+	User                *models.User
+	Profile             *models.User
+	Author              *models.User
+	TwoFactor           *models.TwoFactor
+	TwoFactorSetup      *twoFactorSetup
+	RecoveryCodes       []string
+	Sessions            []*models.UserSession
+	CurrentSession      *models.UserSession
+	Snippet             *models.Snippet
+	Files               []*models.SnippetFile
+	ShowSource          bool
+	Tags                []string
+	Tag                 string
+	Languages           []string
+	Parent              *models.Snippet
+	Forks               []*models.Snippet
+	Snippets            []*models.Snippet
+	CommentCounts       map[int]int
+	StarCounts          map[int]int
+	Collection          *models.Collection
+	Collections         []*models.Collection
+	Starred             bool
+	Comment             *models.Comment
+	Comments            []*models.Comment
+	Pagination          *pagination
+	Users               []*models.User
+	Roles               []models.Role
+	AuditEvents         []*models.AuditEvent
+	AuditActions        []string
+	Form                any
+	Flash               string
+	IsAuthenticated     bool
+	AuthenticatedUserId int
+	Role                models.Role
+	CSRFToken           string
+	OIDCName            string
+	BaseURL             string
 }
 
 func humanDate(t time.Time) string {
@@ -27,8 +76,23 @@ func humanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+// commentNode is what the recursive "comment" template is given: one comment
+// of the thread, and the page's data for the forms and links around it.
+type commentNode struct {
+	Comment *models.Comment
+	Page    *templateData
+}
+
+func newCommentNode(c *models.Comment, page *templateData) commentNode {
+	return commentNode{Comment: c, Page: page}
+}
+
 var functions = template.FuncMap{
-	"humanDate": humanDate,
+	"humanDate":        humanDate,
+	"highlight":        highlight.Code,
+	"markdown":         markdown.Comment,
+	"markdownDocument": markdown.Document,
+	"commentNode":      newCommentNode,
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
@@ -56,5 +120,14 @@ func newTemplateCache() (map[string]*template.Template, error) {
 		cache[name] = ts
 	}
 
+	// The embed page has a layout of its own, without the site's header and
+	// navigation.
+	ts, err := template.New("embed.tmpl").Funcs(functions).ParseFS(ui.Files, "html/embed.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	cache["embed.tmpl"] = ts
+
 	return cache, nil
 }
@@ -13,7 +13,9 @@ import (
 	"testing"
 	"time"
 
+	"snippetbox.jonnevuorela.com/internal/mailer"
 	"snippetbox.jonnevuorela.com/internal/models/mocks"
+	"snippetbox.jonnevuorela.com/internal/ratelimit"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
@@ -39,18 +41,47 @@ func newTestApplication(t *testing.T) *application {
 	formDecoder := form.NewDecoder()
 
 	sessionManager := scs.New()
-	sessionManager.Lifetime = 12 * time.Hour
+	sessionManager.Lifetime = 30 * 24 * time.Hour
+	sessionManager.Cookie.Persist = false
 	sessionManager.Cookie.Secure = true
 
-	return &application{
-		errorLog:       log.New(io.Discard, "", 0),
-		infoLog:        log.New(io.Discard, "", 0),
-		snippets:       &mocks.SnippetModel{},
-		users:          &mocks.UserModel{},
-		templateCache:  templateCache,
-		formDecoder:    formDecoder,
-		sessionManager: sessionManager,
+	app := &application{
+		config: config{
+			baseURL:             "https://snippetbox.test",
+			readinessTimeout:    time.Second,
+			embedFrameAncestors: []string{"'self'", "https://wiki.example.com"},
+		},
+		errorLog:           log.New(io.Discard, "", 0),
+		infoLog:            log.New(io.Discard, "", 0),
+		snippets:           &mocks.SnippetModel{},
+		users:              &mocks.UserModel{},
+		loginAttempts:      &mocks.LoginAttemptModel{},
+		passwordResets:     &mocks.PasswordResetModel{},
+		emailVerifications: &mocks.EmailVerificationModel{},
+		twoFactor:          &mocks.TwoFactorModel{},
+		userSessions:       &mocks.UserSessionModel{},
+		identities:         &mocks.IdentityModel{},
+		auditEvents:        &mocks.AuditModel{},
+		comments:           &mocks.CommentModel{},
+		stars:              &mocks.StarModel{},
+		collections:        &mocks.CollectionModel{},
+		templateCache:      templateCache,
+		formDecoder:        formDecoder,
+		sessionManager:     sessionManager,
+		metrics:            newMetrics(nil),
+		rateLimiter:        ratelimit.NewMemoryStore(),
+		mailer:             &mailer.MemoryMailer{},
 	}
+
+	app.config.session.lifetime = 12 * time.Hour
+	app.config.session.rememberLifetime = 30 * 24 * time.Hour
+
+	app.readinessChecks = []healthCheck{
+		sessionStoreCheck(sessionManager.Store),
+		app.templateCacheCheck(),
+	}
+
+	return app
 }
 
 type testServer struct {
@@ -105,3 +136,38 @@ func (ts *testServer) get(t *testing.T, urlPath string) (int, http.Header, strin
 
 	return rs.StatusCode, rs.Header, string(body)
 }
+
+// login logs the test server's client in with a password and returns a CSRF
+// token for the new session. For users with two-factor authentication the
+// session is left waiting for the second step.
+func (ts *testServer) login(t *testing.T, email, password string) string {
+	_, _, body := ts.get(t, "/user/login")
+
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", password)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, header, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login as %s: got status %d", email, code)
+	}
+
+	_, _, body = ts.get(t, header.Get("Location"))
+	return extractCSRFToken(t, body)
+}
+
+// sessionCookie returns the value of the client's session cookie.
+func (ts *testServer) sessionCookie(t *testing.T) string {
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range ts.Client().Jar.Cookies(u) {
+		if c.Name == "session" {
+			return c.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/justinas/alice"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("snippetbox.jonnevuorela.com/cmd/web")
+
+// newTracerProvider builds the tracer provider for the configured exporter
+// and installs it, along with the W3C trace context propagator, as the
+// global default. With the "none" exporter spans are still created so that
+// incoming traceparent headers are honoured, but nothing is exported.
+func newTracerProvider(cfg config) (*sdktrace.TracerProvider, error) {
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.trace.sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "snippetbox"))),
+	}
+
+	switch cfg.trace.exporter {
+	case "none":
+	case "stdout":
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	case "otlp":
+		exporter, err := otlptracehttp.New(context.Background(),
+			otlptracehttp.WithEndpoint(cfg.trace.otlpEndpoint),
+			otlptracehttp.WithInsecure(),
+		)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.trace.exporter)
+	}
+
+	tp := sdktrace.NewTracerProvider(opts...)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	return tp, nil
+}
+
+// traceRequests starts the server span for every request, continuing the
+// trace from an incoming traceparent header if there is one.
+func traceRequests(next http.Handler) http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), serverSpanContextKey, trace.SpanFromContext(r.Context()))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+
+	return otelhttp.NewHandler(h, "http.server",
+		otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+			return r.Method
+		}),
+	)
+}
+
+// traceMiddleware wraps a middleware constructor so that the middleware and
+// everything it calls run inside a span of their own.
+func traceMiddleware(name string, mw alice.Constructor) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		h := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, span := tracer.Start(r.Context(), "middleware "+name)
+			defer span.End()
+
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// traceRoute names the server span after the matched route pattern and
+// starts a span for the route's handler chain.
+func traceRoute(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Method + " " + route
+
+		if serverSpan, ok := r.Context().Value(serverSpanContextKey).(trace.Span); ok {
+			serverSpan.SetName(name)
+			serverSpan.SetAttributes(attribute.String("http.route", route))
+		}
+
+		ctx, span := tracer.Start(r.Context(), "handler "+name)
+		defer span.End()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// The global tracer provider can only be delegated to once per process, so
+// every test shares one recorder and filters spans by trace ID.
+var spanRecorder = sync.OnceValue(func() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+})
+
+func TestTracing(t *testing.T) {
+	recorder := spanRecorder()
+
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/snippet/view/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+
+	// Close waits for the handler to return, so every span has ended.
+	ts.Close()
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() == traceID {
+			spans[span.Name()] = span
+		}
+	}
+
+	tests := []struct {
+		name       string
+		wantParent string
+	}{
+		{
+			name:       "GET /snippet/view/:id",
+			wantParent: "00f067aa0ba902b7",
+		},
+		{
+			name:       "middleware secureHeaders",
+			wantParent: "GET /snippet/view/:id",
+		},
+		{
+			name:       "handler GET /snippet/view/:id",
+			wantParent: "middleware secureHeaders",
+		},
+		{
+			name:       "middleware session",
+			wantParent: "handler GET /snippet/view/:id",
+		},
+		{
+			name:       "middleware noSurf",
+			wantParent: "middleware session",
+		},
+		{
+			name:       "middleware authenticate",
+			wantParent: "middleware noSurf",
+		},
+		{
+			name:       "render view.tmpl",
+			wantParent: "middleware authenticate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span, ok := spans[tt.name]
+			if !ok {
+				t.Fatalf("no span named %q", tt.name)
+			}
+
+			parentID := span.Parent().SpanID().String()
+			if parent, ok := spans[tt.wantParent]; ok {
+				assert.Equal(t, parentID, parent.SpanContext().SpanID().String())
+			} else {
+				assert.Equal(t, parentID, tt.wantParent)
+			}
+		})
+	}
+}
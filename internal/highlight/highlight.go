@@ -0,0 +1,118 @@
+// Package highlight colours source code for display. It is used for snippet
+// files and for fenced code blocks in Markdown, so that code looks the same
+// wherever it is shown. Tokens are marked up with classes rather than inline
+// styles, which the Content-Security-Policy blocks; the colours are in
+// ui/static/css/highlight.css, which is written by CSS.
+package highlight
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// ClassPrefix starts the class of every token, so that token classes can't
+// clash with the site's own and sanitisers can allow just these.
+const ClassPrefix = "hl-"
+
+// Code returns the source as HTML for the inside of a code element, with its
+// tokens in spans. A language that isn't known, including "" for plain text,
+// is escaped without highlighting.
+func Code(language, source string) template.HTML {
+	var b strings.Builder
+	_ = Write(&b, language, source)
+	return template.HTML(b.String())
+}
+
+// Write writes the source to w like Code does.
+func Write(w io.Writer, language, source string) error {
+	var lexer chroma.Lexer
+	if language != "" {
+		lexer = lexers.Get(language)
+	}
+	if lexer == nil {
+		_, err := io.WriteString(w, html.EscapeString(source))
+		return err
+	}
+
+	tokens, err := chroma.Coalesce(lexer).Tokenise(nil, source)
+	if err != nil {
+		_, err := io.WriteString(w, html.EscapeString(source))
+		return err
+	}
+
+	for token := tokens(); token != chroma.EOF; token = tokens() {
+		text := html.EscapeString(token.Value)
+
+		class := tokenClass(token.Type)
+		if class == "" {
+			_, err = io.WriteString(w, text)
+		} else {
+			_, err = io.WriteString(w, `<span class="`+ClassPrefix+class+`">`+text+"</span>")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tokenClass returns the class for tokens of a type, without ClassPrefix,
+// or "" for plain text and whitespace, which are left as they are.
+func tokenClass(tokenType chroma.TokenType) string {
+	if tokenType == chroma.Whitespace {
+		return ""
+	}
+
+	for _, t := range []chroma.TokenType{tokenType, tokenType.SubCategory(), tokenType.Category()} {
+		if class := chroma.StandardTypes[t]; class != "" {
+			return class
+		}
+	}
+
+	return ""
+}
+
+// CSS writes the stylesheet for the token classes. The site's own styles
+// set the background and layout of code, so only the token colours are
+// taken from the style.
+func CSS(w io.Writer) error {
+	style := styles.Get("github")
+	background := style.Get(chroma.Background)
+
+	_, err := io.WriteString(w, "/* Written by highlight.CSS in internal/highlight; don't edit by hand. */\n\n")
+	if err != nil {
+		return err
+	}
+
+	tokenTypes := slices.Sorted(maps.Keys(chroma.StandardTypes))
+
+	for _, tokenType := range tokenTypes {
+		class := chroma.StandardTypes[tokenType]
+		if class == "" || tokenType < 0 || tokenType == chroma.Whitespace {
+			continue
+		}
+
+		css := chromahtml.StyleEntryToCSS(style.Get(tokenType).Sub(background))
+		if css == "" {
+			continue
+		}
+
+		_, err = fmt.Fprintf(w, ".%s%s { %s }\n", ClassPrefix, class, css)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
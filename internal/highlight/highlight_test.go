@@ -0,0 +1,62 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+	"snippetbox.jonnevuorela.com/ui"
+)
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		language string
+		source   string
+		want     string
+	}{
+		{
+			name:     "Bash",
+			language: "bash",
+			source:   "echo splash",
+			want:     `<span class="hl-nb">echo</span> splash`,
+		},
+		{
+			name:     "Escaped tokens",
+			language: "go",
+			source:   `s := "<a&b>"`,
+			want:     `<span class="hl-nx">s</span> <span class="hl-o">:=</span> <span class="hl-s">&#34;&lt;a&amp;b&gt;&#34;</span>`,
+		},
+		{
+			name:     "Plain text",
+			language: "",
+			source:   "echo <splash>",
+			want:     "echo &lt;splash&gt;",
+		},
+		{
+			name:     "Unknown language",
+			language: "frog",
+			source:   "ribbit & <croak>",
+			want:     "ribbit &amp; &lt;croak&gt;",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, string(Code(tt.language, tt.source)), tt.want)
+		})
+	}
+}
+
+// The stylesheet is served from ui/static, so it has to be rewritten when the
+// style or the classes change.
+func TestCSS(t *testing.T) {
+	var want strings.Builder
+	err := CSS(&want)
+	assert.NilError(t, err)
+
+	got, err := ui.Files.ReadFile("static/css/highlight.css")
+	assert.NilError(t, err)
+
+	assert.Equal(t, string(got), want.String())
+}
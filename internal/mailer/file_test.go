@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+)
+
+func TestFileMailerSend(t *testing.T) {
+	dir := t.TempDir()
+
+	m := &FileMailer{Dir: dir, From: "Snippetbox <no-reply@example.com>"}
+
+	err := m.Send(context.Background(), Message{
+		To:      "alice@example.com",
+		Subject: "Hello",
+		Body:    "Hi Alice",
+	})
+	assert.NilError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	assert.NilError(t, err)
+	assert.Equal(t, len(files), 1)
+
+	b, err := os.ReadFile(files[0])
+	assert.NilError(t, err)
+
+	assert.StringContains(t, string(b), "From: Snippetbox <no-reply@example.com>\r\n")
+	assert.StringContains(t, string(b), "To: alice@example.com\r\n")
+	assert.StringContains(t, string(b), "Subject: Hello\r\n")
+	assert.StringContains(t, string(b), "\r\n\r\nHi Alice")
+}
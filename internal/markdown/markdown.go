@@ -0,0 +1,137 @@
+// Package markdown renders user-written Markdown to HTML that is safe to put
+// in a page. Raw HTML in the source is dropped, and the output is passed
+// through an allowlist sanitiser so that it can't carry scripts, event
+// handlers or inline styles, none of which the Content-Security-Policy would
+// let run anyway.
+package markdown
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+
+	"snippetbox.jonnevuorela.com/internal/highlight"
+)
+
+// comments renders the subset of Markdown allowed in comments. Autolinks
+// are on so that pasted URLs become links.
+var comments = goldmark.New(
+	goldmark.WithExtensions(extension.Linkify, extension.Strikethrough),
+)
+
+// commentPolicy allows inline formatting, code, quotes, lists and links.
+// Headings, images and tables are reduced to their text.
+var commentPolicy = func() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+
+	p.AllowElements("p", "br", "em", "strong", "del", "code", "pre", "blockquote", "ul", "ol", "li")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.RequireNoReferrerOnLinks(true)
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+-]+$`)).OnElements("code")
+
+	return p
+}()
+
+// documents renders Markdown snippets: CommonMark plus tables. Table cell
+// alignment is written as align attributes, since the inline styles goldmark
+// would otherwise use are blocked by the Content-Security-Policy. Fenced code
+// blocks are highlighted by fencedCodeRenderer.
+var documents = goldmark.New(
+	goldmark.WithExtensions(
+		extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+	),
+	goldmark.WithRendererOptions(
+		renderer.WithNodeRenderers(util.Prioritized(fencedCodeRenderer{}, 100)),
+	),
+)
+
+// fencedCodeRenderer writes fenced code blocks through the highlighter used
+// for snippet files, with the language taken from the fence's info string.
+type fencedCodeRenderer struct{}
+
+func (r fencedCodeRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.render)
+}
+
+func (r fencedCodeRenderer) render(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	block := node.(*ast.FencedCodeBlock)
+	language := string(block.Language(source))
+
+	var code strings.Builder
+	lines := block.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		code.Write(line.Value(source))
+	}
+
+	_, _ = w.WriteString("<pre><code")
+	if language != "" {
+		_, _ = w.WriteString(` class="language-` + html.EscapeString(language) + `"`)
+	}
+	_, _ = w.WriteString(">")
+	err := highlight.Write(w, language, code.String())
+	if err != nil {
+		return ast.WalkStop, err
+	}
+	_, _ = w.WriteString("</code></pre>\n")
+
+	return ast.WalkSkipChildren, nil
+}
+
+// documentPolicy allows what commentPolicy does, plus headings, rules and
+// tables. Images are still dropped: the Content-Security-Policy only lets
+// pages load images from this site, so links to them elsewhere would break.
+var documentPolicy = func() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+
+	p.AllowElements("p", "br", "em", "strong", "del", "code", "pre", "blockquote", "ul", "ol", "li")
+	p.AllowElements("h1", "h2", "h3", "h4", "h5", "h6", "hr")
+	p.AllowElements("table", "thead", "tbody", "tr", "th", "td")
+	p.AllowAttrs("align").Matching(regexp.MustCompile(`^(left|center|right)$`)).OnElements("th", "td")
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.RequireNoReferrerOnLinks(true)
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+-]+$`)).OnElements("code")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^hl-[a-z0-9]+$`)).OnElements("span")
+
+	return p
+}()
+
+// Comment renders a comment's restricted Markdown.
+func Comment(src string) template.HTML {
+	var buf bytes.Buffer
+
+	// goldmark only fails if writing to buf does, which it can't.
+	_ = comments.Convert([]byte(src), &buf)
+
+	return template.HTML(commentPolicy.SanitizeBytes(buf.Bytes()))
+}
+
+// Document renders a Markdown snippet. Fenced code blocks are highlighted
+// the same way as snippet files.
+func Document(src string) template.HTML {
+	var buf bytes.Buffer
+
+	_ = documents.Convert([]byte(src), &buf)
+
+	return template.HTML(documentPolicy.SanitizeBytes(buf.Bytes()))
+}
@@ -0,0 +1,117 @@
+package markdown
+
+import (
+	"testing"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+)
+
+func TestComment(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "Emphasis",
+			src:  "*looks* **good**",
+			want: "<p><em>looks</em> <strong>good</strong></p>\n",
+		},
+		{
+			name: "Code",
+			src:  "```go\nfmt.Println(\"<hi>\")\n```",
+			want: "<pre><code class=\"language-go\">fmt.Println(&#34;&lt;hi&gt;&#34;)\n</code></pre>\n",
+		},
+		{
+			name: "Link",
+			src:  "[docs](https://go.dev/doc)",
+			want: "<p><a href=\"https://go.dev/doc\" rel=\"nofollow noreferrer\">docs</a></p>\n",
+		},
+		{
+			name: "Autolink",
+			src:  "see https://go.dev",
+			want: "<p>see <a href=\"https://go.dev\" rel=\"nofollow noreferrer\">https://go.dev</a></p>\n",
+		},
+		{
+			name: "Script link",
+			src:  "[click](javascript:alert(1))",
+			want: "<p>click</p>\n",
+		},
+		{
+			name: "Raw HTML",
+			src:  "<script>alert(1)</script><b onclick='x()'>hi</b>",
+			want: "\n",
+		},
+		{
+			name: "Heading",
+			src:  "# Big",
+			want: "Big\n",
+		},
+		{
+			name: "Image",
+			src:  "![alt](https://example.com/x.png)",
+			want: "<p></p>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, string(Comment(tt.src)), tt.want)
+		})
+	}
+}
+
+func TestDocument(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "Heading",
+			src:  "# Big\n\n---",
+			want: "<h1>Big</h1>\n<hr>\n",
+		},
+		{
+			name: "Table",
+			src:  "| a | b |\n|:--|--:|\n| 1 | 2 |",
+			want: "<table>\n<thead>\n<tr>\n<th align=\"left\">a</th>\n<th align=\"right\">b</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td align=\"left\">1</td>\n<td align=\"right\">2</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		{
+			name: "Code",
+			src:  "```go\nfmt.Println(\"<hi>\")\n```",
+			want: "<pre><code class=\"language-go\"><span class=\"hl-nx\">fmt</span><span class=\"hl-p\">.</span><span class=\"hl-nf\">Println</span><span class=\"hl-p\">(</span><span class=\"hl-s\">&#34;&lt;hi&gt;&#34;</span><span class=\"hl-p\">)</span>\n</code></pre>\n",
+		},
+		{
+			name: "Code without a language",
+			src:  "```\n<b>plain</b>\n```",
+			want: "<pre><code>&lt;b&gt;plain&lt;/b&gt;\n</code></pre>\n",
+		},
+		{
+			name: "Ordered list",
+			src:  "3. three\n4. four",
+			want: "<ol start=\"3\">\n<li>three</li>\n<li>four</li>\n</ol>\n",
+		},
+		{
+			name: "Raw HTML",
+			src:  "<script>alert(1)</script><p style='color: red' onclick='x()'>hi</p>",
+			want: "\n",
+		},
+		{
+			name: "Script link",
+			src:  "[click](javascript:alert(1))",
+			want: "<p>click</p>\n",
+		},
+		{
+			name: "Image",
+			src:  "![alt](https://example.com/x.png)",
+			want: "<p></p>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, string(Document(tt.src)), tt.want)
+		})
+	}
+}
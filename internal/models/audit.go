@@ -0,0 +1,178 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Audit actions. An action names what happened, not whether it was allowed,
+// so a refused attempt has its own action.
+const (
+	AuditLogin            = "login"
+	AuditLoginFailed      = "login_failed"
+	AuditLogout           = "logout"
+	AuditSignup           = "signup"
+	AuditPasswordChange   = "password_change"
+	AuditEmailChange      = "email_change"
+	AuditTokenCreate      = "token_create"
+	AuditSnippetDelete    = "snippet_delete"
+	AuditUserDisable      = "user_disable"
+	AuditUserEnable       = "user_enable"
+	AuditUserRoleChange   = "user_role_change"
+	AuditTwoFactorEnable  = "two_factor_enable"
+	AuditTwoFactorDisable = "two_factor_disable"
+)
+
+var AuditActions = []string{
+	AuditLogin,
+	AuditLoginFailed,
+	AuditLogout,
+	AuditSignup,
+	AuditPasswordChange,
+	AuditEmailChange,
+	AuditTokenCreate,
+	AuditSnippetDelete,
+	AuditUserDisable,
+	AuditUserEnable,
+	AuditUserRoleChange,
+	AuditTwoFactorEnable,
+	AuditTwoFactorDisable,
+}
+
+// AuditEvent records a security-relevant event. ActorId is the user who
+// caused it, or 0 if nobody was logged in, and ActorEmail is their current
+// email address. Details holds whatever else is worth knowing about the
+// event, such as the email address a failed login was for.
+type AuditEvent struct {
+	Id         int               `json:"id"`
+	Action     string            `json:"action"`
+	ActorId    int               `json:"actor_id"`
+	ActorEmail string            `json:"actor_email"`
+	IP         string            `json:"ip"`
+	UserAgent  string            `json:"user_agent"`
+	Details    map[string]string `json:"details"`
+	Created    time.Time         `json:"created"`
+}
+
+// AuditFilter narrows down the events returned by List. Zero fields match
+// everything.
+type AuditFilter struct {
+	Action  string
+	ActorId int
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+}
+
+// AuditModel stores the audit log. Events can only be added, never changed
+// or removed, so there are no methods to do either.
+type AuditModel struct {
+	DB *sql.DB
+}
+
+type AuditModelInterface interface {
+	Record(ctx context.Context, event *AuditEvent) error
+	List(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error)
+}
+
+func (m *AuditModel) Record(ctx context.Context, event *AuditEvent) error {
+	stmt := `INSERT INTO audit_events (action, actor_id, ip, user_agent, details, created)
+   VALUES(?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	ctx, span := startSpan(ctx, "AuditModel.Record", stmt)
+	defer span.End()
+
+	details := event.Details
+	if details == nil {
+		details = map[string]string{}
+	}
+
+	js, err := json.Marshal(details)
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	userAgent := truncateChars(event.UserAgent, userAgentMaxChars)
+
+	var actorId sql.NullInt64
+	if event.ActorId > 0 {
+		actorId = sql.NullInt64{Int64: int64(event.ActorId), Valid: true}
+	}
+
+	_, err = m.DB.ExecContext(ctx, stmt, event.Action, actorId, event.IP, userAgent, js)
+	if err != nil {
+		return spanError(span, err)
+	}
+	return nil
+}
+
+// List returns the events that match the filter, newest first.
+func (m *AuditModel) List(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error) {
+	var where []string
+	var args []any
+
+	if filter.Action != "" {
+		where = append(where, "a.action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.ActorId > 0 {
+		where = append(where, "a.actor_id = ?")
+		args = append(args, filter.ActorId)
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, "a.created >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, "a.created < ?")
+		args = append(args, filter.Until.UTC())
+	}
+
+	stmt := `SELECT a.id, a.action, COALESCE(a.actor_id, 0), COALESCE(u.email, ''), a.ip, a.user_agent, a.details, a.created
+   FROM audit_events a LEFT JOIN users u ON u.id = a.actor_id`
+	if len(where) > 0 {
+		stmt += "\n   WHERE " + strings.Join(where, " AND ")
+	}
+	stmt += "\n   ORDER BY a.id DESC"
+	if filter.Limit > 0 {
+		stmt += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	ctx, span := startSpan(ctx, "AuditModel.List", stmt)
+	defer span.End()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		e := &AuditEvent{}
+		var details []byte
+
+		err = rows.Scan(&e.Id, &e.Action, &e.ActorId, &e.ActorEmail, &e.IP, &e.UserAgent, &details, &e.Created)
+		if err != nil {
+			return nil, spanError(span, err)
+		}
+
+		err = json.Unmarshal(details, &e.Details)
+		if err != nil {
+			return nil, spanError(span, err)
+		}
+
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, spanError(span, err)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+)
+
+func TestAuditModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	m := AuditModel{db}
+	ctx := context.Background()
+
+	events := []*AuditEvent{
+		{Action: AuditLoginFailed, IP: "192.0.2.1", UserAgent: "test", Details: map[string]string{"email": "alice@example.com"}},
+		{Action: AuditLogin, ActorId: 1, IP: "192.0.2.1", UserAgent: "test"},
+		{Action: AuditLogout, ActorId: 1, IP: "192.0.2.1", UserAgent: strings.Repeat("é", 300)},
+	}
+
+	for _, e := range events {
+		err := m.Record(ctx, e)
+		assert.NilError(t, err)
+	}
+
+	tests := []struct {
+		name        string
+		filter      AuditFilter
+		wantActions []string
+	}{
+		{
+			name:        "All",
+			wantActions: []string{AuditLogout, AuditLogin, AuditLoginFailed},
+		},
+		{
+			name:        "Action",
+			filter:      AuditFilter{Action: AuditLogin},
+			wantActions: []string{AuditLogin},
+		},
+		{
+			name:        "Actor",
+			filter:      AuditFilter{ActorId: 1, Limit: 1},
+			wantActions: []string{AuditLogout},
+		},
+		{
+			name:        "Until",
+			filter:      AuditFilter{Until: time.Now().Add(-time.Hour)},
+			wantActions: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := m.List(ctx, tt.filter)
+			assert.NilError(t, err)
+
+			actions := []string{}
+			for _, e := range events {
+				actions = append(actions, e.Action)
+			}
+
+			assert.Equal(t, len(actions), len(tt.wantActions))
+			for i := range actions {
+				assert.Equal(t, actions[i], tt.wantActions[i])
+			}
+		})
+	}
+
+	events, err := m.List(ctx, AuditFilter{Action: AuditLoginFailed})
+	assert.NilError(t, err)
+	assert.Equal(t, events[0].Details["email"], "alice@example.com")
+	assert.Equal(t, events[0].ActorId, 0)
+
+	events, err = m.List(ctx, AuditFilter{Action: AuditLogin})
+	assert.NilError(t, err)
+	assert.Equal(t, events[0].ActorEmail, "alice@example.com")
+}
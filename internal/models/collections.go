@@ -0,0 +1,264 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Collection is a named, ordered set of snippets put together by a user. A
+// snippet can be in any number of collections. Slug is the collection's
+// address, /c/slug, and never changes.
+type Collection struct {
+	Id          int
+	UserId      int
+	Slug        string
+	Title       string
+	Description string
+	Public      bool
+	Created     time.Time
+}
+
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+type CollectionModelInterface interface {
+	Insert(ctx context.Context, userId int, slug, title, description string, public bool) (int, error)
+	GetBySlug(ctx context.Context, slug string) (*Collection, error)
+	ByOwner(ctx context.Context, userId int) ([]*Collection, error)
+	Update(ctx context.Context, id int, title, description string, public bool) error
+	Delete(ctx context.Context, id int) error
+	Snippets(ctx context.Context, id int) ([]*Snippet, error)
+	AddSnippet(ctx context.Context, id, snippetId int) error
+	RemoveSnippet(ctx context.Context, id, snippetId int) error
+	MoveSnippet(ctx context.Context, id, snippetId, userId int, up bool) error
+}
+
+// Insert adds a collection. It returns ErrDuplicateSlug if another
+// collection already has the slug.
+func (m *CollectionModel) Insert(ctx context.Context, userId int, slug, title, description string, public bool) (int, error) {
+	stmt := `INSERT INTO collections (user_id, slug, title, description, public, created)
+   VALUES(?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	ctx, span := startSpan(ctx, "CollectionModel.Insert", stmt)
+	defer span.End()
+
+	result, err := m.DB.ExecContext(ctx, stmt, userId, slug, title, description, public)
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) && mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "collections_uc_slug") {
+			return 0, ErrDuplicateSlug
+		}
+		return 0, spanError(span, err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, spanError(span, err)
+	}
+
+	return int(id), nil
+}
+
+func (m *CollectionModel) GetBySlug(ctx context.Context, slug string) (*Collection, error) {
+	stmt := `SELECT id, user_id, slug, title, description, public, created FROM collections
+   WHERE slug = ?`
+
+	ctx, span := startSpan(ctx, "CollectionModel.GetBySlug", stmt)
+	defer span.End()
+
+	c := &Collection{}
+
+	err := m.DB.QueryRowContext(ctx, stmt, slug).Scan(&c.Id, &c.UserId, &c.Slug, &c.Title, &c.Description, &c.Public, &c.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, spanError(span, err)
+	}
+
+	return c, nil
+}
+
+// ByOwner returns every collection the user has made, public or private, by
+// title.
+func (m *CollectionModel) ByOwner(ctx context.Context, userId int) ([]*Collection, error) {
+	stmt := `SELECT id, user_id, slug, title, description, public, created FROM collections
+   WHERE user_id = ? ORDER BY title, id`
+
+	ctx, span := startSpan(ctx, "CollectionModel.ByOwner", stmt)
+	defer span.End()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userId)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+	defer rows.Close()
+
+	collections := []*Collection{}
+
+	for rows.Next() {
+		c := &Collection{}
+		err = rows.Scan(&c.Id, &c.UserId, &c.Slug, &c.Title, &c.Description, &c.Public, &c.Created)
+		if err != nil {
+			return nil, spanError(span, err)
+		}
+		collections = append(collections, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, spanError(span, err)
+	}
+
+	return collections, nil
+}
+
+func (m *CollectionModel) Update(ctx context.Context, id int, title, description string, public bool) error {
+	stmt := "UPDATE collections SET title = ?, description = ?, public = ? WHERE id = ?"
+
+	ctx, span := startSpan(ctx, "CollectionModel.Update", stmt)
+	defer span.End()
+
+	_, err := m.DB.ExecContext(ctx, stmt, title, description, public, id)
+	if err != nil {
+		return spanError(span, err)
+	}
+	return nil
+}
+
+// Delete deletes a collection. The snippets in it are left alone.
+func (m *CollectionModel) Delete(ctx context.Context, id int) error {
+	stmt := "DELETE FROM collections WHERE id = ?"
+
+	ctx, span := startSpan(ctx, "CollectionModel.Delete", stmt)
+	defer span.End()
+
+	_, err := m.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return spanError(span, err)
+	}
+	return nil
+}
+
+// Snippets returns the unexpired snippets in the collection, in order. Private
+// snippets are included, so callers must check who can see them.
+func (m *CollectionModel) Snippets(ctx context.Context, id int) ([]*Snippet, error) {
+	stmt := "SELECT " + snippetColumns + `
+   FROM collection_snippets cs JOIN snippets s ON s.id = cs.snippet_id
+   WHERE cs.collection_id = ? AND s.expires > UTC_TIMESTAMP()
+   ORDER BY cs.position`
+
+	return querySnippets(ctx, m.DB, "CollectionModel.Snippets", stmt, id)
+}
+
+// AddSnippet puts the snippet at the end of the collection. Adding a snippet
+// that is already in the collection does nothing.
+func (m *CollectionModel) AddSnippet(ctx context.Context, id, snippetId int) error {
+	stmt := `INSERT IGNORE INTO collection_snippets (collection_id, snippet_id, position)
+   SELECT ?, ?, COALESCE(MAX(position), 0) + 1 FROM collection_snippets WHERE collection_id = ?`
+
+	ctx, span := startSpan(ctx, "CollectionModel.AddSnippet", stmt)
+	defer span.End()
+
+	_, err := m.DB.ExecContext(ctx, stmt, id, snippetId, id)
+	if err != nil {
+		return spanError(span, err)
+	}
+	return nil
+}
+
+// RemoveSnippet takes the snippet out of the collection. It returns
+// ErrNoRecord if the snippet wasn't in it.
+func (m *CollectionModel) RemoveSnippet(ctx context.Context, id, snippetId int) error {
+	stmt := "DELETE FROM collection_snippets WHERE collection_id = ? AND snippet_id = ?"
+
+	ctx, span := startSpan(ctx, "CollectionModel.RemoveSnippet", stmt)
+	defer span.End()
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, snippetId)
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// MoveSnippet swaps the snippet with the one before it in the collection, or
+// after it if up is false. Snippets the user can't see on the collection's
+// page, because they have expired or are someone else's private snippet, are
+// skipped over, so that the move always shows. Moving the first snippet up or
+// the last one down does nothing. It returns ErrNoRecord if the snippet isn't
+// in the collection.
+func (m *CollectionModel) MoveSnippet(ctx context.Context, id, snippetId, userId int, up bool) error {
+	ctx, span := tracer.Start(ctx, "CollectionModel.MoveSnippet")
+	defer span.End()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return spanError(span, err)
+	}
+	defer tx.Rollback()
+
+	var position int
+
+	stmt := "SELECT position FROM collection_snippets WHERE collection_id = ? AND snippet_id = ? FOR UPDATE"
+
+	err = tx.QueryRowContext(ctx, stmt, id, snippetId).Scan(&position)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return spanError(span, err)
+	}
+
+	stmt = `SELECT cs.snippet_id, cs.position
+   FROM collection_snippets cs JOIN snippets s ON s.id = cs.snippet_id
+   WHERE cs.collection_id = ? AND s.expires > UTC_TIMESTAMP() AND (s.public OR s.user_id = ?)`
+	if up {
+		stmt += " AND cs.position < ? ORDER BY cs.position DESC LIMIT 1 FOR UPDATE"
+	} else {
+		stmt += " AND cs.position > ? ORDER BY cs.position LIMIT 1 FOR UPDATE"
+	}
+
+	var otherId, otherPosition int
+
+	err = tx.QueryRowContext(ctx, stmt, id, userId, position).Scan(&otherId, &otherPosition)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return spanError(span, err)
+	}
+
+	stmt = "UPDATE collection_snippets SET position = ? WHERE collection_id = ? AND snippet_id = ?"
+
+	_, err = tx.ExecContext(ctx, stmt, otherPosition, id, snippetId)
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	_, err = tx.ExecContext(ctx, stmt, position, id, otherId)
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	return nil
+}
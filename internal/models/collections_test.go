@@ -0,0 +1,100 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+)
+
+func TestCollectionModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	snippets := SnippetModel{db}
+	m := CollectionModel{db}
+	ctx := context.Background()
+
+	id, err := m.Insert(ctx, 1, "runbooks", "Runbooks", "", true)
+	assert.NilError(t, err)
+
+	_, err = m.Insert(ctx, 1, "runbooks", "More runbooks", "", true)
+	assert.Equal(t, errors.Is(err, ErrDuplicateSlug), true)
+
+	var ids []int
+	for _, title := range []string{"First", "Second", "Third"} {
+		snippetId, err := snippets.Insert(ctx, 1, title, textFile("content"), nil, 7, true, 0)
+		assert.NilError(t, err)
+		ids = append(ids, snippetId)
+
+		err = m.AddSnippet(ctx, id, snippetId)
+		assert.NilError(t, err)
+	}
+
+	// Adding a snippet twice leaves it where it was.
+	err = m.AddSnippet(ctx, id, ids[0])
+	assert.NilError(t, err)
+
+	err = m.MoveSnippet(ctx, id, ids[2], 1, true)
+	assert.NilError(t, err)
+	err = m.MoveSnippet(ctx, id, ids[0], 1, true)
+	assert.NilError(t, err)
+
+	s, err := m.Snippets(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, len(s), 3)
+	assert.Equal(t, s[0].Id, ids[0])
+	assert.Equal(t, s[1].Id, ids[2])
+	assert.Equal(t, s[2].Id, ids[1])
+
+	err = m.RemoveSnippet(ctx, id, ids[2])
+	assert.NilError(t, err)
+
+	err = m.MoveSnippet(ctx, id, ids[2], 1, false)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	// An expired snippet between two others is skipped over.
+	expired, err := snippets.Insert(ctx, 1, "Expired", textFile("content"), nil, 7, true, 0)
+	assert.NilError(t, err)
+	err = m.AddSnippet(ctx, id, expired)
+	assert.NilError(t, err)
+	err = m.AddSnippet(ctx, id, ids[2])
+	assert.NilError(t, err)
+	_, err = db.Exec("UPDATE snippets SET expires = DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 DAY) WHERE id = ?", expired)
+	assert.NilError(t, err)
+
+	err = m.MoveSnippet(ctx, id, ids[2], 1, true)
+	assert.NilError(t, err)
+
+	s, err = m.Snippets(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, len(s), 3)
+	assert.Equal(t, s[1].Id, ids[2])
+	assert.Equal(t, s[2].Id, ids[1])
+
+	c, err := m.GetBySlug(ctx, "runbooks")
+	assert.NilError(t, err)
+	assert.Equal(t, c.Id, id)
+
+	err = m.Update(ctx, id, "Renamed", "Now private", false)
+	assert.NilError(t, err)
+
+	collections, err := m.ByOwner(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(collections), 1)
+	assert.Equal(t, collections[0].Title, "Renamed")
+	assert.Equal(t, collections[0].Public, false)
+
+	err = m.Delete(ctx, id)
+	assert.NilError(t, err)
+
+	_, err = m.GetBySlug(ctx, "runbooks")
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	_, err = snippets.Get(ctx, ids[0])
+	assert.NilError(t, err)
+}
@@ -0,0 +1,251 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Comment is a comment on a snippet, or a reply to another comment when
+// ParentId is set. A deleted comment that still has replies is kept, without
+// its body, so that the thread holds together.
+type Comment struct {
+	Id           int
+	SnippetId    int
+	ParentId     int
+	UserId       int
+	AuthorName   string
+	AuthorHandle string
+	Body         string
+	Created      time.Time
+	Edited       *time.Time
+	Deleted      bool
+	Replies      []*Comment
+}
+
+type CommentModel struct {
+	DB *sql.DB
+}
+
+type CommentModelInterface interface {
+	Insert(ctx context.Context, snippetId, parentId, userId int, body string) (int, error)
+	Get(ctx context.Context, id int) (*Comment, error)
+	Thread(ctx context.Context, snippetId int) ([]*Comment, error)
+	Counts(ctx context.Context, snippetIds []int) (map[int]int, error)
+	Update(ctx context.Context, id, userId int, body string) error
+	Delete(ctx context.Context, id, userId int) error
+}
+
+// Insert adds a comment. parentId is the comment being replied to, or 0.
+func (m *CommentModel) Insert(ctx context.Context, snippetId, parentId, userId int, body string) (int, error) {
+	stmt := `INSERT INTO comments (snippet_id, parent_id, user_id, body, created)
+   VALUES(?, NULLIF(?, 0), ?, ?, UTC_TIMESTAMP())`
+
+	ctx, span := startSpan(ctx, "CommentModel.Insert", stmt)
+	defer span.End()
+
+	result, err := m.DB.ExecContext(ctx, stmt, snippetId, parentId, userId, body)
+	if err != nil {
+		return 0, spanError(span, err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, spanError(span, err)
+	}
+
+	return int(id), nil
+}
+
+// Get returns a comment that hasn't been deleted, or ErrNoRecord.
+func (m *CommentModel) Get(ctx context.Context, id int) (*Comment, error) {
+	stmt := `SELECT c.id, c.snippet_id, COALESCE(c.parent_id, 0), c.user_id, u.name, u.handle, c.body, c.created, c.edited
+   FROM comments c JOIN users u ON u.id = c.user_id
+   WHERE c.id = ? AND c.deleted_at IS NULL`
+
+	ctx, span := startSpan(ctx, "CommentModel.Get", stmt)
+	defer span.End()
+
+	c := &Comment{}
+
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&c.Id, &c.SnippetId, &c.ParentId, &c.UserId, &c.AuthorName, &c.AuthorHandle, &c.Body, &c.Created, &c.Edited)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, spanError(span, err)
+	}
+
+	return c, nil
+}
+
+// Thread returns the snippet's comments as a tree: the top-level comments,
+// oldest first, each with its replies.
+func (m *CommentModel) Thread(ctx context.Context, snippetId int) ([]*Comment, error) {
+	stmt := `SELECT c.id, c.snippet_id, COALESCE(c.parent_id, 0), c.user_id, u.name, u.handle, c.body, c.created, c.edited, c.deleted_at IS NOT NULL
+   FROM comments c JOIN users u ON u.id = c.user_id
+   WHERE c.snippet_id = ? ORDER BY c.id`
+
+	ctx, span := startSpan(ctx, "CommentModel.Thread", stmt)
+	defer span.End()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, snippetId)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+	defer rows.Close()
+
+	comments := []*Comment{}
+
+	for rows.Next() {
+		c := &Comment{}
+
+		err = rows.Scan(&c.Id, &c.SnippetId, &c.ParentId, &c.UserId, &c.AuthorName, &c.AuthorHandle, &c.Body, &c.Created, &c.Edited, &c.Deleted)
+		if err != nil {
+			return nil, spanError(span, err)
+		}
+
+		comments = append(comments, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, spanError(span, err)
+	}
+
+	return BuildThread(comments), nil
+}
+
+// BuildThread arranges comments, sorted oldest first, into a tree. Deleted
+// comments are left out unless they have replies.
+func BuildThread(comments []*Comment) []*Comment {
+	byId := make(map[int]*Comment, len(comments))
+	for _, c := range comments {
+		c.Replies = nil
+		byId[c.Id] = c
+	}
+
+	roots := []*Comment{}
+
+	for _, c := range comments {
+		if parent, ok := byId[c.ParentId]; ok {
+			parent.Replies = append(parent.Replies, c)
+		} else {
+			roots = append(roots, c)
+		}
+	}
+
+	return prune(roots)
+}
+
+func prune(comments []*Comment) []*Comment {
+	kept := comments[:0]
+	for _, c := range comments {
+		c.Replies = prune(c.Replies)
+		if c.Deleted {
+			if len(c.Replies) == 0 {
+				continue
+			}
+			c.Body = ""
+		}
+		kept = append(kept, c)
+	}
+	return kept
+}
+
+// Counts returns how many comments, not counting deleted ones, each of the
+// snippets has. Snippets without comments are left out of the map.
+func (m *CommentModel) Counts(ctx context.Context, snippetIds []int) (map[int]int, error) {
+	counts := map[int]int{}
+
+	if len(snippetIds) == 0 {
+		return counts, nil
+	}
+
+	stmt := `SELECT snippet_id, COUNT(*) FROM comments
+   WHERE deleted_at IS NULL AND snippet_id IN (?` + strings.Repeat(", ?", len(snippetIds)-1) + `)
+   GROUP BY snippet_id`
+
+	ctx, span := startSpan(ctx, "CommentModel.Counts", stmt)
+	defer span.End()
+
+	args := make([]any, len(snippetIds))
+	for i, id := range snippetIds {
+		args[i] = id
+	}
+
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, n int
+
+		err = rows.Scan(&id, &n)
+		if err != nil {
+			return nil, spanError(span, err)
+		}
+
+		counts[id] = n
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, spanError(span, err)
+	}
+
+	return counts, nil
+}
+
+// Update changes the body of the user's comment. It returns ErrNoRecord if
+// the comment doesn't exist, has been deleted or isn't theirs.
+func (m *CommentModel) Update(ctx context.Context, id, userId int, body string) error {
+	stmt := `UPDATE comments SET body = ?, edited = UTC_TIMESTAMP()
+   WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
+
+	ctx, span := startSpan(ctx, "CommentModel.Update", stmt)
+	defer span.End()
+
+	result, err := m.DB.ExecContext(ctx, stmt, body, id, userId)
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// Delete deletes the user's comment. The row is kept, with its body cleared,
+// so that replies to it still have a parent. It returns ErrNoRecord if the
+// comment doesn't exist, has been deleted or isn't theirs.
+func (m *CommentModel) Delete(ctx context.Context, id, userId int) error {
+	stmt := `UPDATE comments SET body = '', deleted_at = UTC_TIMESTAMP()
+   WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
+
+	ctx, span := startSpan(ctx, "CommentModel.Delete", stmt)
+	defer span.End()
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, userId)
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
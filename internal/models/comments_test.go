@@ -0,0 +1,80 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+)
+
+func TestBuildThread(t *testing.T) {
+	comments := []*Comment{
+		{Id: 1},
+		{Id: 2, ParentId: 1},
+		{Id: 3, Deleted: true},
+		{Id: 4, Deleted: true, Body: "gone"},
+		{Id: 5, ParentId: 4},
+		{Id: 6, ParentId: 2},
+	}
+
+	thread := BuildThread(comments)
+
+	assert.Equal(t, len(thread), 2)
+	assert.Equal(t, thread[0].Id, 1)
+	assert.Equal(t, thread[0].Replies[0].Id, 2)
+	assert.Equal(t, thread[0].Replies[0].Replies[0].Id, 6)
+	assert.Equal(t, thread[1].Id, 4)
+	assert.Equal(t, thread[1].Body, "")
+	assert.Equal(t, thread[1].Replies[0].Id, 5)
+}
+
+func TestCommentModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	snippets := SnippetModel{db}
+	m := CommentModel{db}
+	ctx := context.Background()
+
+	snippetId, err := snippets.Insert(ctx, 1, "Title", textFile("content"), nil, 7, true, 0)
+	assert.NilError(t, err)
+
+	first, err := m.Insert(ctx, snippetId, 0, 1, "First")
+	assert.NilError(t, err)
+	reply, err := m.Insert(ctx, snippetId, first, 1, "Reply")
+	assert.NilError(t, err)
+
+	err = m.Update(ctx, reply, 2, "Not mine")
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	err = m.Update(ctx, reply, 1, "Edited")
+	assert.NilError(t, err)
+
+	c, err := m.Get(ctx, reply)
+	assert.NilError(t, err)
+	assert.Equal(t, c.Body, "Edited")
+	assert.Equal(t, c.ParentId, first)
+	assert.Equal(t, c.AuthorHandle, "alice")
+	assert.Equal(t, c.Edited != nil, true)
+
+	err = m.Delete(ctx, first, 1)
+	assert.NilError(t, err)
+
+	_, err = m.Get(ctx, first)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	thread, err := m.Thread(ctx, snippetId)
+	assert.NilError(t, err)
+	assert.Equal(t, len(thread), 1)
+	assert.Equal(t, thread[0].Deleted, true)
+	assert.Equal(t, thread[0].Replies[0].Id, reply)
+
+	counts, err := m.Counts(ctx, []int{snippetId, snippetId + 1})
+	assert.NilError(t, err)
+	assert.Equal(t, counts[snippetId], 1)
+	assert.Equal(t, len(counts), 1)
+}
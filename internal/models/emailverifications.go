@@ -0,0 +1,81 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type EmailVerificationModel struct {
+	DB *sql.DB
+}
+
+type EmailVerificationModelInterface interface {
+	New(ctx context.Context, userId int, email string, ttl time.Duration) (string, error)
+	Consume(ctx context.Context, token string) (int, string, error)
+}
+
+// New creates a token proving that the user owns email, valid for ttl. The
+// address is stored with the token so that a link sent before the user
+// changed their address can't verify the new one.
+func (m *EmailVerificationModel) New(ctx context.Context, userId int, email string, ttl time.Duration) (string, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO email_verifications (hash, user_id, email, created, expires)
+   VALUES(?, ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+
+	ctx, span := startSpan(ctx, "EmailVerificationModel.New", stmt)
+	defer span.End()
+
+	_, err = m.DB.ExecContext(ctx, stmt, hash, userId, email, int(ttl.Seconds()))
+	if err != nil {
+		return "", spanError(span, err)
+	}
+
+	return token, nil
+}
+
+// Consume checks that token is valid and returns the user and email address
+// it was issued for. Only that token is deleted: others the user has, such as
+// one sent to their current address, stay valid.
+func (m *EmailVerificationModel) Consume(ctx context.Context, token string) (int, string, error) {
+	ctx, span := tracer.Start(ctx, "EmailVerificationModel.Consume")
+	defer span.End()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, "", spanError(span, err)
+	}
+	defer tx.Rollback()
+
+	var userId int
+	var email string
+
+	stmt := "SELECT user_id, email FROM email_verifications WHERE hash = ? AND expires > UTC_TIMESTAMP() FOR UPDATE"
+
+	err = tx.QueryRowContext(ctx, stmt, hashToken(token)).Scan(&userId, &email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, "", ErrInvalidToken
+		}
+		return 0, "", spanError(span, err)
+	}
+
+	stmt = "DELETE FROM email_verifications WHERE hash = ?"
+
+	_, err = tx.ExecContext(ctx, stmt, hashToken(token))
+	if err != nil {
+		return 0, "", spanError(span, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, "", spanError(span, err)
+	}
+
+	return userId, email, nil
+}
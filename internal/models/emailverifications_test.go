@@ -0,0 +1,48 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+)
+
+func TestEmailVerificationModelConsume(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	m := EmailVerificationModel{db}
+	users := UserModel{db}
+	ctx := context.Background()
+
+	stale, err := m.New(ctx, 1, "alice.old@example.com", time.Hour)
+	assert.NilError(t, err)
+
+	current, err := m.New(ctx, 1, "alice@example.com", time.Hour)
+	assert.NilError(t, err)
+
+	// The link sent to the old address is used up, but verifies nothing and
+	// leaves the link sent to the current address alone.
+	userId, email, err := m.Consume(ctx, stale)
+	assert.NilError(t, err)
+	assert.Equal(t, userId, 1)
+	assert.Equal(t, email, "alice.old@example.com")
+
+	err = users.MarkEmailVerified(ctx, userId, email)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	_, _, err = m.Consume(ctx, stale)
+	assert.Equal(t, errors.Is(err, ErrInvalidToken), true)
+
+	userId, email, err = m.Consume(ctx, current)
+	assert.NilError(t, err)
+	assert.Equal(t, email, "alice@example.com")
+
+	err = users.MarkEmailVerified(ctx, userId, email)
+	assert.NilError(t, err)
+}
@@ -8,4 +8,8 @@ var (
 	ErrNoRecord           = errors.New("models: no matching record found")
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	ErrDuplicateEmail     = errors.New("models: duplicate email")
+	ErrDuplicateHandle    = errors.New("models: duplicate handle")
+	ErrDuplicateSlug      = errors.New("models: duplicate slug")
+	ErrInvalidToken       = errors.New("models: invalid or expired token")
+	ErrAccountDisabled    = errors.New("models: account disabled")
 )
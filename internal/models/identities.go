@@ -0,0 +1,55 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// IdentityModel links accounts at external identity providers to users.
+// An identity is the provider's issuer URL and the stable subject it
+// assigns to the account, which unlike the email address never changes.
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+type IdentityModelInterface interface {
+	Get(ctx context.Context, issuer, subject string) (int, error)
+	Link(ctx context.Context, userId int, issuer, subject string) error
+}
+
+// Get returns the user linked to the identity, or ErrNoRecord if there
+// isn't one.
+func (m *IdentityModel) Get(ctx context.Context, issuer, subject string) (int, error) {
+	stmt := "SELECT user_id FROM identities WHERE issuer = ? AND subject = ?"
+
+	ctx, span := startSpan(ctx, "IdentityModel.Get", stmt)
+	defer span.End()
+
+	var userId int
+
+	err := m.DB.QueryRowContext(ctx, stmt, issuer, subject).Scan(&userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, spanError(span, err)
+	}
+
+	return userId, nil
+}
+
+// Link links the identity to the user.
+func (m *IdentityModel) Link(ctx context.Context, userId int, issuer, subject string) error {
+	stmt := `INSERT INTO identities (issuer, subject, user_id, created)
+   VALUES(?, ?, ?, UTC_TIMESTAMP())`
+
+	ctx, span := startSpan(ctx, "IdentityModel.Link", stmt)
+	defer span.End()
+
+	_, err := m.DB.ExecContext(ctx, stmt, issuer, subject, userId)
+	if err != nil {
+		return spanError(span, err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+)
+
+func TestIdentityModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	m := IdentityModel{db}
+	ctx := context.Background()
+
+	err := m.Link(ctx, 1, "https://idp.example.com", "alice-subject")
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name       string
+		issuer     string
+		subject    string
+		wantUserId int
+		wantErr    error
+	}{
+		{
+			name:       "Linked identity",
+			issuer:     "https://idp.example.com",
+			subject:    "alice-subject",
+			wantUserId: 1,
+		},
+		{
+			name:    "Same subject at another issuer",
+			issuer:  "https://other.example.com",
+			subject: "alice-subject",
+			wantErr: ErrNoRecord,
+		},
+		{
+			name:    "Unknown subject",
+			issuer:  "https://idp.example.com",
+			subject: "bob-subject",
+			wantErr: ErrNoRecord,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userId, err := m.Get(ctx, tt.issuer, tt.subject)
+
+			assert.Equal(t, userId, tt.wantUserId)
+			assert.Equal(t, errors.Is(err, tt.wantErr), true)
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// throttlePolicy decides how long a subject has to wait after a number of
+// consecutive failed logins. The first few failures are free, then the delay
+// doubles with each failure, up to lockDuration, until the subject is locked
+// outright.
+type throttlePolicy struct {
+	scope        string
+	freeAttempts int
+	baseDelay    time.Duration
+	lockAfter    int
+	lockDuration time.Duration
+	window       time.Duration
+}
+
+var (
+	emailThrottle = throttlePolicy{
+		scope:        "email",
+		freeAttempts: 3,
+		baseDelay:    time.Second,
+		lockAfter:    10,
+		lockDuration: 15 * time.Minute,
+		window:       24 * time.Hour,
+	}
+	ipThrottle = throttlePolicy{
+		scope:        "ip",
+		freeAttempts: 10,
+		baseDelay:    time.Second,
+		lockAfter:    50,
+		lockDuration: time.Hour,
+		window:       24 * time.Hour,
+	}
+)
+
+func (p throttlePolicy) delay(failures int) (time.Duration, bool) {
+	switch {
+	case failures >= p.lockAfter:
+		return p.lockDuration, true
+	case failures < p.freeAttempts:
+		return 0, false
+	}
+
+	// Doubling stops at lockDuration, so a subject that isn't locked never
+	// waits longer than one that is, and the shift can't overflow.
+	d := p.baseDelay
+	for i := p.freeAttempts; i < failures && d < p.lockDuration; i++ {
+		d <<= 1
+	}
+	return min(d, p.lockDuration), false
+}
+
+// LoginBlock describes why a login attempt may not go ahead yet.
+type LoginBlock struct {
+	Until  time.Time
+	Locked bool
+}
+
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+type LoginAttemptModelInterface interface {
+	Check(ctx context.Context, email, ip string) (*LoginBlock, error)
+	RecordFailure(ctx context.Context, email, ip string) (*LoginBlock, error)
+	Reset(ctx context.Context, email string) error
+}
+
+// Check returns the block that applies to a login for email from ip, or nil
+// if the attempt may go ahead.
+func (m *LoginAttemptModel) Check(ctx context.Context, email, ip string) (*LoginBlock, error) {
+	stmt := `SELECT locked_until, locked FROM login_failures
+   WHERE ((scope = 'email' AND subject = ?) OR (scope = 'ip' AND subject = ?)) AND locked_until > UTC_TIMESTAMP()
+   ORDER BY locked DESC, locked_until DESC LIMIT 1`
+
+	ctx, span := startSpan(ctx, "LoginAttemptModel.Check", stmt)
+	defer span.End()
+
+	b := &LoginBlock{}
+
+	err := m.DB.QueryRowContext(ctx, stmt, email, ip).Scan(&b.Until, &b.Locked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, spanError(span, err)
+	}
+
+	return b, nil
+}
+
+// RecordFailure counts a failed login against both the email and the IP,
+// and returns the block that the email is now under, if any. A failure more
+// than a day after the previous one starts the count again.
+func (m *LoginAttemptModel) RecordFailure(ctx context.Context, email, ip string) (*LoginBlock, error) {
+	ctx, span := tracer.Start(ctx, "LoginAttemptModel.RecordFailure")
+	defer span.End()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	_, err = m.recordFailure(ctx, tx, ipThrottle, ip, now)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+
+	block, err := m.recordFailure(ctx, tx, emailThrottle, email, now)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+
+	return block, nil
+}
+
+func (m *LoginAttemptModel) recordFailure(ctx context.Context, tx *sql.Tx, p throttlePolicy, subject string, now time.Time) (*LoginBlock, error) {
+	stmt := `INSERT INTO login_failures (scope, subject, failures, last_failure, locked_until, locked)
+   VALUES(?, ?, 1, ?, ?, FALSE)
+   ON DUPLICATE KEY UPDATE
+   failures = IF(last_failure < ?, 1, failures + 1), last_failure = VALUES(last_failure)`
+
+	_, err := tx.ExecContext(ctx, stmt, p.scope, subject, now, now, now.Add(-p.window))
+	if err != nil {
+		return nil, err
+	}
+
+	var failures int
+
+	stmt = "SELECT failures FROM login_failures WHERE scope = ? AND subject = ?"
+
+	err = tx.QueryRowContext(ctx, stmt, p.scope, subject).Scan(&failures)
+	if err != nil {
+		return nil, err
+	}
+
+	delay, locked := p.delay(failures)
+	if delay == 0 {
+		return nil, nil
+	}
+
+	b := &LoginBlock{Until: now.Add(delay), Locked: locked}
+
+	stmt = "UPDATE login_failures SET locked_until = ?, locked = ? WHERE scope = ? AND subject = ?"
+
+	_, err = tx.ExecContext(ctx, stmt, b.Until, b.Locked, p.scope, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Reset clears the failures recorded against an email after a successful
+// login. Failures against the IP are kept, so that an attacker can't clear
+// them by logging in to an account of their own.
+func (m *LoginAttemptModel) Reset(ctx context.Context, email string) error {
+	stmt := "DELETE FROM login_failures WHERE scope = 'email' AND subject = ?"
+
+	ctx, span := startSpan(ctx, "LoginAttemptModel.Reset", stmt)
+	defer span.End()
+
+	_, err := m.DB.ExecContext(ctx, stmt, email)
+	if err != nil {
+		return spanError(span, err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+)
+
+func TestThrottlePolicyDelay(t *testing.T) {
+	// noLock never locks, to check that the delay stays capped however many
+	// failures there are.
+	noLock := ipThrottle
+	noLock.lockAfter = 1 << 30
+
+	tests := []struct {
+		name       string
+		policy     throttlePolicy
+		failures   int
+		wantDelay  time.Duration
+		wantLocked bool
+	}{
+		{"Email free", emailThrottle, 2, 0, false},
+		{"Email first delay", emailThrottle, 3, time.Second, false},
+		{"Email last delay", emailThrottle, 9, 64 * time.Second, false},
+		{"Email locked", emailThrottle, 10, 15 * time.Minute, true},
+		{"IP free", ipThrottle, 9, 0, false},
+		{"IP first delay", ipThrottle, 10, time.Second, false},
+		{"IP below cap", ipThrottle, 21, 2048 * time.Second, false},
+		{"IP capped", ipThrottle, 22, time.Hour, false},
+		{"IP capped later", ipThrottle, 44, time.Hour, false},
+		{"IP before lock", ipThrottle, 49, time.Hour, false},
+		{"IP locked", ipThrottle, 50, time.Hour, true},
+		{"IP long locked", ipThrottle, 1000, time.Hour, true},
+		{"Overflowing shift", noLock, 100, time.Hour, false},
+		{"Huge count", noLock, 1 << 29, time.Hour, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, locked := tt.policy.delay(tt.failures)
+
+			assert.Equal(t, delay, tt.wantDelay)
+			assert.Equal(t, locked, tt.wantLocked)
+		})
+	}
+}
+
+func TestLoginAttemptModelRecordFailure(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	m := LoginAttemptModel{db}
+	ctx := context.Background()
+
+	for i := 1; i < emailThrottle.freeAttempts; i++ {
+		block, err := m.RecordFailure(ctx, "alice@example.com", "192.0.2.1")
+		assert.NilError(t, err)
+		assert.Equal(t, block == nil, true)
+	}
+
+	block, err := m.Check(ctx, "alice@example.com", "192.0.2.1")
+	assert.NilError(t, err)
+	assert.Equal(t, block == nil, true)
+
+	for i := emailThrottle.freeAttempts; i < emailThrottle.lockAfter; i++ {
+		block, err := m.RecordFailure(ctx, "alice@example.com", "192.0.2.1")
+		assert.NilError(t, err)
+		assert.Equal(t, block.Locked, false)
+	}
+
+	block, err = m.RecordFailure(ctx, "alice@example.com", "192.0.2.1")
+	assert.NilError(t, err)
+	assert.Equal(t, block.Locked, true)
+
+	block, err = m.Check(ctx, "alice@example.com", "192.0.2.2")
+	assert.NilError(t, err)
+	assert.Equal(t, block.Locked, true)
+
+	block, err = m.Check(ctx, "bob@example.com", "192.0.2.2")
+	assert.NilError(t, err)
+	assert.Equal(t, block == nil, true)
+
+	err = m.Reset(ctx, "alice@example.com")
+	assert.NilError(t, err)
+
+	block, err = m.Check(ctx, "alice@example.com", "192.0.2.2")
+	assert.NilError(t, err)
+	assert.Equal(t, block == nil, true)
+}
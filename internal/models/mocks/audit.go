@@ -0,0 +1,57 @@
+package mocks
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/models"
+)
+
+// AuditModel keeps events in memory, so that tests can check what a
+// handler recorded.
+type AuditModel struct {
+	mu     sync.Mutex
+	events []*models.AuditEvent
+}
+
+func (m *AuditModel) Record(ctx context.Context, event *models.AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := *event
+	e.Id = len(m.events) + 1
+	e.Created = time.Now()
+	m.events = append(m.events, &e)
+
+	return nil
+}
+
+func (m *AuditModel) List(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := []*models.AuditEvent{}
+
+	for i := len(m.events) - 1; i >= 0; i-- {
+		e := m.events[i]
+
+		switch {
+		case filter.Action != "" && e.Action != filter.Action:
+			continue
+		case filter.ActorId > 0 && e.ActorId != filter.ActorId:
+			continue
+		case !filter.Since.IsZero() && e.Created.Before(filter.Since):
+			continue
+		case !filter.Until.IsZero() && !e.Created.Before(filter.Until):
+			continue
+		}
+
+		events = append(events, e)
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+	}
+
+	return events, nil
+}
@@ -0,0 +1,96 @@
+package mocks
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/models"
+)
+
+var mockCollection = &models.Collection{
+	Id:          1,
+	UserId:      1,
+	Slug:        "runbooks",
+	Title:       "Runbooks",
+	Description: "What to do when things break",
+	Public:      true,
+	Created:     time.Now(),
+}
+
+var mockPrivateCollection = &models.Collection{
+	Id:      2,
+	UserId:  1,
+	Slug:    "drafts",
+	Title:   "Drafts",
+	Public:  false,
+	Created: time.Now(),
+}
+
+// mockLongSlug is a taken slug as long as slugs get.
+var mockLongSlug = strings.Repeat("a", 60)
+
+type CollectionModel struct{}
+
+func (m *CollectionModel) Insert(ctx context.Context, userId int, slug, title, description string, public bool) (int, error) {
+	switch slug {
+	case "runbooks", "drafts", mockLongSlug:
+		return 0, models.ErrDuplicateSlug
+	default:
+		return 3, nil
+	}
+}
+
+func (m *CollectionModel) GetBySlug(ctx context.Context, slug string) (*models.Collection, error) {
+	switch slug {
+	case "runbooks":
+		return mockCollection, nil
+	case "drafts":
+		return mockPrivateCollection, nil
+	default:
+		return nil, models.ErrNoRecord
+	}
+}
+
+func (m *CollectionModel) ByOwner(ctx context.Context, userId int) ([]*models.Collection, error) {
+	if userId != 1 {
+		return []*models.Collection{}, nil
+	}
+	return []*models.Collection{mockPrivateCollection, mockCollection}, nil
+}
+
+func (m *CollectionModel) Update(ctx context.Context, id int, title, description string, public bool) error {
+	return nil
+}
+
+func (m *CollectionModel) Delete(ctx context.Context, id int) error {
+	return nil
+}
+
+// Snippets has the runbooks collection holding alice's public snippet and
+// her private one.
+func (m *CollectionModel) Snippets(ctx context.Context, id int) ([]*models.Snippet, error) {
+	if id != 1 {
+		return []*models.Snippet{}, nil
+	}
+	return []*models.Snippet{mockSnippet, mockPrivateSnippet}, nil
+}
+
+func (m *CollectionModel) AddSnippet(ctx context.Context, id, snippetId int) error {
+	return nil
+}
+
+func (m *CollectionModel) RemoveSnippet(ctx context.Context, id, snippetId int) error {
+	return inMockCollection(id, snippetId)
+}
+
+func (m *CollectionModel) MoveSnippet(ctx context.Context, id, snippetId, userId int, up bool) error {
+	return inMockCollection(id, snippetId)
+}
+
+func inMockCollection(id, snippetId int) error {
+	if id == 1 && (snippetId == 1 || snippetId == 3) {
+		return nil
+	}
+	return models.ErrNoRecord
+}
@@ -0,0 +1,95 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/models"
+)
+
+var mockComment = &models.Comment{
+	Id:           1,
+	SnippetId:    1,
+	UserId:       1,
+	AuthorName:   "Alice Jones",
+	AuthorHandle: "alice",
+	Body:         "What a *lovely* pond.",
+	Created:      time.Now(),
+}
+
+var mockReply = &models.Comment{
+	Id:           2,
+	SnippetId:    1,
+	ParentId:     1,
+	UserId:       4,
+	AuthorName:   "Mike Moderator",
+	AuthorHandle: "mike",
+	Body:         "Where is the frog?",
+	Created:      time.Now(),
+}
+
+// mockHiddenComment was posted by carol on a snippet that alice has since
+// made private.
+var mockHiddenComment = &models.Comment{
+	Id:           3,
+	SnippetId:    3,
+	UserId:       2,
+	AuthorName:   "Carol Smith",
+	AuthorHandle: "carol",
+	Body:         "Can I see too?",
+	Created:      time.Now(),
+}
+
+type CommentModel struct{}
+
+func (m *CommentModel) Insert(ctx context.Context, snippetId, parentId, userId int, body string) (int, error) {
+	return 3, nil
+}
+
+func (m *CommentModel) Get(ctx context.Context, id int) (*models.Comment, error) {
+	switch id {
+	case 1:
+		return mockComment, nil
+	case 2:
+		return mockReply, nil
+	case 3:
+		return mockHiddenComment, nil
+	default:
+		return nil, models.ErrNoRecord
+	}
+}
+
+func (m *CommentModel) Thread(ctx context.Context, snippetId int) ([]*models.Comment, error) {
+	if snippetId != 1 {
+		return []*models.Comment{}, nil
+	}
+
+	comment, reply := *mockComment, *mockReply
+	return models.BuildThread([]*models.Comment{&comment, &reply}), nil
+}
+
+func (m *CommentModel) Counts(ctx context.Context, snippetIds []int) (map[int]int, error) {
+	counts := map[int]int{}
+	for _, id := range snippetIds {
+		if id == 1 {
+			counts[id] = 2
+		}
+	}
+	return counts, nil
+}
+
+func (m *CommentModel) Update(ctx context.Context, id, userId int, body string) error {
+	return m.owned(id, userId)
+}
+
+func (m *CommentModel) Delete(ctx context.Context, id, userId int) error {
+	return m.owned(id, userId)
+}
+
+func (m *CommentModel) owned(id, userId int) error {
+	c, err := m.Get(context.Background(), id)
+	if err != nil || c.UserId != userId {
+		return models.ErrNoRecord
+	}
+	return nil
+}
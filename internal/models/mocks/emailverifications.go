@@ -0,0 +1,25 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/models"
+)
+
+type EmailVerificationModel struct{}
+
+func (m *EmailVerificationModel) New(ctx context.Context, userId int, email string, ttl time.Duration) (string, error) {
+	return "verify-token", nil
+}
+
+func (m *EmailVerificationModel) Consume(ctx context.Context, token string) (int, string, error) {
+	switch token {
+	case "verify-token":
+		return 2, "carol@example.com", nil
+	case "stale-token":
+		return 2, "carol.old@example.com", nil
+	default:
+		return 0, "", models.ErrInvalidToken
+	}
+}
@@ -0,0 +1,24 @@
+package mocks
+
+import (
+	"context"
+
+	"snippetbox.jonnevuorela.com/internal/models"
+)
+
+type IdentityModel struct{}
+
+func (m *IdentityModel) Get(ctx context.Context, issuer, subject string) (int, error) {
+	switch subject {
+	case "alice-subject":
+		return 1, nil
+	case "dave-subject":
+		return 3, nil
+	default:
+		return 0, models.ErrNoRecord
+	}
+}
+
+func (m *IdentityModel) Link(ctx context.Context, userId int, issuer, subject string) error {
+	return nil
+}
@@ -0,0 +1,34 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/models"
+)
+
+type LoginAttemptModel struct{}
+
+func (m *LoginAttemptModel) Check(ctx context.Context, email, ip string) (*models.LoginBlock, error) {
+	switch email {
+	case "locked@example.com":
+		return &models.LoginBlock{Until: time.Now().Add(15 * time.Minute), Locked: true}, nil
+	case "slow@example.com":
+		return &models.LoginBlock{Until: time.Now().Add(8 * time.Second)}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (m *LoginAttemptModel) RecordFailure(ctx context.Context, email, ip string) (*models.LoginBlock, error) {
+	switch email {
+	case "alice@example.com":
+		return &models.LoginBlock{Until: time.Now().Add(15 * time.Minute), Locked: true}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (m *LoginAttemptModel) Reset(ctx context.Context, email string) error {
+	return nil
+}
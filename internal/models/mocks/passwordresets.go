@@ -0,0 +1,23 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/models"
+)
+
+type PasswordResetModel struct{}
+
+func (m *PasswordResetModel) New(ctx context.Context, userId int, ttl time.Duration) (string, error) {
+	return "valid-token", nil
+}
+
+func (m *PasswordResetModel) Consume(ctx context.Context, token string) (int, error) {
+	switch token {
+	case "valid-token":
+		return 1, nil
+	default:
+		return 0, models.ErrInvalidToken
+	}
+}
@@ -1,34 +1,218 @@
 package mocks
 
 import (
+	"context"
 	"time"
 
 	"snippetbox.jonnevuorela.com/internal/models"
 )
 
 var mockSnippet = &models.Snippet{
-	Id:      1,
-	Title:   "An old silet pond",
-	Content: "An old silent pond...",
-	Created: time.Now(),
-	Expires: time.Now(),
+	Id:              1,
+	Title:           "An old silet pond",
+	Content:         "An old silent pond...",
+	Created:         time.Now(),
+	Expires:         time.Now().AddDate(1, 0, 0),
+	UserId:          1,
+	Public:          true,
+	CommentsEnabled: true,
+}
+
+var mockPrivateSnippet = &models.Snippet{
+	Id:              3,
+	Title:           "Private notes",
+	Content:         "Only for Alice",
+	Created:         time.Now(),
+	Expires:         time.Now().Add(12 * time.Hour),
+	UserId:          1,
+	Public:          false,
+	CommentsEnabled: true,
+}
+
+var mockExpiredSnippet = &models.Snippet{
+	Id:              4,
+	Title:           "Old news",
+	Content:         "Nobody reads this any more",
+	Created:         time.Now().AddDate(0, 0, -8),
+	Expires:         time.Now().AddDate(0, 0, -1),
+	UserId:          1,
+	Public:          true,
+	CommentsEnabled: true,
+}
+
+var mockFork = &models.Snippet{
+	Id:         5,
+	Title:      "A newer pond",
+	Content:    "A frog jumps in...",
+	Created:    time.Now(),
+	Expires:    time.Now().AddDate(1, 0, 0),
+	UserId:     4,
+	Public:     true,
+	ForkedFrom: 1,
+}
+
+var mockForkFiles = []*models.SnippetFile{
+	{Filename: "frog.txt", Content: "A frog jumps in..."},
+	{Filename: "splash.sh", Language: "bash", Content: "echo splash"},
+}
+
+var mockMarkdown = &models.Snippet{
+	Id:              6,
+	Title:           "Pond notes",
+	Content:         "# Frogs\n\nThey *jump*.\n\n<script>alert(1)</script>",
+	Created:         time.Now(),
+	Expires:         time.Now().AddDate(1, 0, 0),
+	UserId:          1,
+	Public:          true,
+	CommentsEnabled: true,
+}
+
+var mockMarkdownFiles = []*models.SnippetFile{
+	{Filename: "README.md", Language: "markdown", Content: mockMarkdown.Content},
 }
 
 type SnippetModel struct{}
 
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
+func (m *SnippetModel) Insert(ctx context.Context, userId int, title string, files []*models.SnippetFile, tags []string, expires int, public bool, forkedFrom int) (int, error) {
 	return 2, nil
 }
 
-func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
+// Files has the fork made of two files and snippet 6 of one Markdown file.
+// The other snippets predate files and have none.
+func (m *SnippetModel) Files(ctx context.Context, id int) ([]*models.SnippetFile, error) {
+	switch id {
+	case 5:
+		return mockForkFiles, nil
+	case 6:
+		return mockMarkdownFiles, nil
+	default:
+		return []*models.SnippetFile{}, nil
+	}
+}
+
+// Tags has snippet 1 tagged "haiku" and "pond", and its fork "pond".
+func (m *SnippetModel) Tags(ctx context.Context, id int) ([]string, error) {
+	switch id {
+	case 1:
+		return []string{"haiku", "pond"}, nil
+	case 5:
+		return []string{"pond"}, nil
+	default:
+		return []string{}, nil
+	}
+}
+
+func (m *SnippetModel) Get(ctx context.Context, id int) (*models.Snippet, error) {
 	switch id {
 	case 1:
 		return mockSnippet, nil
+	case 3:
+		return mockPrivateSnippet, nil
+	case 5:
+		return mockFork, nil
+	case 6:
+		return mockMarkdown, nil
 	default:
 		return nil, models.ErrNoRecord
 	}
 }
 
-func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
+func (m *SnippetModel) Forks(ctx context.Context, id int) ([]*models.Snippet, error) {
+	if id == 1 {
+		return []*models.Snippet{mockFork}, nil
+	}
+	return []*models.Snippet{}, nil
+}
+
+func (m *SnippetModel) Latest(ctx context.Context) ([]*models.Snippet, error) {
+	return []*models.Snippet{mockSnippet}, nil
+}
+
+func (m *SnippetModel) MostStarred(ctx context.Context) ([]*models.Snippet, error) {
+	return []*models.Snippet{mockFork, mockSnippet}, nil
+}
+
+func (m *SnippetModel) StarredBy(ctx context.Context, userId int) ([]*models.Snippet, error) {
+	if userId != 1 {
+		return []*models.Snippet{}, nil
+	}
+	return []*models.Snippet{mockFork}, nil
+}
+
+func (m *SnippetModel) PublicByUser(ctx context.Context, userId, limit, offset int) ([]*models.Snippet, error) {
+	if userId != 1 || offset > 0 {
+		return []*models.Snippet{}, nil
+	}
 	return []*models.Snippet{mockSnippet}, nil
 }
+
+func (m *SnippetModel) PublicByTag(ctx context.Context, tag string, limit, offset int) ([]*models.Snippet, error) {
+	if offset > 0 {
+		return []*models.Snippet{}, nil
+	}
+	switch tag {
+	case "haiku":
+		return []*models.Snippet{mockSnippet}, nil
+	case "pond":
+		return []*models.Snippet{mockFork, mockSnippet}, nil
+	default:
+		return []*models.Snippet{}, nil
+	}
+}
+
+func (m *SnippetModel) All(ctx context.Context) ([]*models.Snippet, error) {
+	return []*models.Snippet{mockPrivateSnippet, mockSnippet}, nil
+}
+
+func (m *SnippetModel) Delete(ctx context.Context, id int) error {
+	switch id {
+	case 1, 3:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) ByOwner(ctx context.Context, userId int, sort string) ([]*models.Snippet, error) {
+	if userId != 1 {
+		return []*models.Snippet{}, nil
+	}
+	return []*models.Snippet{mockExpiredSnippet, mockPrivateSnippet, mockSnippet}, nil
+}
+
+func (m *SnippetModel) DeleteByOwner(ctx context.Context, userId int, ids []int) ([]int, error) {
+	deleted := []int{}
+	for _, id := range ids {
+		if ownedCount(userId, []int{id}) > 0 {
+			deleted = append(deleted, id)
+		}
+	}
+	return deleted, nil
+}
+
+func (m *SnippetModel) ExtendByOwner(ctx context.Context, userId int, ids []int, days int) (int, error) {
+	return ownedCount(userId, ids), nil
+}
+
+func (m *SnippetModel) SetPublicByOwner(ctx context.Context, userId int, ids []int, public bool) (int, error) {
+	return ownedCount(userId, ids), nil
+}
+
+func (m *SnippetModel) SetCommentsEnabled(ctx context.Context, userId, id int, enabled bool) error {
+	return nil
+}
+
+func ownedCount(userId int, ids []int) int {
+	if userId != 1 {
+		return 0
+	}
+
+	n := 0
+	for _, id := range ids {
+		switch id {
+		case 1, 3, 4:
+			n++
+		}
+	}
+	return n
+}
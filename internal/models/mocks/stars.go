@@ -0,0 +1,34 @@
+package mocks
+
+import (
+	"context"
+	"time"
+)
+
+// StarModel has alice (user 1) starring the fork, snippet 5, which also has a
+// star from someone else. Snippet 1 has three stars, only one of them recent.
+type StarModel struct{}
+
+func (m *StarModel) Toggle(ctx context.Context, userId, snippetId int) (bool, error) {
+	starred, err := m.Starred(ctx, userId, snippetId)
+	return !starred, err
+}
+
+func (m *StarModel) Starred(ctx context.Context, userId, snippetId int) (bool, error) {
+	return userId == 1 && snippetId == 5, nil
+}
+
+func (m *StarModel) Counts(ctx context.Context, snippetIds []int, since time.Time) (map[int]int, error) {
+	counts := map[int]int{}
+	for _, id := range snippetIds {
+		switch {
+		case id == 1 && since.IsZero():
+			counts[id] = 3
+		case id == 1:
+			counts[id] = 1
+		case id == 5:
+			counts[id] = 2
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,51 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/models"
+)
+
+var mockTwoFactor = &models.TwoFactor{
+	UserId:        3,
+	Created:       time.Now(),
+	RecoveryCodes: 10,
+}
+
+type TwoFactorModel struct{}
+
+func (m *TwoFactorModel) Get(ctx context.Context, userId int) (*models.TwoFactor, error) {
+	switch userId {
+	case 3:
+		return mockTwoFactor, nil
+	default:
+		return nil, models.ErrNoRecord
+	}
+}
+
+func (m *TwoFactorModel) Enable(ctx context.Context, userId int, secret []byte, step int64) ([]string, error) {
+	return []string{"aaaa-bbbb-cccc-dddd", "eeee-ffff-gggg-hhhh"}, nil
+}
+
+func (m *TwoFactorModel) Disable(ctx context.Context, userId int) error {
+	return nil
+}
+
+func (m *TwoFactorModel) Verify(ctx context.Context, userId int, code string) error {
+	switch {
+	case userId == 3 && code == "123456":
+		return nil
+	default:
+		return models.ErrInvalidCredentials
+	}
+}
+
+func (m *TwoFactorModel) UseRecoveryCode(ctx context.Context, userId int, code string) error {
+	switch {
+	case userId == 3 && code == "aaaa-bbbb-cccc-dddd":
+		return nil
+	default:
+		return models.ErrInvalidCredentials
+	}
+}
@@ -1,32 +1,179 @@
 package mocks
 
 import (
+	"context"
+	"time"
+
 	"snippetbox.jonnevuorela.com/internal/models"
 )
 
+var verifiedAt = time.Now()
+
+var mockUser = &models.User{
+	Id:              1,
+	Name:            "Alice Jones",
+	Handle:          "alice",
+	Email:           "alice@example.com",
+	Created:         time.Now(),
+	EmailVerifiedAt: &verifiedAt,
+	Role:            models.RoleUser,
+}
+
+var mockUnverifiedUser = &models.User{
+	Id:      2,
+	Name:    "Carol Smith",
+	Handle:  "carol",
+	Email:   "carol@example.com",
+	Created: time.Now(),
+	Role:    models.RoleUser,
+}
+
+var mockTwoFactorUser = &models.User{
+	Id:              3,
+	Name:            "Dave Brown",
+	Handle:          "dave",
+	Email:           "dave@example.com",
+	Created:         time.Now(),
+	EmailVerifiedAt: &verifiedAt,
+	Role:            models.RoleUser,
+}
+
+var mockModerator = &models.User{
+	Id:              4,
+	Name:            "Mike Moderator",
+	Handle:          "mike",
+	Email:           "mod@example.com",
+	Created:         time.Now(),
+	EmailVerifiedAt: &verifiedAt,
+	Role:            models.RoleModerator,
+}
+
+var mockAdmin = &models.User{
+	Id:              5,
+	Name:            "Ada Admin",
+	Handle:          "ada",
+	Email:           "admin@example.com",
+	Created:         time.Now(),
+	EmailVerifiedAt: &verifiedAt,
+	Role:            models.RoleAdmin,
+}
+
+var mockDisabledUser = &models.User{
+	Id:              6,
+	Name:            "Dan Disabled",
+	Handle:          "dan",
+	Email:           "disabled@example.com",
+	Created:         time.Now(),
+	EmailVerifiedAt: &verifiedAt,
+	Role:            models.RoleUser,
+	DisabledAt:      &verifiedAt,
+}
+
+var mockUsers = []*models.User{mockUser, mockUnverifiedUser, mockTwoFactorUser, mockModerator, mockAdmin, mockDisabledUser}
+
 type UserModel struct{}
 
-func (m *UserModel) Insert(name, email, password string) error {
-	switch email {
-	case "dupe@example.com":
-		return models.ErrDuplicateEmail
-	default:
-		return nil
+func (m *UserModel) Insert(ctx context.Context, name, handle, email, password string) (int, error) {
+	if email == "dupe@example.com" {
+		return 0, models.ErrDuplicateEmail
 	}
+	for _, u := range mockUsers {
+		if u.Handle == handle {
+			return 0, models.ErrDuplicateHandle
+		}
+	}
+	return 2, nil
 }
 
-func (m *UserModel) Authenticate(email, password string) (int, error) {
-	if email == "alice@example.com" && password == "pa$$word" {
+func (m *UserModel) Authenticate(ctx context.Context, email, password string) (int, error) {
+	switch {
+	case email == "alice@example.com" && password == "pa$$word":
 		return 1, nil
+	case email == "carol@example.com" && password == "pa$$word":
+		return 2, nil
+	case email == "dave@example.com" && password == "pa$$word":
+		return 3, nil
+	case email == "mod@example.com" && password == "pa$$word":
+		return 4, nil
+	case email == "admin@example.com" && password == "pa$$word":
+		return 5, nil
+	case email == "disabled@example.com" && password == "pa$$word":
+		return 0, models.ErrAccountDisabled
+	default:
+		return 0, models.ErrInvalidCredentials
 	}
-	return 0, models.ErrInvalidCredentials
 }
 
-func (m *UserModel) Exists(id int) (bool, error) {
+func (m *UserModel) Exists(ctx context.Context, id int) (bool, error) {
 	switch id {
-	case 1:
+	case 1, 2, 3, 4, 5, 6:
 		return true, nil
 	default:
 		return false, nil
 	}
 }
+
+func (m *UserModel) Get(ctx context.Context, id int) (*models.User, error) {
+	for _, u := range mockUsers {
+		if u.Id == id {
+			return u, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *UserModel) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	for _, u := range mockUsers {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *UserModel) GetByHandle(ctx context.Context, handle string) (*models.User, error) {
+	for _, u := range mockUsers {
+		if u.Handle == handle {
+			return u, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *UserModel) UpdateName(ctx context.Context, id int, name string) error {
+	return nil
+}
+
+func (m *UserModel) UpdateEmail(ctx context.Context, id int, email string) error {
+	switch email {
+	case "dupe@example.com":
+		return models.ErrDuplicateEmail
+	default:
+		return nil
+	}
+}
+
+func (m *UserModel) UpdatePassword(ctx context.Context, id int, password string) error {
+	return nil
+}
+
+func (m *UserModel) MarkEmailVerified(ctx context.Context, id int, email string) error {
+	for _, u := range mockUsers {
+		if u.Id == id && u.Email == email {
+			return nil
+		}
+	}
+	return models.ErrNoRecord
+}
+
+func (m *UserModel) List(ctx context.Context) ([]*models.User, error) {
+	return mockUsers, nil
+}
+
+func (m *UserModel) SetRole(ctx context.Context, id int, role models.Role) error {
+	return nil
+}
+
+func (m *UserModel) SetDisabled(ctx context.Context, id int, disabled bool) error {
+	return nil
+}
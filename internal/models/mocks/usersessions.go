@@ -0,0 +1,121 @@
+package mocks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/models"
+)
+
+// UserSessionModel keeps sessions in memory, so that tests can check that a
+// session revoked in one client is logged out in another.
+type UserSessionModel struct {
+	mu       sync.Mutex
+	lastId   int
+	sessions map[string]*models.UserSession
+}
+
+func (m *UserSessionModel) New(ctx context.Context, userId int, ip, userAgent string, remember bool, expires time.Time) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(b)
+
+	if m.sessions == nil {
+		m.sessions = map[string]*models.UserSession{}
+	}
+
+	m.lastId++
+	m.sessions[key] = &models.UserSession{
+		Id:        m.lastId,
+		UserId:    userId,
+		IP:        ip,
+		UserAgent: userAgent,
+		Remember:  remember,
+		Created:   time.Now(),
+		LastSeen:  time.Now(),
+		Expires:   expires,
+	}
+
+	return key, nil
+}
+
+func (m *UserSessionModel) Get(ctx context.Context, key string) (*models.UserSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[key]
+	if !ok || time.Now().After(s.Expires) {
+		return nil, models.ErrNoRecord
+	}
+
+	session := *s
+	return &session, nil
+}
+
+func (m *UserSessionModel) Touch(ctx context.Context, id int, ip string, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sessions {
+		if s.Id == id {
+			s.LastSeen = time.Now()
+			s.IP = ip
+			s.Expires = expires
+		}
+	}
+	return nil
+}
+
+func (m *UserSessionModel) List(ctx context.Context, userId int) ([]*models.UserSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sessions := []*models.UserSession{}
+	for _, s := range m.sessions {
+		if s.UserId == userId && time.Now().Before(s.Expires) {
+			session := *s
+			sessions = append(sessions, &session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Id > sessions[j].Id
+	})
+
+	return sessions, nil
+}
+
+func (m *UserSessionModel) Delete(ctx context.Context, userId, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, s := range m.sessions {
+		if s.UserId == userId && s.Id == id {
+			delete(m.sessions, key)
+			return nil
+		}
+	}
+	return models.ErrNoRecord
+}
+
+func (m *UserSessionModel) DeleteAll(ctx context.Context, userId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, s := range m.sessions {
+		if s.UserId == userId {
+			delete(m.sessions, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+type PasswordResetModel struct {
+	DB *sql.DB
+}
+
+type PasswordResetModelInterface interface {
+	New(ctx context.Context, userId int, ttl time.Duration) (string, error)
+	Consume(ctx context.Context, token string) (int, error)
+}
+
+// newToken returns a random token for the user to hold and the hash of it
+// that is stored, so that a leaked table can't be used to reset passwords.
+func newToken() (string, string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// New creates a reset token for the user that is valid for ttl.
+func (m *PasswordResetModel) New(ctx context.Context, userId int, ttl time.Duration) (string, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO password_resets (hash, user_id, created, expires)
+   VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+
+	ctx, span := startSpan(ctx, "PasswordResetModel.New", stmt)
+	defer span.End()
+
+	_, err = m.DB.ExecContext(ctx, stmt, hash, userId, int(ttl.Seconds()))
+	if err != nil {
+		return "", spanError(span, err)
+	}
+
+	return token, nil
+}
+
+// Consume checks that token is valid and returns the user it was issued
+// for. Every outstanding token for that user is deleted, so a token can only
+// be used once.
+func (m *PasswordResetModel) Consume(ctx context.Context, token string) (int, error) {
+	ctx, span := tracer.Start(ctx, "PasswordResetModel.Consume")
+	defer span.End()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, spanError(span, err)
+	}
+	defer tx.Rollback()
+
+	var userId int
+
+	stmt := "SELECT user_id FROM password_resets WHERE hash = ? AND expires > UTC_TIMESTAMP() FOR UPDATE"
+
+	err = tx.QueryRowContext(ctx, stmt, hashToken(token)).Scan(&userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidToken
+		}
+		return 0, spanError(span, err)
+	}
+
+	stmt = "DELETE FROM password_resets WHERE user_id = ?"
+
+	_, err = tx.ExecContext(ctx, stmt, userId)
+	if err != nil {
+		return 0, spanError(span, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, spanError(span, err)
+	}
+
+	return userId, nil
+}
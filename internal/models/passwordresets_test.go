@@ -0,0 +1,64 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+)
+
+func TestPasswordResetModelConsume(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	m := PasswordResetModel{db}
+	ctx := context.Background()
+
+	token, err := m.New(ctx, 1, time.Hour)
+	assert.NilError(t, err)
+
+	expired, err := m.New(ctx, 1, -time.Hour)
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name       string
+		token      string
+		wantUserId int
+		wantErr    error
+	}{
+		{
+			name:    "Expired token",
+			token:   expired,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:       "Valid token",
+			token:      token,
+			wantUserId: 1,
+		},
+		{
+			name:    "Reused token",
+			token:   token,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "Unknown token",
+			token:   "not-a-token",
+			wantErr: ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userId, err := m.Consume(ctx, tt.token)
+
+			assert.Equal(t, userId, tt.wantUserId)
+			assert.Equal(t, errors.Is(err, tt.wantErr), true)
+		})
+	}
+}
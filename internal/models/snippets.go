@@ -1,17 +1,147 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 )
 
+// Snippet is a piece of text shared by a user. ForkedFrom is the id of the
+// snippet it was forked from, or 0 if it is an original. CommentsEnabled is
+// the owner's choice of whether others can comment on it.
+//
+// A snippet is made of one or more files, which are loaded separately with
+// Files. Content is the first file's content, so that listings don't have to
+// load the files. Its tags are loaded with Tags.
 type Snippet struct {
-	Id      int
-	Title   string
-	Content string
-	Created time.Time
-	Expires time.Time
+	Id              int
+	Title           string
+	Content         string
+	Created         time.Time
+	Expires         time.Time
+	UserId          int
+	Public          bool
+	ForkedFrom      int
+	CommentsEnabled bool
+}
+
+// SnippetFile is one of the files a snippet is made of. Filename can be
+// blank for a snippet with a single file. Language is one of
+// SnippetLanguages, where "" is plain text.
+type SnippetFile struct {
+	Filename string
+	Language string
+	Content  string
+}
+
+// SnippetLanguages are the languages a snippet file can be marked as.
+var SnippetLanguages = []string{
+	"",
+	"bash",
+	"c",
+	"css",
+	"dockerfile",
+	"go",
+	"html",
+	"java",
+	"javascript",
+	"json",
+	"markdown",
+	"python",
+	"ruby",
+	"rust",
+	"sql",
+	"typescript",
+	"yaml",
+}
+
+// Tags are lower case words made of TagChars, up to TagMaxChars long.
+const (
+	TagChars    = "abcdefghijklmnopqrstuvwxyz0123456789-"
+	TagMaxChars = 32
+)
+
+// Snippet statuses, as shown on the owner's dashboard.
+const (
+	SnippetLive     = "live"
+	SnippetExpiring = "expiring"
+	SnippetExpired  = "expired"
+)
+
+// ExpiringSoon is how close to its expiry a snippet has to be to count as
+// expiring.
+const ExpiringSoon = 24 * time.Hour
+
+// Status reports whether the snippet is live, expiring soon or expired.
+func (s *Snippet) Status() string {
+	left := time.Until(s.Expires)
+	switch {
+	case left <= 0:
+		return SnippetExpired
+	case left <= ExpiringSoon:
+		return SnippetExpiring
+	default:
+		return SnippetLive
+	}
+}
+
+// SnippetSorts maps the orders ByOwner can list snippets in to their ORDER
+// BY clauses. An unknown order falls back to newest.
+var SnippetSorts = map[string]string{
+	"newest":  "id DESC",
+	"oldest":  "id ASC",
+	"title":   "title ASC, id DESC",
+	"expires": "expires ASC, id DESC",
+}
+
+// snippetColumns are the columns of a Snippet, in the order scanSnippet reads
+// them. Queries select them from the snippets table aliased as s.
+const snippetColumns = `s.id, s.title, s.content, s.created, s.expires, COALESCE(s.user_id, 0), s.public,
+   COALESCE(s.forked_from, 0), s.comments_enabled`
+
+// rowScanner is a *sql.Row or *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanSnippet reads a snippet selected with snippetColumns.
+func scanSnippet(row rowScanner) (*Snippet, error) {
+	s := &Snippet{}
+	err := row.Scan(&s.Id, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserId, &s.Public, &s.ForkedFrom, &s.CommentsEnabled)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// querySnippets runs a query that selects snippetColumns, in a span called
+// name, and returns the snippets it finds.
+func querySnippets(ctx context.Context, db *sql.DB, name, stmt string, args ...any) ([]*Snippet, error) {
+	ctx, span := startSpan(ctx, name, stmt)
+	defer span.End()
+
+	rows, err := db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+	defer rows.Close()
+	snippets := []*Snippet{}
+
+	for rows.Next() {
+		s, err := scanSnippet(rows)
+		if err != nil {
+			return nil, spanError(span, err)
+		}
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, spanError(span, err)
+	}
+
+	return snippets, nil
 }
 
 type SnippetModel struct {
@@ -19,68 +149,370 @@ type SnippetModel struct {
 }
 
 type SnippetModelInterface interface {
-	Insert(title string, content string, expires int) (int, error)
-	Get(id int) (*Snippet, error)
-	Latest() ([]*Snippet, error)
+	Insert(ctx context.Context, userId int, title string, files []*SnippetFile, tags []string, expires int, public bool, forkedFrom int) (int, error)
+	Get(ctx context.Context, id int) (*Snippet, error)
+	Files(ctx context.Context, id int) ([]*SnippetFile, error)
+	Tags(ctx context.Context, id int) ([]string, error)
+	Forks(ctx context.Context, id int) ([]*Snippet, error)
+	Latest(ctx context.Context) ([]*Snippet, error)
+	MostStarred(ctx context.Context) ([]*Snippet, error)
+	StarredBy(ctx context.Context, userId int) ([]*Snippet, error)
+	PublicByUser(ctx context.Context, userId, limit, offset int) ([]*Snippet, error)
+	PublicByTag(ctx context.Context, tag string, limit, offset int) ([]*Snippet, error)
+	ByOwner(ctx context.Context, userId int, sort string) ([]*Snippet, error)
+	DeleteByOwner(ctx context.Context, userId int, ids []int) ([]int, error)
+	ExtendByOwner(ctx context.Context, userId int, ids []int, days int) (int, error)
+	SetPublicByOwner(ctx context.Context, userId int, ids []int, public bool) (int, error)
+	SetCommentsEnabled(ctx context.Context, userId, id int, enabled bool) error
+	All(ctx context.Context) ([]*Snippet, error)
+	Delete(ctx context.Context, id int) error
 }
 
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
-	stmt := "INSERT INTO snippets (title, content, created, expires) VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))"
+// Insert adds a snippet made of the files, in order, with the tags. There
+// must be at least one file. forkedFrom is the id of the snippet it is a fork of, or 0 for an
+// original.
+func (m *SnippetModel) Insert(ctx context.Context, userId int, title string, files []*SnippetFile, tags []string, expires int, public bool, forkedFrom int) (int, error) {
+	ctx, span := tracer.Start(ctx, "SnippetModel.Insert")
+	defer span.End()
 
-	result, err := m.DB.Exec(stmt, title, content, expires)
+	if len(files) == 0 {
+		return 0, spanError(span, errors.New("models: snippet has no files"))
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, spanError(span, err)
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO snippets (title, content, created, expires, user_id, public, forked_from)
+   VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY), ?, ?, NULLIF(?, 0))`
+
+	result, err := tx.ExecContext(ctx, stmt, title, files[0].Content, expires, userId, public, forkedFrom)
 	if err != nil {
-		return 0, err
+		return 0, spanError(span, err)
 	}
 
 	id, err := result.LastInsertId()
 	if err != nil {
-		return 0, err
+		return 0, spanError(span, err)
+	}
+
+	stmt = `INSERT INTO snippet_files (snippet_id, position, filename, language, content)
+   VALUES(?, ?, ?, ?, ?)`
+
+	for i, f := range files {
+		_, err = tx.ExecContext(ctx, stmt, id, i, f.Filename, f.Language, f.Content)
+		if err != nil {
+			return 0, spanError(span, err)
+		}
+	}
+
+	stmt = `INSERT INTO snippet_tags (snippet_id, tag) VALUES(?, ?)`
+
+	for _, tag := range tags {
+		_, err = tx.ExecContext(ctx, stmt, id, tag)
+		if err != nil {
+			return 0, spanError(span, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, spanError(span, err)
 	}
 
 	return int(id), nil
 }
 
-func (m *SnippetModel) Get(id int) (*Snippet, error) {
-	stmt := "SELECT id, title, content, created, expires FROM snippets WHERE expires > UTC_TIMESTAMP() AND id = ?"
+func (m *SnippetModel) Get(ctx context.Context, id int) (*Snippet, error) {
+	stmt := "SELECT " + snippetColumns + ` FROM snippets s
+   WHERE s.expires > UTC_TIMESTAMP() AND s.id = ?`
 
-	row := m.DB.QueryRow(stmt, id)
+	ctx, span := startSpan(ctx, "SnippetModel.Get", stmt)
+	defer span.End()
 
-	s := &Snippet{}
-
-	err := row.Scan(&s.Id, &s.Title, &s.Content, &s.Created, &s.Expires)
+	s, err := scanSnippet(m.DB.QueryRowContext(ctx, stmt, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
 		} else {
-			return nil, err
+			return nil, spanError(span, err)
 		}
 	}
 
 	return s, nil
 }
 
-func (m *SnippetModel) Latest() ([]*Snippet, error) {
-	stmt := "SELECT id, title, content, created, expires FROM snippets WHERE expires > UTC_TIMESTAMP() ORDER BY id DESC LIMIT 10"
+// Files returns the snippet's files in order. Snippets saved before they
+// could have several files have none, and callers should treat Content as
+// their only file.
+func (m *SnippetModel) Files(ctx context.Context, id int) ([]*SnippetFile, error) {
+	stmt := `SELECT filename, language, content FROM snippet_files
+   WHERE snippet_id = ? ORDER BY position`
+
+	ctx, span := startSpan(ctx, "SnippetModel.Files", stmt)
+	defer span.End()
 
-	rows, err := m.DB.Query(stmt)
+	rows, err := m.DB.QueryContext(ctx, stmt, id)
 	if err != nil {
-		return nil, err
+		return nil, spanError(span, err)
 	}
 	defer rows.Close()
-	snippets := []*Snippet{}
+
+	files := []*SnippetFile{}
 
 	for rows.Next() {
-		s := &Snippet{}
-		err = rows.Scan(&s.Id, &s.Title, &s.Content, &s.Created, &s.Expires)
+		f := &SnippetFile{}
+		err = rows.Scan(&f.Filename, &f.Language, &f.Content)
 		if err != nil {
-			return nil, err
+			return nil, spanError(span, err)
 		}
-		snippets = append(snippets, s)
+		files = append(files, f)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, spanError(span, err)
 	}
 
-	return snippets, nil
+	return files, nil
+}
+
+// Tags returns the snippet's tags in alphabetical order.
+func (m *SnippetModel) Tags(ctx context.Context, id int) ([]string, error) {
+	stmt := `SELECT tag FROM snippet_tags WHERE snippet_id = ? ORDER BY tag`
+
+	ctx, span := startSpan(ctx, "SnippetModel.Tags", stmt)
+	defer span.End()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, id)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+	defer rows.Close()
+
+	tags := []string{}
+
+	for rows.Next() {
+		var tag string
+		err = rows.Scan(&tag)
+		if err != nil {
+			return nil, spanError(span, err)
+		}
+		tags = append(tags, tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, spanError(span, err)
+	}
+
+	return tags, nil
+}
+
+// Forks returns the public, unexpired forks of the snippet, oldest first.
+func (m *SnippetModel) Forks(ctx context.Context, id int) ([]*Snippet, error) {
+	stmt := "SELECT " + snippetColumns + ` FROM snippets s
+   WHERE s.forked_from = ? AND s.public AND s.expires > UTC_TIMESTAMP() ORDER BY s.id`
+
+	return querySnippets(ctx, m.DB, "SnippetModel.Forks", stmt, id)
+}
+
+func (m *SnippetModel) Latest(ctx context.Context) ([]*Snippet, error) {
+	stmt := "SELECT " + snippetColumns + ` FROM snippets s
+   WHERE s.expires > UTC_TIMESTAMP() AND s.public ORDER BY s.id DESC LIMIT 10`
+
+	return querySnippets(ctx, m.DB, "SnippetModel.Latest", stmt)
+}
+
+// MostStarred returns the ten public, unexpired snippets that were starred
+// most in the last TrendingPeriod, most starred first.
+func (m *SnippetModel) MostStarred(ctx context.Context) ([]*Snippet, error) {
+	stmt := "SELECT " + snippetColumns + `
+   FROM snippets s JOIN stars st ON st.snippet_id = s.id AND st.created > ?
+   WHERE s.expires > UTC_TIMESTAMP() AND s.public
+   GROUP BY s.id ORDER BY COUNT(*) DESC, s.id DESC LIMIT 10`
+
+	return querySnippets(ctx, m.DB, "SnippetModel.MostStarred", stmt, time.Now().Add(-TrendingPeriod).UTC())
+}
+
+// StarredBy returns the unexpired snippets the user has starred, most
+// recently starred first. Snippets that have since been made private are left
+// out unless the user owns them.
+func (m *SnippetModel) StarredBy(ctx context.Context, userId int) ([]*Snippet, error) {
+	stmt := "SELECT " + snippetColumns + `
+   FROM stars st JOIN snippets s ON s.id = st.snippet_id
+   WHERE st.user_id = ? AND s.expires > UTC_TIMESTAMP() AND (s.public OR s.user_id = st.user_id)
+   ORDER BY st.created DESC, s.id DESC`
+
+	return querySnippets(ctx, m.DB, "SnippetModel.StarredBy", stmt, userId)
+}
+
+// PublicByUser returns a page of the user's public snippets that haven't
+// expired, newest first.
+func (m *SnippetModel) PublicByUser(ctx context.Context, userId, limit, offset int) ([]*Snippet, error) {
+	stmt := "SELECT " + snippetColumns + ` FROM snippets s
+   WHERE s.user_id = ? AND s.public AND s.expires > UTC_TIMESTAMP() ORDER BY s.id DESC LIMIT ? OFFSET ?`
+
+	return querySnippets(ctx, m.DB, "SnippetModel.PublicByUser", stmt, userId, limit, offset)
+}
+
+// PublicByTag returns a page of the public snippets with the tag that haven't
+// expired, newest first.
+func (m *SnippetModel) PublicByTag(ctx context.Context, tag string, limit, offset int) ([]*Snippet, error) {
+	stmt := "SELECT " + snippetColumns + `
+   FROM snippet_tags t JOIN snippets s ON s.id = t.snippet_id
+   WHERE t.tag = ? AND s.public AND s.expires > UTC_TIMESTAMP() ORDER BY s.id DESC LIMIT ? OFFSET ?`
+
+	return querySnippets(ctx, m.DB, "SnippetModel.PublicByTag", stmt, tag, limit, offset)
+}
+
+// All returns every snippet that hasn't expired, public or private, newest
+// first. It is for moderators.
+func (m *SnippetModel) All(ctx context.Context) ([]*Snippet, error) {
+	stmt := "SELECT " + snippetColumns + ` FROM snippets s
+   WHERE s.expires > UTC_TIMESTAMP() ORDER BY s.id DESC`
+
+	return querySnippets(ctx, m.DB, "SnippetModel.All", stmt)
+}
+
+// Delete deletes a snippet. It returns ErrNoRecord if there is no snippet
+// with the id.
+func (m *SnippetModel) Delete(ctx context.Context, id int) error {
+	stmt := "DELETE FROM snippets WHERE id = ?"
+
+	ctx, span := startSpan(ctx, "SnippetModel.Delete", stmt)
+	defer span.End()
+
+	result, err := m.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// ByOwner returns every snippet the user owns, including private and expired
+// ones, in one of the SnippetSorts orders.
+func (m *SnippetModel) ByOwner(ctx context.Context, userId int, sort string) ([]*Snippet, error) {
+	order, ok := SnippetSorts[sort]
+	if !ok {
+		order = SnippetSorts["newest"]
+	}
+
+	stmt := "SELECT " + snippetColumns + ` FROM snippets s
+   WHERE s.user_id = ? ORDER BY ` + order
+
+	return querySnippets(ctx, m.DB, "SnippetModel.ByOwner", stmt, userId)
+}
+
+// DeleteByOwner deletes those of the snippets that the user owns, and
+// returns the ids of the ones deleted.
+func (m *SnippetModel) DeleteByOwner(ctx context.Context, userId int, ids []int) ([]int, error) {
+	return m.updateByOwner(ctx, "SnippetModel.DeleteByOwner", "DELETE FROM snippets", userId, ids)
+}
+
+// ExtendByOwner pushes back the expiry of those of the snippets that the
+// user owns by days. An expired snippet gets days from now.
+func (m *SnippetModel) ExtendByOwner(ctx context.Context, userId int, ids []int, days int) (int, error) {
+	owned, err := m.updateByOwner(ctx, "SnippetModel.ExtendByOwner", "UPDATE snippets SET expires = DATE_ADD(GREATEST(expires, UTC_TIMESTAMP()), INTERVAL ? DAY)", userId, ids, days)
+	return len(owned), err
+}
+
+// SetPublicByOwner changes the visibility of those of the snippets that the
+// user owns.
+func (m *SnippetModel) SetPublicByOwner(ctx context.Context, userId int, ids []int, public bool) (int, error) {
+	owned, err := m.updateByOwner(ctx, "SnippetModel.SetPublicByOwner", "UPDATE snippets SET public = ?", userId, ids, public)
+	return len(owned), err
+}
+
+// SetCommentsEnabled turns comments on the snippet on or off. It does
+// nothing unless the user owns the snippet.
+func (m *SnippetModel) SetCommentsEnabled(ctx context.Context, userId, id int, enabled bool) error {
+	stmt := "UPDATE snippets SET comments_enabled = ? WHERE id = ? AND user_id = ?"
+
+	ctx, span := startSpan(ctx, "SnippetModel.SetCommentsEnabled", stmt)
+	defer span.End()
+
+	_, err := m.DB.ExecContext(ctx, stmt, enabled, id, userId)
+	if err != nil {
+		return spanError(span, err)
+	}
+	return nil
+}
+
+// updateByOwner runs a bulk statement on those of the given snippets that
+// the user owns, and returns their ids. They are counted whether or not the
+// statement changed them, since MySQL only reports rows that actually
+// changed. args are for the placeholders in stmt, which come before the WHERE
+// clause.
+func (m *SnippetModel) updateByOwner(ctx context.Context, name, stmt string, userId int, ids []int, args ...any) ([]int, error) {
+	if len(ids) == 0 {
+		return []int{}, nil
+	}
+
+	ctx, span := tracer.Start(ctx, name)
+	defer span.End()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+	defer tx.Rollback()
+
+	query := "SELECT id FROM snippets WHERE user_id = ? AND id IN (?" + strings.Repeat(", ?", len(ids)-1) + ") FOR UPDATE"
+
+	queryArgs := []any{userId}
+	for _, id := range ids {
+		queryArgs = append(queryArgs, id)
+	}
+
+	rows, err := tx.QueryContext(ctx, query, queryArgs...)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+	defer rows.Close()
+
+	owned := []int{}
+
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, spanError(span, err)
+		}
+		owned = append(owned, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, spanError(span, err)
+	}
+
+	if len(owned) == 0 {
+		return owned, nil
+	}
+
+	stmt += " WHERE id IN (?" + strings.Repeat(", ?", len(owned)-1) + ")"
+	for _, id := range owned {
+		args = append(args, id)
+	}
+
+	_, err = tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+
+	return owned, nil
 }
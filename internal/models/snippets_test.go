@@ -0,0 +1,154 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+)
+
+func TestSnippetModelByOwner(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	m := SnippetModel{db}
+	ctx := context.Background()
+
+	first, err := m.Insert(ctx, 1, "B first", textFile("content"), nil, 7, true, 0)
+	assert.NilError(t, err)
+	second, err := m.Insert(ctx, 1, "A second", textFile("content"), nil, 1, false, 0)
+	assert.NilError(t, err)
+
+	_, err = db.Exec("UPDATE snippets SET expires = DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 DAY) WHERE id = ?", second)
+	assert.NilError(t, err)
+
+	snippets, err := m.ByOwner(ctx, 1, "title")
+	assert.NilError(t, err)
+	assert.Equal(t, len(snippets), 2)
+	assert.Equal(t, snippets[0].Id, second)
+	assert.Equal(t, snippets[0].Status(), SnippetExpired)
+
+	n, err := m.ExtendByOwner(ctx, 1, []int{second}, 7)
+	assert.NilError(t, err)
+	assert.Equal(t, n, 1)
+
+	// second is already private, but still counts as one of the user's.
+	n, err = m.SetPublicByOwner(ctx, 1, []int{first, second}, false)
+	assert.NilError(t, err)
+	assert.Equal(t, n, 2)
+
+	deleted, err := m.DeleteByOwner(ctx, 2, []int{first})
+	assert.NilError(t, err)
+	assert.Equal(t, len(deleted), 0)
+
+	snippets, err = m.ByOwner(ctx, 1, "newest")
+	assert.NilError(t, err)
+	assert.Equal(t, snippets[0].Id, second)
+	assert.Equal(t, snippets[0].Status(), SnippetLive)
+	assert.Equal(t, snippets[1].Public, false)
+
+	deleted, err = m.DeleteByOwner(ctx, 1, []int{first, second, first + 1000})
+	assert.NilError(t, err)
+	assert.Equal(t, len(deleted), 2)
+}
+
+func TestSnippetModelForks(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	m := SnippetModel{db}
+	ctx := context.Background()
+
+	original, err := m.Insert(ctx, 1, "Original", textFile("content"), nil, 7, true, 0)
+	assert.NilError(t, err)
+	fork, err := m.Insert(ctx, 1, "Fork", textFile("content"), nil, 7, true, original)
+	assert.NilError(t, err)
+	_, err = m.Insert(ctx, 1, "Private fork", textFile("content"), nil, 7, false, original)
+	assert.NilError(t, err)
+
+	s, err := m.Get(ctx, fork)
+	assert.NilError(t, err)
+	assert.Equal(t, s.ForkedFrom, original)
+
+	forks, err := m.Forks(ctx, original)
+	assert.NilError(t, err)
+	assert.Equal(t, len(forks), 1)
+	assert.Equal(t, forks[0].Id, fork)
+
+	err = m.Delete(ctx, original)
+	assert.NilError(t, err)
+
+	s, err = m.Get(ctx, fork)
+	assert.NilError(t, err)
+	assert.Equal(t, s.ForkedFrom, 0)
+}
+
+func TestSnippetModelFiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	m := SnippetModel{db}
+	ctx := context.Background()
+
+	files := []*SnippetFile{
+		{Filename: "Dockerfile", Language: "dockerfile", Content: "FROM golang"},
+		{Filename: "compose.yaml", Language: "yaml", Content: "services: {}"},
+	}
+
+	id, err := m.Insert(ctx, 1, "Deploy", files, nil, 7, true, 0)
+	assert.NilError(t, err)
+
+	s, err := m.Get(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Content, "FROM golang")
+
+	got, err := m.Files(ctx, id)
+	assert.NilError(t, err)
+	assert.Equal(t, len(got), 2)
+	assert.Equal(t, *got[0], *files[0])
+	assert.Equal(t, *got[1], *files[1])
+
+	_, err = m.Insert(ctx, 1, "Empty", nil, nil, 7, true, 0)
+	assert.Equal(t, err != nil, true)
+}
+
+func TestSnippetModelTags(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	m := SnippetModel{db}
+	ctx := context.Background()
+
+	tagged, err := m.Insert(ctx, 1, "Tagged", textFile("content"), []string{"go", "deploy"}, 7, true, 0)
+	assert.NilError(t, err)
+	_, err = m.Insert(ctx, 1, "Private", textFile("content"), []string{"go"}, 7, false, 0)
+	assert.NilError(t, err)
+	_, err = m.Insert(ctx, 1, "Untagged", textFile("content"), nil, 7, true, 0)
+	assert.NilError(t, err)
+
+	tags, err := m.Tags(ctx, tagged)
+	assert.NilError(t, err)
+	assert.Equal(t, strings.Join(tags, ","), "deploy,go")
+
+	snippets, err := m.PublicByTag(ctx, "go", 10, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(snippets), 1)
+	assert.Equal(t, snippets[0].Id, tagged)
+
+	snippets, err = m.PublicByTag(ctx, "rust", 10, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(snippets), 0)
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// TrendingPeriod is how far back stars count towards a snippet being one of
+// the most starred.
+const TrendingPeriod = 7 * 24 * time.Hour
+
+// StarModel records which users have starred which snippets. A user can star
+// a snippet once.
+type StarModel struct {
+	DB *sql.DB
+}
+
+type StarModelInterface interface {
+	Toggle(ctx context.Context, userId, snippetId int) (bool, error)
+	Starred(ctx context.Context, userId, snippetId int) (bool, error)
+	Counts(ctx context.Context, snippetIds []int, since time.Time) (map[int]int, error)
+}
+
+// Toggle stars the snippet for the user, or unstars it if they had already
+// starred it. It reports whether the snippet is now starred.
+func (m *StarModel) Toggle(ctx context.Context, userId, snippetId int) (bool, error) {
+	ctx, span := tracer.Start(ctx, "StarModel.Toggle")
+	defer span.End()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, spanError(span, err)
+	}
+	defer tx.Rollback()
+
+	stmt := "DELETE FROM stars WHERE user_id = ? AND snippet_id = ?"
+
+	result, err := tx.ExecContext(ctx, stmt, userId, snippetId)
+	if err != nil {
+		return false, spanError(span, err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, spanError(span, err)
+	}
+
+	if n == 0 {
+		stmt = "INSERT IGNORE INTO stars (user_id, snippet_id, created) VALUES(?, ?, UTC_TIMESTAMP())"
+
+		_, err = tx.ExecContext(ctx, stmt, userId, snippetId)
+		if err != nil {
+			return false, spanError(span, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, spanError(span, err)
+	}
+
+	return n == 0, nil
+}
+
+// Starred reports whether the user has starred the snippet.
+func (m *StarModel) Starred(ctx context.Context, userId, snippetId int) (bool, error) {
+	stmt := "SELECT EXISTS(SELECT true FROM stars WHERE user_id = ? AND snippet_id = ?)"
+
+	ctx, span := startSpan(ctx, "StarModel.Starred", stmt)
+	defer span.End()
+
+	var starred bool
+
+	err := m.DB.QueryRowContext(ctx, stmt, userId, snippetId).Scan(&starred)
+	if err != nil {
+		return false, spanError(span, err)
+	}
+
+	return starred, nil
+}
+
+// Counts returns how many stars each of the snippets has been given since the
+// given time, or ever if it is zero. Snippets without stars are left out of
+// the map.
+func (m *StarModel) Counts(ctx context.Context, snippetIds []int, since time.Time) (map[int]int, error) {
+	counts := map[int]int{}
+
+	if len(snippetIds) == 0 {
+		return counts, nil
+	}
+
+	stmt := `SELECT snippet_id, COUNT(*) FROM stars
+   WHERE snippet_id IN (?` + strings.Repeat(", ?", len(snippetIds)-1) + `) AND created > ?
+   GROUP BY snippet_id`
+
+	ctx, span := startSpan(ctx, "StarModel.Counts", stmt)
+	defer span.End()
+
+	args := make([]any, len(snippetIds), len(snippetIds)+1)
+	for i, id := range snippetIds {
+		args[i] = id
+	}
+	args = append(args, since.UTC())
+
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, n int
+
+		err = rows.Scan(&id, &n)
+		if err != nil {
+			return nil, spanError(span, err)
+		}
+
+		counts[id] = n
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, spanError(span, err)
+	}
+
+	return counts, nil
+}
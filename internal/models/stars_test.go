@@ -0,0 +1,67 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+)
+
+func TestStarModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	snippets := SnippetModel{db}
+	m := StarModel{db}
+	ctx := context.Background()
+
+	public, err := snippets.Insert(ctx, 1, "Public", textFile("content"), nil, 7, true, 0)
+	assert.NilError(t, err)
+	private, err := snippets.Insert(ctx, 1, "Private", textFile("content"), nil, 7, false, 0)
+	assert.NilError(t, err)
+
+	starred, err := m.Toggle(ctx, 1, public)
+	assert.NilError(t, err)
+	assert.Equal(t, starred, true)
+
+	starred, err = m.Starred(ctx, 1, public)
+	assert.NilError(t, err)
+	assert.Equal(t, starred, true)
+
+	_, err = m.Toggle(ctx, 1, private)
+	assert.NilError(t, err)
+
+	counts, err := m.Counts(ctx, []int{public, private}, time.Time{})
+	assert.NilError(t, err)
+	assert.Equal(t, counts[public], 1)
+	assert.Equal(t, counts[private], 1)
+
+	_, err = db.Exec("UPDATE stars SET created = DATE_SUB(UTC_TIMESTAMP(), INTERVAL 8 DAY) WHERE snippet_id = ?", private)
+	assert.NilError(t, err)
+
+	counts, err = m.Counts(ctx, []int{public, private}, time.Now().Add(-TrendingPeriod))
+	assert.NilError(t, err)
+	assert.Equal(t, counts[public], 1)
+	assert.Equal(t, counts[private], 0)
+
+	s, err := snippets.StarredBy(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(s), 2)
+
+	s, err = snippets.MostStarred(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, len(s), 1)
+	assert.Equal(t, s[0].Id, public)
+
+	starred, err = m.Toggle(ctx, 1, public)
+	assert.NilError(t, err)
+	assert.Equal(t, starred, false)
+
+	s, err = snippets.MostStarred(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, len(s), 0)
+}
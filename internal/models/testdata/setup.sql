@@ -3,24 +3,184 @@ CREATE TABLE snippets (
    title VARCHAR(100),
    content TEXT NOT NULL,
    created DATETIME NOT NULL,
-   expires DATETIME NOT NULL
+   expires DATETIME NOT NULL,
+   user_id INTEGER NULL,
+   public BOOLEAN NOT NULL DEFAULT TRUE,
+   forked_from INTEGER NULL,
+   comments_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+   CONSTRAINT snippets_fk_forked_from FOREIGN KEY (forked_from) REFERENCES snippets(id) ON DELETE SET NULL
 );
 
 CREATE INDEX idx_snippets_created ON snippets(created);
 
+CREATE TABLE snippet_files (
+   snippet_id INTEGER NOT NULL,
+   position INTEGER NOT NULL,
+   filename VARCHAR(255) NOT NULL,
+   language VARCHAR(32) NOT NULL,
+   content TEXT NOT NULL,
+   PRIMARY KEY (snippet_id, position),
+   CONSTRAINT snippet_files_fk_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+);
+
+CREATE TABLE snippet_tags (
+   snippet_id INTEGER NOT NULL,
+   tag VARCHAR(32) NOT NULL,
+   PRIMARY KEY (snippet_id, tag),
+   CONSTRAINT snippet_tags_fk_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_snippet_tags_tag ON snippet_tags(tag, snippet_id);
+
 CREATE TABLE users (
    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
    name VARCHAR(255) NOT NULL,
+   handle VARCHAR(30) NOT NULL,
    email VARCHAR(255) NOT NULL,
    hashed_password CHAR(60) NOT NULL,
-   created DATETIME NOT NULL
+   created DATETIME NOT NULL,
+   email_verified_at DATETIME NULL,
+   role VARCHAR(16) NOT NULL DEFAULT 'user',
+   disabled_at DATETIME NULL
 );
 
 ALTER TABLE users ADD CONSTRAINT users_uc_email UNIQUE (email);
 
-INSERT INTO users (name, email, hashed_password, created) VALUES(
+ALTER TABLE users ADD CONSTRAINT users_uc_handle UNIQUE (handle);
+
+ALTER TABLE snippets ADD CONSTRAINT snippets_fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE TABLE password_resets (
+   hash CHAR(64) NOT NULL PRIMARY KEY,
+   user_id INTEGER NOT NULL,
+   created DATETIME NOT NULL,
+   expires DATETIME NOT NULL,
+   CONSTRAINT password_resets_fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE email_verifications (
+   hash CHAR(64) NOT NULL PRIMARY KEY,
+   user_id INTEGER NOT NULL,
+   email VARCHAR(255) NOT NULL,
+   created DATETIME NOT NULL,
+   expires DATETIME NOT NULL,
+   CONSTRAINT email_verifications_fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE login_failures (
+   scope VARCHAR(5) NOT NULL,
+   subject VARCHAR(255) NOT NULL,
+   failures INTEGER NOT NULL,
+   last_failure DATETIME NOT NULL,
+   locked_until DATETIME NOT NULL,
+   locked BOOLEAN NOT NULL,
+   PRIMARY KEY (scope, subject)
+);
+
+CREATE TABLE two_factor (
+   user_id INTEGER NOT NULL PRIMARY KEY,
+   secret VARBINARY(64) NOT NULL,
+   last_step BIGINT NOT NULL,
+   created DATETIME NOT NULL,
+   CONSTRAINT two_factor_fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE recovery_codes (
+   hash CHAR(64) NOT NULL PRIMARY KEY,
+   user_id INTEGER NOT NULL,
+   used_at DATETIME NULL,
+   CONSTRAINT recovery_codes_fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE user_sessions (
+   id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+   hash CHAR(64) NOT NULL,
+   user_id INTEGER NOT NULL,
+   ip VARCHAR(45) NOT NULL,
+   user_agent VARCHAR(255) NOT NULL,
+   remember BOOLEAN NOT NULL,
+   created DATETIME NOT NULL,
+   last_seen DATETIME NOT NULL,
+   expires DATETIME NOT NULL,
+   CONSTRAINT user_sessions_uc_hash UNIQUE (hash),
+   CONSTRAINT user_sessions_fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE identities (
+   issuer VARCHAR(255) NOT NULL,
+   subject VARCHAR(255) NOT NULL,
+   user_id INTEGER NOT NULL,
+   created DATETIME NOT NULL,
+   PRIMARY KEY (issuer, subject),
+   CONSTRAINT identities_fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE audit_events (
+   id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+   action VARCHAR(32) NOT NULL,
+   actor_id INTEGER NULL,
+   ip VARCHAR(45) NOT NULL,
+   user_agent VARCHAR(255) NOT NULL,
+   details JSON NOT NULL,
+   created DATETIME NOT NULL,
+   CONSTRAINT audit_events_fk_actor FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_audit_events_created ON audit_events(created);
+
+CREATE TABLE comments (
+   id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+   snippet_id INTEGER NOT NULL,
+   parent_id INTEGER NULL,
+   user_id INTEGER NOT NULL,
+   body TEXT NOT NULL,
+   created DATETIME NOT NULL,
+   edited DATETIME NULL,
+   deleted_at DATETIME NULL,
+   CONSTRAINT comments_fk_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE,
+   CONSTRAINT comments_fk_parent FOREIGN KEY (parent_id) REFERENCES comments(id) ON DELETE CASCADE,
+   CONSTRAINT comments_fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_comments_snippet ON comments(snippet_id);
+
+CREATE TABLE stars (
+   user_id INTEGER NOT NULL,
+   snippet_id INTEGER NOT NULL,
+   created DATETIME NOT NULL,
+   PRIMARY KEY (user_id, snippet_id),
+   CONSTRAINT stars_fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+   CONSTRAINT stars_fk_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_stars_snippet_created ON stars(snippet_id, created);
+
+CREATE TABLE collections (
+   id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+   user_id INTEGER NOT NULL,
+   slug VARCHAR(80) NOT NULL,
+   title VARCHAR(100) NOT NULL,
+   description TEXT NOT NULL,
+   public BOOLEAN NOT NULL DEFAULT TRUE,
+   created DATETIME NOT NULL,
+   CONSTRAINT collections_uc_slug UNIQUE (slug),
+   CONSTRAINT collections_fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE collection_snippets (
+   collection_id INTEGER NOT NULL,
+   snippet_id INTEGER NOT NULL,
+   position INTEGER NOT NULL,
+   PRIMARY KEY (collection_id, snippet_id),
+   CONSTRAINT collection_snippets_fk_collection FOREIGN KEY (collection_id) REFERENCES collections(id) ON DELETE CASCADE,
+   CONSTRAINT collection_snippets_fk_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+);
+
+INSERT INTO users (name, handle, email, hashed_password, created, email_verified_at) VALUES(
    'Alice Jones',
+   'alice',
    'alice@example.com',
    '$2a$12$NuTjWXm3KKntReFwyBVHyuf/to.HEwTy.eS206TNfkGfr6HzGJSWG',
-   '2022-01-01 10:00:00'
+   '2022-01-01 10:00:00',
+   '2022-01-01 10:05:00'
 );
@@ -1,3 +1,31 @@
-DROP TABLE users;
+DROP TABLE snippet_files;
+
+DROP TABLE snippet_tags;
+
+DROP TABLE collection_snippets;
+
+DROP TABLE collections;
+
+DROP TABLE stars;
+
+DROP TABLE comments;
+
+DROP TABLE audit_events;
+
+DROP TABLE identities;
+
+DROP TABLE user_sessions;
+
+DROP TABLE recovery_codes;
+
+DROP TABLE two_factor;
+
+DROP TABLE email_verifications;
+
+DROP TABLE password_resets;
+
+DROP TABLE login_failures;
 
 DROP TABLE snippets;
+
+DROP TABLE users;
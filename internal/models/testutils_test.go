@@ -35,3 +35,8 @@ func newTestDB(t *testing.T) *sql.DB {
 
 	return db
 }
+
+// textFile returns the files of a snippet that is a single plain text file.
+func textFile(content string) []*SnippetFile {
+	return []*SnippetFile{{Content: content}}
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("snippetbox.jonnevuorela.com/internal/models")
+
+// startSpan starts a client span for a single query. Callers must end the
+// returned span.
+func startSpan(ctx context.Context, name, stmt string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "mysql"),
+			attribute.String("db.statement", stmt),
+		),
+	)
+}
+
+// spanError marks the span as failed and returns err unchanged so it can be
+// used inline in return statements.
+func spanError(span trace.Span, err error) error {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	return err
+}
@@ -0,0 +1,233 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/totp"
+)
+
+const recoveryCodeCount = 10
+
+// TwoFactor is a user's TOTP enrolment. A user without a row has two-factor
+// authentication turned off.
+type TwoFactor struct {
+	UserId        int
+	Created       time.Time
+	RecoveryCodes int
+}
+
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+type TwoFactorModelInterface interface {
+	Get(ctx context.Context, userId int) (*TwoFactor, error)
+	Enable(ctx context.Context, userId int, secret []byte, step int64) ([]string, error)
+	Disable(ctx context.Context, userId int) error
+	Verify(ctx context.Context, userId int, code string) error
+	UseRecoveryCode(ctx context.Context, userId int, code string) error
+}
+
+// newRecoveryCode returns a random code formatted as four groups of four
+// characters, so that it is easy to copy down by hand.
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	s := strings.ToLower(totp.Encoding.EncodeToString(b))
+	return s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16], nil
+}
+
+// normalizeRecoveryCode strips the separators and case that a user might
+// type a recovery code with, so that only the characters are hashed.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// Get returns the user's enrolment and how many recovery codes they have
+// left, or ErrNoRecord if two-factor authentication is turned off.
+func (m *TwoFactorModel) Get(ctx context.Context, userId int) (*TwoFactor, error) {
+	stmt := `SELECT user_id, created,
+   (SELECT COUNT(*) FROM recovery_codes WHERE user_id = two_factor.user_id AND used_at IS NULL)
+   FROM two_factor WHERE user_id = ?`
+
+	ctx, span := startSpan(ctx, "TwoFactorModel.Get", stmt)
+	defer span.End()
+
+	tf := &TwoFactor{}
+
+	err := m.DB.QueryRowContext(ctx, stmt, userId).Scan(&tf.UserId, &tf.Created, &tf.RecoveryCodes)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, spanError(span, err)
+	}
+
+	return tf, nil
+}
+
+// Enable turns on two-factor authentication with secret, replacing any
+// earlier enrolment, and returns a fresh set of recovery codes. Only hashes
+// of the codes are stored, so this is the only time they can be shown. step
+// is the time step of the code the user confirmed enrolment with, which
+// can't then be used to log in.
+func (m *TwoFactorModel) Enable(ctx context.Context, userId int, secret []byte, step int64) ([]string, error) {
+	ctx, span := tracer.Start(ctx, "TwoFactorModel.Enable")
+	defer span.End()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+	defer tx.Rollback()
+
+	stmt := `INSERT INTO two_factor (user_id, secret, last_step, created)
+   VALUES(?, ?, ?, UTC_TIMESTAMP())
+   ON DUPLICATE KEY UPDATE secret = VALUES(secret), last_step = VALUES(last_step), created = VALUES(created)`
+
+	_, err = tx.ExecContext(ctx, stmt, userId, secret, step)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+
+	stmt = "DELETE FROM recovery_codes WHERE user_id = ?"
+
+	_, err = tx.ExecContext(ctx, stmt, userId)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+
+	stmt = "INSERT INTO recovery_codes (hash, user_id) VALUES(?, ?)"
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i], err = newRecoveryCode()
+		if err != nil {
+			return nil, spanError(span, err)
+		}
+
+		_, err = tx.ExecContext(ctx, stmt, hashToken(normalizeRecoveryCode(codes[i])), userId)
+		if err != nil {
+			return nil, spanError(span, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+
+	return codes, nil
+}
+
+// Disable turns off two-factor authentication and deletes the user's
+// recovery codes.
+func (m *TwoFactorModel) Disable(ctx context.Context, userId int) error {
+	ctx, span := tracer.Start(ctx, "TwoFactorModel.Disable")
+	defer span.End()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return spanError(span, err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userId)
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM two_factor WHERE user_id = ?", userId)
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	return nil
+}
+
+// Verify checks a TOTP code for the user. A code is only accepted once: the
+// step it matched is recorded, and codes from that step or earlier are
+// rejected afterwards. It returns ErrInvalidCredentials if the code doesn't
+// match or has been used.
+func (m *TwoFactorModel) Verify(ctx context.Context, userId int, code string) error {
+	ctx, span := tracer.Start(ctx, "TwoFactorModel.Verify")
+	defer span.End()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return spanError(span, err)
+	}
+	defer tx.Rollback()
+
+	var secret []byte
+	var lastStep int64
+
+	stmt := "SELECT secret, last_step FROM two_factor WHERE user_id = ? FOR UPDATE"
+
+	err = tx.QueryRowContext(ctx, stmt, userId).Scan(&secret, &lastStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidCredentials
+		}
+		return spanError(span, err)
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok || step <= lastStep {
+		return ErrInvalidCredentials
+	}
+
+	stmt = "UPDATE two_factor SET last_step = ? WHERE user_id = ?"
+
+	_, err = tx.ExecContext(ctx, stmt, step, userId)
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks one of the user's recovery codes as used. It returns
+// ErrInvalidCredentials if the code doesn't exist or was used before.
+func (m *TwoFactorModel) UseRecoveryCode(ctx context.Context, userId int, code string) error {
+	stmt := `UPDATE recovery_codes SET used_at = UTC_TIMESTAMP()
+   WHERE user_id = ? AND hash = ? AND used_at IS NULL`
+
+	ctx, span := startSpan(ctx, "TwoFactorModel.UseRecoveryCode", stmt)
+	defer span.End()
+
+	result, err := m.DB.ExecContext(ctx, stmt, userId, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	if n == 0 {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
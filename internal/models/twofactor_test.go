@@ -0,0 +1,132 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+	"snippetbox.jonnevuorela.com/internal/totp"
+)
+
+func TestTwoFactorModelVerify(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	m := TwoFactorModel{db}
+	ctx := context.Background()
+
+	secret := []byte("12345678901234567890")
+	now := totp.Step(time.Now())
+
+	_, err := m.Enable(ctx, 1, secret, now-1)
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name    string
+		userId  int
+		code    string
+		wantErr error
+	}{
+		{
+			name:    "Enrolment code",
+			userId:  1,
+			code:    totp.Code(secret, now-1),
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name:   "Valid code",
+			userId: 1,
+			code:   totp.Code(secret, now),
+		},
+		{
+			name:    "Replayed code",
+			userId:  1,
+			code:    totp.Code(secret, now),
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name:    "Wrong code",
+			userId:  1,
+			code:    "000000",
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name:    "Not enrolled",
+			userId:  2,
+			code:    totp.Code(secret, now),
+			wantErr: ErrInvalidCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.Verify(ctx, tt.userId, tt.code)
+
+			assert.Equal(t, errors.Is(err, tt.wantErr), true)
+		})
+	}
+}
+
+func TestTwoFactorModelUseRecoveryCode(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	m := TwoFactorModel{db}
+	ctx := context.Background()
+
+	codes, err := m.Enable(ctx, 1, []byte("12345678901234567890"), 0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(codes), recoveryCodeCount)
+
+	tests := []struct {
+		name    string
+		code    string
+		wantErr error
+	}{
+		{
+			name: "Valid code",
+			code: codes[0],
+		},
+		{
+			name:    "Reused code",
+			code:    codes[0],
+			wantErr: ErrInvalidCredentials,
+		},
+		{
+			name: "Typed without dashes",
+			code: strings.ToUpper(strings.ReplaceAll(codes[1], "-", "")),
+		},
+		{
+			name:    "Unknown code",
+			code:    "aaaa-bbbb-cccc-dddd",
+			wantErr: ErrInvalidCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.UseRecoveryCode(ctx, 1, tt.code)
+
+			assert.Equal(t, errors.Is(err, tt.wantErr), true)
+		})
+	}
+
+	tf, err := m.Get(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, tf.RecoveryCodes, recoveryCodeCount-2)
+
+	err = m.Disable(ctx, 1)
+	assert.NilError(t, err)
+
+	_, err = m.Get(ctx, 1)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+}
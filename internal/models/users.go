@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"strings"
@@ -10,12 +11,54 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Role decides what a user may do beyond managing their own snippets.
+// Moderators can see and delete anyone's content, and admins can also
+// manage users.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+var Roles = []Role{RoleUser, RoleModerator, RoleAdmin}
+
+var roleRanks = map[Role]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// AtLeast reports whether r has every permission that min has.
+func (r Role) AtLeast(min Role) bool {
+	return roleRanks[r] >= roleRanks[min] && roleRanks[r] > 0
+}
+
+// Valid reports whether r is one of the known roles.
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
 type User struct {
-	Id             int
-	Name           string
-	Email          string
-	HashedPassword []byte
-	Created        time.Time
+	Id              int
+	Name            string
+	Handle          string
+	Email           string
+	HashedPassword  []byte
+	Created         time.Time
+	EmailVerifiedAt *time.Time
+	Role            Role
+	DisabledAt      *time.Time
+}
+
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+func (u *User) Disabled() bool {
+	return u.DisabledAt != nil
 }
 
 type UserModel struct {
@@ -23,49 +66,196 @@ type UserModel struct {
 }
 
 type UserModelInterface interface {
-	Insert(name, email, password string) error
-	Authenticate(email, password string) (int, error)
-	Exists(id int) (bool, error)
+	Insert(ctx context.Context, name, handle, email, password string) (int, error)
+	Authenticate(ctx context.Context, email, password string) (int, error)
+	Exists(ctx context.Context, id int) (bool, error)
+	Get(ctx context.Context, id int) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	GetByHandle(ctx context.Context, handle string) (*User, error)
+	UpdateName(ctx context.Context, id int, name string) error
+	UpdateEmail(ctx context.Context, id int, email string) error
+	UpdatePassword(ctx context.Context, id int, password string) error
+	MarkEmailVerified(ctx context.Context, id int, email string) error
+	List(ctx context.Context) ([]*User, error)
+	SetRole(ctx context.Context, id int, role Role) error
+	SetDisabled(ctx context.Context, id int, disabled bool) error
 }
 
-func (m *UserModel) Authenticate(email, password string) (int, error) {
+// Authenticate checks the user's password. It returns ErrAccountDisabled,
+// rather than ErrInvalidCredentials, only once the password has been
+// checked, so that it doesn't reveal which accounts are disabled.
+func (m *UserModel) Authenticate(ctx context.Context, email, password string) (int, error) {
 	var id int
 	var hashedPassword []byte
+	var disabledAt *time.Time
+
+	stmt := "SELECT id, hashed_password, disabled_at FROM users WHERE email = ?"
 
-	stmt := "SELECT id, hashed_password FROM users WHERE email = ?"
+	ctx, span := startSpan(ctx, "UserModel.Authenticate", stmt)
+	defer span.End()
 
-	err := m.DB.QueryRow(stmt, email).Scan(&id, &hashedPassword)
+	err := m.DB.QueryRowContext(ctx, stmt, email).Scan(&id, &hashedPassword, &disabledAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, ErrInvalidCredentials
 		} else {
-			return 0, err
+			return 0, spanError(span, err)
 		}
 	}
 
+	_, bcryptSpan := tracer.Start(ctx, "bcrypt.CompareHashAndPassword")
 	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	bcryptSpan.End()
 	if err != nil {
 		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
 			return 0, ErrInvalidCredentials
 		} else {
-			return 0, err
+			return 0, spanError(span, err)
 		}
 	}
 
+	if disabledAt != nil {
+		return 0, ErrAccountDisabled
+	}
+
 	return id, nil
 }
 
-func (m *UserModel) Insert(name, email, password string) error {
+// Insert adds a user. It returns ErrDuplicateEmail or ErrDuplicateHandle if
+// another user already has the email address or handle.
+func (m *UserModel) Insert(ctx context.Context, name, handle, email, password string) (int, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	stmt := `INSERT INTO users (name, email, hashed_password, created)
-   VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	stmt := `INSERT INTO users (name, handle, email, hashed_password, created)
+   VALUES(?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	ctx, span := startSpan(ctx, "UserModel.Insert", stmt)
+	defer span.End()
 
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
+	result, err := m.DB.ExecContext(ctx, stmt, name, handle, email, string(hashedPassword))
 
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) && mySQLError.Number == 1062 {
+			if strings.Contains(mySQLError.Message, "users_uc_email") {
+				return 0, ErrDuplicateEmail
+			}
+			if strings.Contains(mySQLError.Message, "users_uc_handle") {
+				return 0, ErrDuplicateHandle
+			}
+		}
+		return 0, spanError(span, err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, spanError(span, err)
+	}
+
+	return int(id), nil
+}
+
+func (m *UserModel) Exists(ctx context.Context, id int) (bool, error) {
+	var exists bool
+
+	stmt := "SELECT EXISTS(SELECT true FROM users WHERE id = ?)"
+
+	ctx, span := startSpan(ctx, "UserModel.Exists", stmt)
+	defer span.End()
+
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&exists)
+	if err != nil {
+		return false, spanError(span, err)
+	}
+	return exists, nil
+}
+
+func (m *UserModel) Get(ctx context.Context, id int) (*User, error) {
+	stmt := "SELECT id, name, handle, email, hashed_password, created, email_verified_at, role, disabled_at FROM users WHERE id = ?"
+
+	ctx, span := startSpan(ctx, "UserModel.Get", stmt)
+	defer span.End()
+
+	u := &User{}
+
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&u.Id, &u.Name, &u.Handle, &u.Email, &u.HashedPassword, &u.Created, &u.EmailVerifiedAt, &u.Role, &u.DisabledAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, spanError(span, err)
+		}
+	}
+
+	return u, nil
+}
+
+func (m *UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	stmt := "SELECT id, name, handle, email, hashed_password, created, email_verified_at, role, disabled_at FROM users WHERE email = ?"
+
+	ctx, span := startSpan(ctx, "UserModel.GetByEmail", stmt)
+	defer span.End()
+
+	u := &User{}
+
+	err := m.DB.QueryRowContext(ctx, stmt, email).Scan(&u.Id, &u.Name, &u.Handle, &u.Email, &u.HashedPassword, &u.Created, &u.EmailVerifiedAt, &u.Role, &u.DisabledAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, spanError(span, err)
+		}
+	}
+
+	return u, nil
+}
+
+// GetByHandle returns the user with the handle, or ErrNoRecord if there
+// isn't one.
+func (m *UserModel) GetByHandle(ctx context.Context, handle string) (*User, error) {
+	stmt := "SELECT id, name, handle, email, hashed_password, created, email_verified_at, role, disabled_at FROM users WHERE handle = ?"
+
+	ctx, span := startSpan(ctx, "UserModel.GetByHandle", stmt)
+	defer span.End()
+
+	u := &User{}
+
+	err := m.DB.QueryRowContext(ctx, stmt, handle).Scan(&u.Id, &u.Name, &u.Handle, &u.Email, &u.HashedPassword, &u.Created, &u.EmailVerifiedAt, &u.Role, &u.DisabledAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, spanError(span, err)
+		}
+	}
+
+	return u, nil
+}
+
+func (m *UserModel) UpdateName(ctx context.Context, id int, name string) error {
+	stmt := "UPDATE users SET name = ? WHERE id = ?"
+
+	ctx, span := startSpan(ctx, "UserModel.UpdateName", stmt)
+	defer span.End()
+
+	_, err := m.DB.ExecContext(ctx, stmt, name, id)
+	if err != nil {
+		return spanError(span, err)
+	}
+	return nil
+}
+
+// UpdateEmail changes the user's address and marks it unverified.
+func (m *UserModel) UpdateEmail(ctx context.Context, id int, email string) error {
+	stmt := "UPDATE users SET email = ?, email_verified_at = NULL WHERE id = ?"
+
+	ctx, span := startSpan(ctx, "UserModel.UpdateEmail", stmt)
+	defer span.End()
+
+	_, err := m.DB.ExecContext(ctx, stmt, email, id)
 	if err != nil {
 		var mySQLError *mysql.MySQLError
 		if errors.As(err, &mySQLError) {
@@ -73,16 +263,113 @@ func (m *UserModel) Insert(name, email, password string) error {
 				return ErrDuplicateEmail
 			}
 		}
-		return nil
+		return spanError(span, err)
 	}
 	return nil
 }
 
-func (m *UserModel) Exists(id int) (bool, error) {
-	var exists bool
+func (m *UserModel) UpdatePassword(ctx context.Context, id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
 
-	stmt := "SELECT EXISTS(SELECT true FROM users WHERE id = ?)"
+	stmt := "UPDATE users SET hashed_password = ? WHERE id = ?"
+
+	ctx, span := startSpan(ctx, "UserModel.UpdatePassword", stmt)
+	defer span.End()
+
+	_, err = m.DB.ExecContext(ctx, stmt, string(hashedPassword), id)
+	if err != nil {
+		return spanError(span, err)
+	}
+	return nil
+}
+
+// MarkEmailVerified records that the user has proved they own email. It
+// returns ErrNoRecord, and changes nothing, if the user's address is no longer
+// email.
+func (m *UserModel) MarkEmailVerified(ctx context.Context, id int, email string) error {
+	stmt := "UPDATE users SET email_verified_at = UTC_TIMESTAMP() WHERE id = ? AND email = ?"
+
+	ctx, span := startSpan(ctx, "UserModel.MarkEmailVerified", stmt)
+	defer span.End()
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, email)
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// List returns every user, oldest first.
+func (m *UserModel) List(ctx context.Context) ([]*User, error) {
+	stmt := `SELECT id, name, handle, email, created, email_verified_at, role, disabled_at FROM users
+   ORDER BY id`
+
+	ctx, span := startSpan(ctx, "UserModel.List", stmt)
+	defer span.End()
+
+	rows, err := m.DB.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		u := &User{}
+
+		err = rows.Scan(&u.Id, &u.Name, &u.Handle, &u.Email, &u.Created, &u.EmailVerifiedAt, &u.Role, &u.DisabledAt)
+		if err != nil {
+			return nil, spanError(span, err)
+		}
+
+		users = append(users, u)
+	}
 
-	err := m.DB.QueryRow(stmt, id).Scan(&exists)
-	return exists, err
+	if err = rows.Err(); err != nil {
+		return nil, spanError(span, err)
+	}
+
+	return users, nil
+}
+
+// SetRole changes the user's role.
+func (m *UserModel) SetRole(ctx context.Context, id int, role Role) error {
+	stmt := "UPDATE users SET role = ? WHERE id = ?"
+
+	ctx, span := startSpan(ctx, "UserModel.SetRole", stmt)
+	defer span.End()
+
+	_, err := m.DB.ExecContext(ctx, stmt, role, id)
+	if err != nil {
+		return spanError(span, err)
+	}
+	return nil
+}
+
+// SetDisabled disables or re-enables the user's account. A disabled user
+// can't log in, and any sessions they have stop working.
+func (m *UserModel) SetDisabled(ctx context.Context, id int, disabled bool) error {
+	stmt := "UPDATE users SET disabled_at = IF(?, COALESCE(disabled_at, UTC_TIMESTAMP()), NULL) WHERE id = ?"
+
+	ctx, span := startSpan(ctx, "UserModel.SetDisabled", stmt)
+	defer span.End()
+
+	_, err := m.DB.ExecContext(ctx, stmt, disabled, id)
+	if err != nil {
+		return spanError(span, err)
+	}
+	return nil
 }
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"snippetbox.jonnevuorela.com/internal/assert"
@@ -39,10 +41,108 @@ func TestUserModelExists(t *testing.T) {
 
 			m := UserModel{db}
 
-			exists, err := m.Exists(tt.userId)
+			exists, err := m.Exists(context.Background(), tt.userId)
 
 			assert.Equal(t, exists, tt.want)
 			assert.NilError(t, err)
 		})
 	}
 }
+
+func TestUserModelSetDisabled(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	m := UserModel{db}
+	ctx := context.Background()
+
+	err := m.SetDisabled(ctx, 1, true)
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  error
+	}{
+		{
+			name:     "Wrong password",
+			password: "wrongPa$$word",
+			wantErr:  ErrInvalidCredentials,
+		},
+		{
+			name:     "Right password",
+			password: "pa$$word",
+			wantErr:  ErrAccountDisabled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.Authenticate(ctx, "alice@example.com", tt.password)
+
+			assert.Equal(t, errors.Is(err, tt.wantErr), true)
+		})
+	}
+
+	user, err := m.Get(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, user.Disabled(), true)
+	assert.Equal(t, user.Role, RoleUser)
+
+	err = m.SetDisabled(ctx, 1, false)
+	assert.NilError(t, err)
+
+	_, err = m.Authenticate(ctx, "alice@example.com", "pa$$word")
+	assert.NilError(t, err)
+}
+
+func TestUserModelInsert(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	m := UserModel{db}
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		handle  string
+		email   string
+		wantErr error
+	}{
+		{
+			name:   "New user",
+			handle: "bob",
+			email:  "bob@example.com",
+		},
+		{
+			name:    "Duplicate email",
+			handle:  "bob2",
+			email:   "alice@example.com",
+			wantErr: ErrDuplicateEmail,
+		},
+		{
+			name:    "Duplicate handle",
+			handle:  "alice",
+			email:   "alice2@example.com",
+			wantErr: ErrDuplicateHandle,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.Insert(ctx, "Bob", tt.handle, tt.email, "pa$$word")
+
+			assert.Equal(t, errors.Is(err, tt.wantErr), true)
+		})
+	}
+
+	user, err := m.GetByHandle(ctx, "bob")
+	assert.NilError(t, err)
+	assert.Equal(t, user.Email, "bob@example.com")
+}
@@ -0,0 +1,192 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// userAgentMaxChars is the size of the user_agent columns.
+const userAgentMaxChars = 255
+
+// truncateChars shortens s to at most n characters. It cuts between runes,
+// and replaces invalid UTF-8, so that the result fits a utf8mb4 VARCHAR(n).
+func truncateChars(s string, n int) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// UserSession records a logged-in session so that the user can see where
+// they are logged in and end sessions remotely. The session itself holds a
+// random key for the record, and only a hash of the key is stored here.
+type UserSession struct {
+	Id        int
+	UserId    int
+	IP        string
+	UserAgent string
+	Remember  bool
+	Created   time.Time
+	LastSeen  time.Time
+	Expires   time.Time
+}
+
+type UserSessionModel struct {
+	DB *sql.DB
+}
+
+type UserSessionModelInterface interface {
+	New(ctx context.Context, userId int, ip, userAgent string, remember bool, expires time.Time) (string, error)
+	Get(ctx context.Context, key string) (*UserSession, error)
+	Touch(ctx context.Context, id int, ip string, expires time.Time) error
+	List(ctx context.Context, userId int) ([]*UserSession, error)
+	Delete(ctx context.Context, userId, id int) error
+	DeleteAll(ctx context.Context, userId int) error
+}
+
+// New records a session for the user and returns the key to keep in it.
+// remember records whether the user asked to stay logged in. Expired records
+// for the user are cleared out at the same time.
+func (m *UserSessionModel) New(ctx context.Context, userId int, ip, userAgent string, remember bool, expires time.Time) (string, error) {
+	ctx, span := tracer.Start(ctx, "UserSessionModel.New")
+	defer span.End()
+
+	key, hash, err := newToken()
+	if err != nil {
+		return "", spanError(span, err)
+	}
+
+	userAgent = truncateChars(userAgent, userAgentMaxChars)
+
+	stmt := "DELETE FROM user_sessions WHERE user_id = ? AND expires <= UTC_TIMESTAMP()"
+
+	_, err = m.DB.ExecContext(ctx, stmt, userId)
+	if err != nil {
+		return "", spanError(span, err)
+	}
+
+	stmt = `INSERT INTO user_sessions (hash, user_id, ip, user_agent, remember, created, last_seen, expires)
+   VALUES(?, ?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP(), ?)`
+
+	_, err = m.DB.ExecContext(ctx, stmt, hash, userId, ip, userAgent, remember, expires.UTC())
+	if err != nil {
+		return "", spanError(span, err)
+	}
+
+	return key, nil
+}
+
+// Get returns the record for a session key, or ErrNoRecord if it has been
+// revoked or has expired.
+func (m *UserSessionModel) Get(ctx context.Context, key string) (*UserSession, error) {
+	stmt := `SELECT id, user_id, ip, user_agent, remember, created, last_seen, expires FROM user_sessions
+   WHERE hash = ? AND expires > UTC_TIMESTAMP()`
+
+	ctx, span := startSpan(ctx, "UserSessionModel.Get", stmt)
+	defer span.End()
+
+	s := &UserSession{}
+
+	err := m.DB.QueryRowContext(ctx, stmt, hashToken(key)).Scan(&s.Id, &s.UserId, &s.IP, &s.UserAgent, &s.Remember, &s.Created, &s.LastSeen, &s.Expires)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, spanError(span, err)
+	}
+
+	return s, nil
+}
+
+// Touch records that the session has just been used from ip, and moves its
+// expiry on to allow for an idle timeout.
+func (m *UserSessionModel) Touch(ctx context.Context, id int, ip string, expires time.Time) error {
+	stmt := "UPDATE user_sessions SET last_seen = UTC_TIMESTAMP(), ip = ?, expires = ? WHERE id = ?"
+
+	ctx, span := startSpan(ctx, "UserSessionModel.Touch", stmt)
+	defer span.End()
+
+	_, err := m.DB.ExecContext(ctx, stmt, ip, expires.UTC(), id)
+	if err != nil {
+		return spanError(span, err)
+	}
+	return nil
+}
+
+// List returns the user's sessions that haven't expired, most recently used
+// first.
+func (m *UserSessionModel) List(ctx context.Context, userId int) ([]*UserSession, error) {
+	stmt := `SELECT id, user_id, ip, user_agent, remember, created, last_seen, expires FROM user_sessions
+   WHERE user_id = ? AND expires > UTC_TIMESTAMP() ORDER BY last_seen DESC, id DESC`
+
+	ctx, span := startSpan(ctx, "UserSessionModel.List", stmt)
+	defer span.End()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userId)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+	defer rows.Close()
+
+	sessions := []*UserSession{}
+
+	for rows.Next() {
+		s := &UserSession{}
+
+		err = rows.Scan(&s.Id, &s.UserId, &s.IP, &s.UserAgent, &s.Remember, &s.Created, &s.LastSeen, &s.Expires)
+		if err != nil {
+			return nil, spanError(span, err)
+		}
+
+		sessions = append(sessions, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, spanError(span, err)
+	}
+
+	return sessions, nil
+}
+
+// Delete revokes one of the user's sessions. It returns ErrNoRecord if the
+// session doesn't exist or belongs to someone else.
+func (m *UserSessionModel) Delete(ctx context.Context, userId, id int) error {
+	stmt := "DELETE FROM user_sessions WHERE user_id = ? AND id = ?"
+
+	ctx, span := startSpan(ctx, "UserSessionModel.Delete", stmt)
+	defer span.End()
+
+	result, err := m.DB.ExecContext(ctx, stmt, userId, id)
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// DeleteAll revokes every one of the user's sessions.
+func (m *UserSessionModel) DeleteAll(ctx context.Context, userId int) error {
+	stmt := "DELETE FROM user_sessions WHERE user_id = ?"
+
+	ctx, span := startSpan(ctx, "UserSessionModel.DeleteAll", stmt)
+	defer span.End()
+
+	_, err := m.DB.ExecContext(ctx, stmt, userId)
+	if err != nil {
+		return spanError(span, err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+)
+
+func TestTruncateChars(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{"Short", "curl/8.0", 255, "curl/8.0"},
+		{"Long", strings.Repeat("a", 300), 255, strings.Repeat("a", 255)},
+		{"Multi-byte", strings.Repeat("a", 254) + "éé", 255, strings.Repeat("a", 254) + "é"},
+		{"Invalid UTF-8", "a\xffb", 255, "a\uFFFDb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateChars(tt.s, tt.n)
+			assert.Equal(t, got, tt.want)
+			assert.Equal(t, utf8.ValidString(got), true)
+		})
+	}
+}
+
+func TestUserSessionModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+
+	m := UserSessionModel{db}
+	ctx := context.Background()
+
+	first, err := m.New(ctx, 1, "192.0.2.1", "Firefox", false, time.Now().Add(time.Hour))
+	assert.NilError(t, err)
+
+	second, err := m.New(ctx, 1, "192.0.2.2", "Safari", true, time.Now().Add(time.Hour))
+	assert.NilError(t, err)
+
+	expired, err := m.New(ctx, 1, "192.0.2.3", "Chrome", false, time.Now().Add(-time.Hour))
+	assert.NilError(t, err)
+
+	s, err := m.Get(ctx, first)
+	assert.NilError(t, err)
+	assert.Equal(t, s.UserId, 1)
+	assert.Equal(t, s.UserAgent, "Firefox")
+	assert.Equal(t, s.Remember, false)
+
+	_, err = m.Get(ctx, expired)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	sessions, err := m.List(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(sessions), 2)
+
+	tests := []struct {
+		name    string
+		userId  int
+		id      int
+		wantErr error
+	}{
+		{
+			name:    "Someone else's session",
+			userId:  2,
+			id:      s.Id,
+			wantErr: ErrNoRecord,
+		},
+		{
+			name:   "Own session",
+			userId: 1,
+			id:     s.Id,
+		},
+		{
+			name:    "Already revoked",
+			userId:  1,
+			id:      s.Id,
+			wantErr: ErrNoRecord,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.Delete(ctx, tt.userId, tt.id)
+
+			assert.Equal(t, errors.Is(err, tt.wantErr), true)
+		})
+	}
+
+	_, err = m.Get(ctx, first)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	err = m.DeleteAll(ctx, 1)
+	assert.NilError(t, err)
+
+	_, err = m.Get(ctx, second)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+}
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+	now := time.Date(2025, 1, 16, 10, 15, 0, 0, time.UTC)
+
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+
+	limit := Limit{Burst: 2, Period: time.Minute}
+	ctx := context.Background()
+
+	tests := []struct {
+		name           string
+		advance        time.Duration
+		key            string
+		wantOK         bool
+		wantRetryAfter time.Duration
+	}{
+		{name: "First token", key: "a", wantOK: true},
+		{name: "Second token", key: "a", wantOK: true},
+		{name: "Empty bucket", key: "a", wantOK: false, wantRetryAfter: 30 * time.Second},
+		{name: "Other key", key: "b", wantOK: true},
+		{name: "Partly refilled", advance: 10 * time.Second, key: "a", wantOK: false, wantRetryAfter: 20 * time.Second},
+		{name: "Refilled", advance: 20 * time.Second, key: "a", wantOK: true},
+		{name: "Empty again", key: "a", wantOK: false, wantRetryAfter: 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+
+			ok, retryAfter, err := s.Take(ctx, tt.key, limit)
+
+			assert.NilError(t, err)
+			assert.Equal(t, ok, tt.wantOK)
+			assert.Equal(t, retryAfter.Round(time.Millisecond), tt.wantRetryAfter)
+		})
+	}
+}
+
+func TestMemoryStoreDisabled(t *testing.T) {
+	s := NewMemoryStore()
+
+	for range 100 {
+		ok, _, err := s.Take(context.Background(), "a", Limit{})
+		assert.NilError(t, err)
+		assert.Equal(t, ok, true)
+	}
+}
+
+func TestLimitSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Limit
+		wantErr bool
+	}{
+		{name: "Valid", value: "5/1m", want: Limit{Burst: 5, Period: time.Minute}},
+		{name: "Off", value: "off", want: Limit{}},
+		{name: "Missing period", value: "5", wantErr: true},
+		{name: "Bad burst", value: "x/1m", wantErr: true},
+		{name: "Bad period", value: "5/soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l Limit
+			err := l.Set(tt.value)
+
+			assert.Equal(t, err != nil, tt.wantErr)
+			assert.Equal(t, l, tt.want)
+		})
+	}
+}
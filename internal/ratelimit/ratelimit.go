@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit describes a token bucket that holds at most Burst tokens and is
+// refilled with Burst tokens every Period. The zero Limit allows everything.
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// Store takes tokens from named buckets. Implementations must be safe for
+// concurrent use. The in-memory store is enough for a single instance; a
+// shared store (such as one backed by MySQL or Redis) is needed when
+// several instances sit behind a load balancer.
+type Store interface {
+	// Take removes one token from the bucket for key. If the bucket is
+	// empty it reports false and how long until a token is available.
+	Take(ctx context.Context, key string, limit Limit) (ok bool, retryAfter time.Duration, err error)
+}
+
+func (l Limit) Enabled() bool {
+	return l.Burst > 0 && l.Period > 0
+}
+
+// rate returns the refill rate in tokens per second.
+func (l Limit) rate() float64 {
+	return float64(l.Burst) / l.Period.Seconds()
+}
+
+func (l Limit) String() string {
+	if !l.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", l.Burst, l.Period)
+}
+
+// Set parses a limit written as "burst/period", for example "5/1m" for five
+// requests a minute, or "off". It implements flag.Value.
+func (l *Limit) Set(s string) error {
+	if s == "off" || s == "0" {
+		*l = Limit{}
+		return nil
+	}
+
+	burst, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return fmt.Errorf("ratelimit: invalid limit %q, want burst/period", s)
+	}
+
+	b, err := strconv.Atoi(burst)
+	if err != nil || b < 0 {
+		return fmt.Errorf("ratelimit: invalid burst %q", burst)
+	}
+
+	p, err := time.ParseDuration(period)
+	if err != nil || p <= 0 {
+		return fmt.Errorf("ratelimit: invalid period %q", period)
+	}
+
+	*l = Limit{Burst: b, Period: p}
+	return nil
+}
@@ -0,0 +1,130 @@
+package totp
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.jonnevuorela.com/internal/assert"
+)
+
+// Test vectors from RFC 4226, appendix D.
+func TestHOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	want := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+
+	for counter, code := range want {
+		assert.Equal(t, hotp(sha1.New, secret, int64(counter), 6), code)
+	}
+}
+
+// Test vectors from RFC 6238, appendix B. The RFC uses eight digits and a
+// seed of the right length for each hash.
+func TestTOTP(t *testing.T) {
+	seeds := map[string]struct {
+		h      func() hash.Hash
+		secret []byte
+	}{
+		"SHA1":   {sha1.New, []byte("12345678901234567890")},
+		"SHA256": {sha256.New, []byte("12345678901234567890123456789012")},
+		"SHA512": {sha512.New, []byte("1234567890123456789012345678901234567890123456789012345678901234")},
+	}
+
+	tests := []struct {
+		unix int64
+		mode string
+		want string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111109, "SHA256", "68084774"},
+		{1111111109, "SHA512", "25091201"},
+		{1111111111, "SHA1", "14050471"},
+		{1111111111, "SHA256", "67062674"},
+		{1111111111, "SHA512", "99943326"},
+		{1234567890, "SHA1", "89005924"},
+		{1234567890, "SHA256", "91819424"},
+		{1234567890, "SHA512", "93441116"},
+		{2000000000, "SHA1", "69279037"},
+		{2000000000, "SHA256", "90698825"},
+		{2000000000, "SHA512", "38618901"},
+		{20000000000, "SHA1", "65353130"},
+		{20000000000, "SHA256", "77737706"},
+		{20000000000, "SHA512", "47863826"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode+"/"+time.Unix(tt.unix, 0).UTC().Format(time.RFC3339), func(t *testing.T) {
+			seed := seeds[tt.mode]
+			step := Step(time.Unix(tt.unix, 0))
+
+			assert.Equal(t, hotp(seed.h, seed.secret, step, 8), tt.want)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{
+			name:     "Current step",
+			code:     Code(secret, Step(now)),
+			wantStep: Step(now),
+			wantOK:   true,
+		},
+		{
+			name:     "Previous step",
+			code:     Code(secret, Step(now)-1),
+			wantStep: Step(now) - 1,
+			wantOK:   true,
+		},
+		{
+			name:     "Next step",
+			code:     Code(secret, Step(now)+1),
+			wantStep: Step(now) + 1,
+			wantOK:   true,
+		},
+		{
+			name: "Too old",
+			code: Code(secret, Step(now)-2),
+		},
+		{
+			name: "Wrong length",
+			code: "12345",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(secret, tt.code, now)
+
+			assert.Equal(t, ok, tt.wantOK)
+			assert.Equal(t, step, tt.wantStep)
+		})
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Snippetbox", "alice@example.com", []byte("12345678901234567890"))
+
+	assert.Equal(t, strings.HasPrefix(uri, "otpauth://totp/Snippetbox:alice@example.com?"), true)
+	assert.StringContains(t, uri, "secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	assert.StringContains(t, uri, "issuer=Snippetbox")
+}
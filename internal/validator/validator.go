@@ -8,6 +8,17 @@ import (
 
 var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+// ReservedHandles can't be chosen as handles, because they name parts of the
+// site or could be mistaken for its staff.
+var ReservedHandles = []string{
+	"about", "account", "admin", "administrator", "api", "help", "login",
+	"logout", "moderator", "root", "settings", "signup", "snippet",
+	"snippetbox", "static", "support", "system", "user",
+}
+
+// HandleChars are the characters a handle may contain.
+const HandleChars = "abcdefghijklmnopqrstuvwxyz0123456789_-"
+
 type Validator struct {
 	NonFieldErrors []string
 	FieldErrors    map[string]string
@@ -22,6 +33,20 @@ func PermittedValue[T comparable](value T, permittedValues ...T) bool {
 	return false
 }
 
+func NotPermittedValue[T comparable](value T, forbiddenValues ...T) bool {
+	return !PermittedValue(value, forbiddenValues...)
+}
+
+// AllowedChars reports whether value contains only characters from chars.
+func AllowedChars(value, chars string) bool {
+	for _, r := range value {
+		if !strings.ContainsRune(chars, r) {
+			return false
+		}
+	}
+	return true
+}
+
 func MinChars(value string, n int) bool {
 	return utf8.RuneCountInString(value) >= n
 }